@@ -112,10 +112,12 @@ func (ebs *endpointBridgeStorage) ReadOnly() bool {
 }
 
 func callAPI(ebr *EndpointBridgeRequest) (record.Record, error) {
-	// Add API prefix to path.
-	requestURL := path.Join(apiV1Path, ebr.Path)
+	// Add API prefix to path. Paths may start with the API version.
+	version, endpointPath := splitAPIVersion(strings.TrimPrefix(ebr.Path, "/"))
+	versionPath := apiVersionPath(version)
+	requestURL := path.Join(versionPath, endpointPath)
 	// Check if path is correct. (Defense in depth)
-	if !strings.HasPrefix(requestURL, apiV1Path) {
+	if !strings.HasPrefix(requestURL, versionPath) {
 		return nil, fmt.Errorf("bridged request for %q violates scope", ebr.Path)
 	}
 
@@ -0,0 +1,423 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/rng"
+)
+
+const (
+	apiKeysDatabaseName = "apikeys"
+	apiKeysKeyPrefix    = apiKeysDatabaseName + ":keys/"
+
+	// apiKeyLastUsedInterval defines how often the last used time of a managed
+	// API key is saved.
+	apiKeyLastUsedInterval = time.Minute
+)
+
+var (
+	apiKeysDB = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	managedAPIKeys     = make(map[string]*ManagedAPIKey) // Key is the hash.
+	managedAPIKeysLock sync.Mutex
+
+	// ErrAPIKeyNotFound is returned when a managed API key does not exist.
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+// APIKeyInfo holds the public information of a managed API key.
+type APIKeyInfo struct {
+	// ID is the public identifier of the API key.
+	ID string
+	// Label is a human readable label of the API key.
+	Label string
+	// Read is the read permission granted by the API key.
+	Read Permission
+	// Write is the write permission granted by the API key.
+	Write Permission
+	// Scopes optionally limits the API key to endpoints whose path starts with
+	// one of the scopes.
+	Scopes []string `json:",omitempty"`
+	// Created is the time the API key was created.
+	Created time.Time
+	// ValidUntil optionally is the time the API key expires.
+	ValidUntil *time.Time `json:",omitempty"`
+	// LastUsed is the time the API key was last used, with a precision of
+	// about a minute.
+	LastUsed *time.Time `json:",omitempty"`
+}
+
+// ManagedAPIKey is an API key that is managed through the API and stored in
+// the database. Only a hash of the key itself is stored.
+type ManagedAPIKey struct {
+	record.Base
+	sync.Mutex
+
+	APIKeyInfo
+	Hash string
+}
+
+// APIKeyRequest is the request to create a managed API key.
+type APIKeyRequest struct {
+	// Label is a human readable label of the API key.
+	Label string
+	// Read is the read permission. One of "anyone", "user" and "admin".
+	Read string
+	// Write is the write permission. One of "anyone", "user" and "admin".
+	Write string
+	// Scopes optionally limits the API key to endpoints whose path starts with
+	// one of the scopes, eg. "config/".
+	Scopes []string
+	// ValidUntil optionally is the time the API key expires.
+	ValidUntil *time.Time
+}
+
+// APIKeyResponse is the response to creating or rotating a managed API key.
+// It is the only time the key itself is available.
+type APIKeyResponse struct {
+	Key string
+	APIKeyInfo
+}
+
+func registerAPIKeysDB() error {
+	if _, err := database.Register(&database.Database{
+		Name:        apiKeysDatabaseName,
+		Description: "Managed API keys and sessions",
+		StorageType: "bbolt",
+	}); err != nil {
+		return err
+	}
+
+	// Managed API keys and sessions may only be accessed through the API
+	// endpoints.
+	RequireDatabasePermissions(apiKeysDatabaseName+":", PermitSelf, PermitSelf)
+
+	return loadManagedAPIKeys()
+}
+
+func loadManagedAPIKeys() error {
+	it, err := apiKeysDB.Query(query.New(apiKeysKeyPrefix))
+	if err != nil {
+		return err
+	}
+
+	managedAPIKeysLock.Lock()
+	defer managedAPIKeysLock.Unlock()
+
+	for r := range it.Next {
+		apiKey, err := ensureManagedAPIKey(r)
+		if err != nil {
+			log.Warningf("api: failed to load api key %s: %s", r.Key(), err)
+			continue
+		}
+		managedAPIKeys[apiKey.Hash] = apiKey
+	}
+	return it.Err()
+}
+
+func ensureManagedAPIKey(r record.Record) (*ManagedAPIKey, error) {
+	// Unwrap record if it's wrapped.
+	if r.IsWrapped() {
+		apiKey := &ManagedAPIKey{}
+		if err := record.Unwrap(r, apiKey); err != nil {
+			return nil, err
+		}
+		return apiKey, nil
+	}
+
+	// Or adjust type.
+	apiKey, ok := r.(*ManagedAPIKey)
+	if !ok {
+		return nil, fmt.Errorf("record not of type *ManagedAPIKey, but %T", r)
+	}
+	return apiKey, nil
+}
+
+// checkManagedAPIKey returns the token of the managed API key, if it exists.
+func checkManagedAPIKey(key string) *AuthToken {
+	managedAPIKeysLock.Lock()
+	apiKey, ok := managedAPIKeys[hashAPIKey(key)]
+	managedAPIKeysLock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	apiKey.Lock()
+	defer apiKey.Unlock()
+
+	// Expired API keys are not used.
+	now := time.Now()
+	if apiKey.ValidUntil != nil && now.After(*apiKey.ValidUntil) {
+		return nil
+	}
+
+	// Update the last used time, if it is outdated.
+	if apiKey.LastUsed == nil || now.Sub(*apiKey.LastUsed) > apiKeyLastUsedInterval {
+		apiKey.LastUsed = &now
+		module.StartWorker("save api key", func(_ context.Context) error {
+			return saveManagedAPIKey(apiKey)
+		})
+	}
+
+	return &AuthToken{
+		Read:       apiKey.Read,
+		Write:      apiKey.Write,
+		ValidUntil: apiKey.ValidUntil,
+		Identity:   "api-key:" + apiKey.ID,
+		Scopes:     apiKey.Scopes,
+	}
+}
+
+// saveManagedAPIKey saves the managed API key, unless it was revoked in the
+// meantime, as it would otherwise be restored.
+func saveManagedAPIKey(apiKey *ManagedAPIKey) error {
+	managedAPIKeysLock.Lock()
+	defer managedAPIKeysLock.Unlock()
+
+	apiKey.Lock()
+	hash := apiKey.Hash
+	apiKey.Unlock()
+	if managedAPIKeys[hash] != apiKey {
+		return nil
+	}
+
+	return apiKeysDB.Put(apiKey)
+}
+
+// newAPIKey returns a new random API key and its hash.
+func newAPIKey() (key, hash string, err error) {
+	secret, err := rng.Bytes(32) // 256 bit
+	if err != nil {
+		return "", "", err
+	}
+	key = base64.RawURLEncoding.EncodeToString(secret)
+	return key, hashAPIKey(key), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func registerAPIKeyEndpoints() error {
+	if err := RegisterEndpoint(Endpoint{
+		Path:         "apikeys",
+		Read:         PermitAdmin,
+		StructFunc:   listAPIKeys,
+		ResponseType: []APIKeyInfo{},
+		Name:         "List API Keys",
+		Description:  "Returns all managed API keys, without the keys themselves.",
+	}); err != nil {
+		return err
+	}
+
+	if err := RegisterEndpoint(Endpoint{
+		Path:         "apikeys/create",
+		Write:        PermitAdmin,
+		StructFunc:   createAPIKey,
+		RequestType:  APIKeyRequest{},
+		ResponseType: APIKeyResponse{},
+		Name:         "Create API Key",
+		Description:  "Creates a new managed API key and returns it. The key is not available later.",
+	}); err != nil {
+		return err
+	}
+
+	if err := RegisterEndpoint(Endpoint{
+		Path:  "apikeys/{id:[0-9a-f]+}/rotate",
+		Write: PermitAdmin,
+		Parameters: []Parameter{{
+			Method:      http.MethodPost,
+			Field:       "id",
+			Type:        ParamTypeString,
+			Description: "ID of the API key.",
+		}},
+		StructFunc:   rotateAPIKey,
+		ResponseType: APIKeyResponse{},
+		Name:         "Rotate API Key",
+		Description:  "Replaces the key of a managed API key and returns it. The previous key stops working immediately.",
+	}); err != nil {
+		return err
+	}
+
+	if err := RegisterEndpoint(Endpoint{
+		Path:  "apikeys/{id:[0-9a-f]+}/revoke",
+		Write: PermitAdmin,
+		Parameters: []Parameter{{
+			Method:      http.MethodPost,
+			Field:       "id",
+			Type:        ParamTypeString,
+			Description: "ID of the API key.",
+		}},
+		ActionFunc:  revokeAPIKey,
+		Name:        "Revoke API Key",
+		Description: "Deletes a managed API key.",
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func listAPIKeys(_ *Request) (i interface{}, err error) {
+	managedAPIKeysLock.Lock()
+	defer managedAPIKeysLock.Unlock()
+
+	infos := make([]APIKeyInfo, 0, len(managedAPIKeys))
+	for _, apiKey := range managedAPIKeys {
+		apiKey.Lock()
+		infos = append(infos, apiKey.APIKeyInfo)
+		apiKey.Unlock()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.Before(infos[j].Created)
+	})
+
+	return infos, nil
+}
+
+func createAPIKey(ar *Request) (i interface{}, err error) {
+	// Parse request.
+	req := &APIKeyRequest{}
+	if err := json.Unmarshal(ar.InputData, req); err != nil {
+		return nil, ErrorWithStatus(fmt.Errorf("failed to parse request: %w", err), http.StatusBadRequest)
+	}
+	info := APIKeyInfo{
+		Label:      req.Label,
+		Created:    time.Now(),
+		ValidUntil: req.ValidUntil,
+	}
+	info.Read, err = parseAPIPermission(req.Read)
+	if err != nil {
+		return nil, ErrorWithStatus(err, http.StatusBadRequest)
+	}
+	info.Write, err = parseAPIPermission(req.Write)
+	if err != nil {
+		return nil, ErrorWithStatus(err, http.StatusBadRequest)
+	}
+	for _, scope := range req.Scopes {
+		scope = strings.TrimPrefix(strings.TrimSpace(scope), "/")
+		if scope == "" {
+			return nil, ErrorWithStatus(errors.New("empty scope"), http.StatusBadRequest)
+		}
+		info.Scopes = append(info.Scopes, scope)
+	}
+	if info.ValidUntil != nil && time.Now().After(*info.ValidUntil) {
+		return nil, ErrorWithStatus(errors.New("expiry is in the past"), http.StatusBadRequest)
+	}
+
+	// Generate ID and key.
+	id, err := rng.Bytes(8)
+	if err != nil {
+		return nil, err
+	}
+	info.ID = hex.EncodeToString(id)
+	key, hash, err := newAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Save API key.
+	apiKey := &ManagedAPIKey{
+		APIKeyInfo: info,
+		Hash:       hash,
+	}
+	apiKey.SetKey(apiKeysKeyPrefix + info.ID)
+	if err := apiKeysDB.Put(apiKey); err != nil {
+		return nil, err
+	}
+
+	managedAPIKeysLock.Lock()
+	defer managedAPIKeysLock.Unlock()
+	managedAPIKeys[hash] = apiKey
+
+	log.Infof("api: created api key %s (%s)", info.ID, info.Label)
+	return &APIKeyResponse{
+		Key:        key,
+		APIKeyInfo: info,
+	}, nil
+}
+
+func rotateAPIKey(ar *Request) (i interface{}, err error) {
+	managedAPIKeysLock.Lock()
+	defer managedAPIKeysLock.Unlock()
+
+	apiKey := getManagedAPIKey(ar.Params.String("id"))
+	if apiKey == nil {
+		return nil, ErrorWithStatus(ErrAPIKeyNotFound, http.StatusNotFound)
+	}
+
+	key, hash, err := newAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// Save API key with new hash.
+	apiKey.Lock()
+	oldHash := apiKey.Hash
+	apiKey.Hash = hash
+	info := apiKey.APIKeyInfo
+	apiKey.Unlock()
+	if err := apiKeysDB.Put(apiKey); err != nil {
+		apiKey.Lock()
+		apiKey.Hash = oldHash
+		apiKey.Unlock()
+		return nil, err
+	}
+
+	delete(managedAPIKeys, oldHash)
+	managedAPIKeys[hash] = apiKey
+
+	log.Infof("api: rotated api key %s (%s)", info.ID, info.Label)
+	return &APIKeyResponse{
+		Key:        key,
+		APIKeyInfo: info,
+	}, nil
+}
+
+func revokeAPIKey(ar *Request) (msg string, err error) {
+	managedAPIKeysLock.Lock()
+	defer managedAPIKeysLock.Unlock()
+
+	apiKey := getManagedAPIKey(ar.Params.String("id"))
+	if apiKey == nil {
+		return "", ErrorWithStatus(ErrAPIKeyNotFound, http.StatusNotFound)
+	}
+
+	if err := apiKeysDB.Delete(apiKey.Key()); err != nil {
+		return "", err
+	}
+	delete(managedAPIKeys, apiKey.Hash)
+
+	log.Infof("api: revoked api key %s (%s)", apiKey.ID, apiKey.Label)
+	return "API key revoked.", nil
+}
+
+// getManagedAPIKey returns the managed API key with the given ID.
+// The managed API keys lock must be held.
+func getManagedAPIKey(id string) *ManagedAPIKey {
+	for _, apiKey := range managedAPIKeys {
+		if apiKey.ID == id {
+			return apiKey
+		}
+	}
+	return nil
+}
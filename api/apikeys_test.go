@@ -0,0 +1,119 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/database"
+)
+
+func TestManagedAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+
+	for _, path := range []string{"test/apikeys/scoped", "test/apikeys-other"} {
+		require.NoError(t, RegisterEndpoint(Endpoint{
+			Path: path,
+			Read: PermitUser,
+			ActionFunc: func(_ *Request) (msg string, err error) {
+				return successMsg, nil
+			},
+		}))
+	}
+
+	validKey := func(key string) bool {
+		r := httptest.NewRequest(http.MethodGet, apiV1Path, nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		return checkAPIKey(r) != nil
+	}
+	request := func(key, path string) int {
+		r := httptest.NewRequest(http.MethodGet, apiV1Path+path, nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Create API key.
+	i, err := createAPIKey(&Request{
+		InputData: []byte(`{"Label":"test","Read":"user","Scopes":["test/apikeys/"]}`),
+	})
+	require.NoError(t, err)
+	created, ok := i.(*APIKeyResponse)
+	require.True(t, ok)
+	assert.Equal(t, PermitUser, created.Read)
+	assert.Equal(t, PermitAnyone, created.Write)
+
+	// The key only grants access to endpoints in scope.
+	assert.Equal(t, http.StatusOK, request(created.Key, "test/apikeys/scoped"))
+	assert.Equal(t, http.StatusForbidden, request(created.Key, "test/apikeys-other"))
+
+	// The key is listed with its last use.
+	i, err = listAPIKeys(nil)
+	require.NoError(t, err)
+	infos, ok := i.([]APIKeyInfo)
+	require.True(t, ok)
+	var listed bool
+	for _, info := range infos {
+		if info.ID == created.ID {
+			listed = true
+			assert.NotNil(t, info.LastUsed)
+		}
+	}
+	assert.True(t, listed)
+
+	// Rotating the key invalidates the previous key.
+	i, err = rotateAPIKey(&Request{Params: ParameterValues{"id": created.ID}})
+	require.NoError(t, err)
+	rotated, ok := i.(*APIKeyResponse)
+	require.True(t, ok)
+	assert.Equal(t, created.ID, rotated.ID)
+	assert.False(t, validKey(created.Key))
+	assert.True(t, validKey(rotated.Key))
+
+	// Revoked keys do not grant access anymore.
+	_, err = revokeAPIKey(&Request{Params: ParameterValues{"id": created.ID}})
+	require.NoError(t, err)
+	assert.False(t, validKey(rotated.Key))
+	_, err = revokeAPIKey(&Request{Params: ParameterValues{"id": created.ID}})
+	assert.ErrorIs(t, err, ErrAPIKeyNotFound)
+
+	// Revoked keys are not saved again by pending updates.
+	revoked := &ManagedAPIKey{APIKeyInfo: APIKeyInfo{ID: created.ID}, Hash: hashAPIKey(rotated.Key)}
+	revoked.SetKey(apiKeysKeyPrefix + created.ID)
+	require.NoError(t, saveManagedAPIKey(revoked))
+	_, err = apiKeysDB.Get(apiKeysKeyPrefix + created.ID)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	// Expired keys do not grant access and are not updated.
+	i, err = createAPIKey(&Request{InputData: []byte(`{"Label":"expired","Read":"user"}`)})
+	require.NoError(t, err)
+	expired, ok := i.(*APIKeyResponse)
+	require.True(t, ok)
+	defer func() {
+		_, _ = revokeAPIKey(&Request{Params: ParameterValues{"id": expired.ID}})
+	}()
+	managedAPIKeysLock.Lock()
+	expiredKey := managedAPIKeys[hashAPIKey(expired.Key)]
+	managedAPIKeysLock.Unlock()
+	expiredKey.Lock()
+	past := time.Now().Add(-time.Minute)
+	expiredKey.ValidUntil = &past
+	expiredKey.Unlock()
+	assert.False(t, validKey(expired.Key))
+	expiredKey.Lock()
+	assert.Nil(t, expiredKey.LastUsed)
+	expiredKey.Unlock()
+
+	// Invalid permissions are rejected.
+	_, err = createAPIKey(&Request{InputData: []byte(`{"Read":"self"}`)})
+	assert.Error(t, err)
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	_ "github.com/safing/portbase/database/storage/bbolt" // Storage for the audit log.
+	"github.com/safing/portbase/log"
+)
+
+const (
+	auditDatabaseName = "audit"
+	auditKeyPrefix    = auditDatabaseName + ":entries/"
+
+	// auditMaxQueryLimit is the maximum amount of entries returned by a query.
+	auditMaxQueryLimit = 1000
+
+	auditProtocolHTTP      = "http"
+	auditProtocolWebsocket = "websocket"
+)
+
+var (
+	auditDB = database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	auditQueue    = make(chan *AuditEntry, 1000)
+	auditSequence atomic.Uint64
+)
+
+// AuditEntry is an entry of the audit log of privileged API actions.
+type AuditEntry struct {
+	record.Base
+	sync.Mutex
+
+	// Time is the time of the action.
+	Time time.Time
+	// Protocol is either "http" or "websocket".
+	Protocol string
+	// Method is the HTTP method or the database operation.
+	Method string
+	// Path is the request path or the database key.
+	Path string
+	// Permission is the write permission role of the client.
+	Permission string `json:",omitempty"`
+	// Identity identifies the API key or session of the client, if known.
+	Identity string `json:",omitempty"`
+	// RemoteAddr is the remote address of the client.
+	RemoteAddr string
+	// Status is the HTTP status code of the response. It is missing if the
+	// connection was hijacked, as the outcome is then unknown.
+	Status int `json:",omitempty"`
+	// Success is whether the action is known to have succeeded.
+	Success bool
+	// Error is the error message of a failed database operation.
+	Error string `json:",omitempty"`
+}
+
+func registerAuditDB() error {
+	if _, err := database.Register(&database.Database{
+		Name:        auditDatabaseName,
+		Description: "Audit log of privileged API actions",
+		StorageType: "bbolt",
+	}); err != nil {
+		return err
+	}
+
+	// Only admins may read the audit log and only the software itself may
+	// write to it.
+	RequireDatabasePermissions(auditDatabaseName+":", PermitAdmin, PermitSelf)
+
+	module.StartServiceWorker("audit log writer", 0, auditWriter)
+	return nil
+}
+
+// auditHTTPRequest records the given write request in the audit log.
+// It must be called after the request was handled.
+func auditHTTPRequest(lrw *LoggingResponseWriter, ar *Request) {
+	// If the handler did not write anything, the server responds with OK.
+	status := lrw.Status
+	if status == 0 && !lrw.hijacked {
+		status = http.StatusOK
+	}
+
+	entry := &AuditEntry{
+		Protocol:   auditProtocolHTTP,
+		Method:     ar.Method,
+		Path:       ar.URL.Path,
+		RemoteAddr: ar.RemoteAddr,
+		Status:     status,
+		Success:    status != 0 && status < http.StatusBadRequest,
+	}
+	if ar.AuthToken != nil {
+		entry.Permission = ar.AuthToken.Write.Role()
+		entry.Identity = ar.AuthToken.Identity
+	}
+	submitAuditEntry(entry)
+}
+
+// auditDatabaseWrite records the given database write operation in the audit
+// log.
+func auditDatabaseWrite(remoteAddr string, token *AuthToken, operation, key string, err error) {
+	entry := &AuditEntry{
+		Protocol:   auditProtocolWebsocket,
+		Method:     operation,
+		Path:       key,
+		RemoteAddr: remoteAddr,
+		Success:    err == nil,
+	}
+	if token != nil {
+		entry.Permission = token.Write.Role()
+		entry.Identity = token.Identity
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	submitAuditEntry(entry)
+}
+
+func submitAuditEntry(entry *AuditEntry) {
+	retention := auditLogRetention()
+	if retention <= 0 {
+		return
+	}
+
+	// Set the key in inverted sortable form, so that the newest entries are
+	// listed first.
+	entry.Time = time.Now()
+	entry.SetKey(fmt.Sprintf(
+		"%s%019d-%020d",
+		auditKeyPrefix,
+		math.MaxInt64-entry.Time.UnixNano(),
+		math.MaxUint64-auditSequence.Add(1),
+	))
+	entry.UpdateMeta()
+	entry.Meta().SetRelativateExpiry(retention * int64(24*time.Hour/time.Second))
+
+	select {
+	case auditQueue <- entry:
+	default:
+		log.Warningf("api: audit log queue is full, dropping entry: %s %s", entry.Method, entry.Path)
+	}
+}
+
+func auditWriter(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entry := <-auditQueue:
+			if err := auditDB.Put(entry); err != nil {
+				log.Warningf("api: failed to save audit log entry: %s", err)
+			}
+		}
+	}
+}
+
+func queryAuditLog(ar *Request) (i interface{}, err error) {
+	since := time.Now().Add(-ar.Params.Duration("since"))
+	limit := ar.Params.Int("limit")
+	switch {
+	case limit < 1:
+		return nil, ErrorWithStatus(errors.New("limit must be at least 1"), http.StatusBadRequest)
+	case limit > auditMaxQueryLimit:
+		limit = auditMaxQueryLimit
+	}
+
+	// Filter in the database.
+	var conditions []query.Condition
+	if identity := ar.Params.String("identity"); identity != "" {
+		conditions = append(conditions, query.Where("Identity", query.SameAs, identity))
+	}
+	if pathPrefix := ar.Params.String("path"); pathPrefix != "" {
+		conditions = append(conditions, query.Where("Path", query.StartsWith, pathPrefix))
+	}
+	q := query.New(auditKeyPrefix)
+	if len(conditions) > 0 {
+		q = q.Where(query.And(conditions...))
+	}
+
+	it, err := auditDB.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Cancel()
+
+	// Entries are listed newest first, so stop at the first entry that is too
+	// old or when the limit is reached.
+	entries := make([]*AuditEntry, 0, limit)
+	for r := range it.Next {
+		entry, err := ensureAuditEntry(r)
+		if err != nil {
+			log.Warningf("api: failed to parse audit log entry %s: %s", r.Key(), err)
+			continue
+		}
+		if entry.Time.Before(since) {
+			break
+		}
+		entries = append(entries, entry)
+		if int64(len(entries)) >= limit {
+			break
+		}
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+
+	return entries, nil
+}
+
+func ensureAuditEntry(r record.Record) (*AuditEntry, error) {
+	// Unwrap record if it's wrapped.
+	if r.IsWrapped() {
+		entry := &AuditEntry{}
+		if err := record.Unwrap(r, entry); err != nil {
+			return nil, err
+		}
+		return entry, nil
+	}
+
+	// Or adjust type.
+	entry, ok := r.(*AuditEntry)
+	if !ok {
+		return nil, fmt.Errorf("record not of type *AuditEntry, but %T", r)
+	}
+	return entry, nil
+}
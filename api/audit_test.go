@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+
+	assert.NoError(t, RegisterEndpoint(Endpoint{
+		Path:  "test/audit",
+		Write: PermitAnyone,
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return successMsg, nil
+		},
+	}))
+
+	// Make a write request and a database write.
+	r := httptest.NewRequest(http.MethodPost, apiV1Path+"test/audit", nil)
+	w := httptest.NewRecorder()
+	testHandler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	token := &AuthToken{Read: PermitAdmin, Write: PermitAdmin, Identity: "test-audit"}
+	auditDatabaseWrite("192.0.2.1:1234", token, "delete", "test-audit:a", errors.New("not found"))
+
+	// Query the audit log until the entries were written.
+	query := func(identity, path string) []*AuditEntry {
+		i, err := queryAuditLog(&Request{
+			Params: ParameterValues{
+				"since":    time.Hour,
+				"identity": identity,
+				"path":     path,
+				"limit":    int64(100),
+			},
+		})
+		assert.NoError(t, err)
+		entries, _ := i.([]*AuditEntry)
+		return entries
+	}
+	var httpEntries, dbEntries []*AuditEntry
+	assert.Eventually(t, func() bool {
+		httpEntries = query("", apiV1Path+"test/audit")
+		dbEntries = query("test-audit", "")
+		return len(httpEntries) == 1 && len(dbEntries) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	if assert.Len(t, httpEntries, 1) {
+		entry := httpEntries[0]
+		assert.Equal(t, auditProtocolHTTP, entry.Protocol)
+		assert.Equal(t, http.MethodPost, entry.Method)
+		assert.Equal(t, http.StatusOK, entry.Status)
+		assert.True(t, entry.Success)
+	}
+	if assert.Len(t, dbEntries, 1) {
+		entry := dbEntries[0]
+		assert.Equal(t, auditProtocolWebsocket, entry.Protocol)
+		assert.Equal(t, "delete", entry.Method)
+		assert.Equal(t, "test-audit:a", entry.Path)
+		assert.Equal(t, "Admin", entry.Permission)
+		assert.False(t, entry.Success)
+		assert.Equal(t, "not found", entry.Error)
+	}
+}
+
+func TestAuditLogQueryLimit(t *testing.T) {
+	t.Parallel()
+
+	token := &AuthToken{Read: PermitAdmin, Write: PermitAdmin, Identity: "test-audit-limit"}
+	for _, key := range []string{"a", "b", "c"} {
+		auditDatabaseWrite("192.0.2.1:1234", token, "put", "test-audit-limit:"+key, nil)
+	}
+
+	query := func(limit int64) ([]*AuditEntry, error) {
+		i, err := queryAuditLog(&Request{
+			Params: ParameterValues{
+				"since":    time.Hour,
+				"identity": "test-audit-limit",
+				"path":     "",
+				"limit":    limit,
+			},
+		})
+		entries, _ := i.([]*AuditEntry)
+		return entries, err
+	}
+	assert.Eventually(t, func() bool {
+		entries, err := query(100)
+		return err == nil && len(entries) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The newest entries are returned first, up to the limit.
+	entries, err := query(2)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "test-audit-limit:c", entries[0].Path)
+		assert.Equal(t, "test-audit-limit:b", entries[1].Path)
+	}
+
+	// Invalid limits are rejected.
+	_, err = query(-1)
+	var statusErr HTTPStatusProvider
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusBadRequest, statusErr.HTTPStatus())
+}
+
+func TestAuditLogStatus(t *testing.T) {
+	t.Parallel()
+
+	// Requests that write nothing implicitly succeed, hijacked requests have
+	// an unknown outcome.
+	audit := func(path string, hijack bool) {
+		r := httptest.NewRequest(http.MethodPost, apiV1Path+path, nil)
+		lrw := NewLoggingResponseWriter(&hijackableRecorder{httptest.NewRecorder()}, r)
+		if hijack {
+			c, _, err := lrw.Hijack()
+			require.NoError(t, err)
+			_ = c.Close()
+		}
+		auditHTTPRequest(lrw, &Request{Request: r})
+	}
+	audit("test/status-audit/empty", false)
+	audit("test/status-audit/hijacked", true)
+
+	query := func(path string) []*AuditEntry {
+		i, err := queryAuditLog(&Request{
+			Params: ParameterValues{
+				"since":    time.Hour,
+				"identity": "",
+				"path":     apiV1Path + path,
+				"limit":    int64(100),
+			},
+		})
+		assert.NoError(t, err)
+		entries, _ := i.([]*AuditEntry)
+		return entries
+	}
+	var empty, hijacked []*AuditEntry
+	assert.Eventually(t, func() bool {
+		empty = query("test/status-audit/empty")
+		hijacked = query("test/status-audit/hijacked")
+		return len(empty) == 1 && len(hijacked) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	if assert.Len(t, empty, 1) {
+		assert.Equal(t, http.StatusOK, empty[0].Status)
+		assert.True(t, empty[0].Success)
+	}
+	if assert.Len(t, hijacked, 1) {
+		assert.Equal(t, 0, hijacked[0].Status)
+		assert.False(t, hijacked[0].Success)
+	}
+}
+
+// hijackableRecorder is a response recorder that can be hijacked.
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, other := net.Pipe()
+	_ = other.Close()
+	return c, bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c)), nil
+}
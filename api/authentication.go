@@ -2,7 +2,8 @@
 
 import (
 	"context"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -15,13 +16,6 @@
 
 	"github.com/safing/portbase/config"
 	"github.com/safing/portbase/log"
-	"github.com/safing/portbase/modules"
-	"github.com/safing/portbase/rng"
-)
-
-const (
-	sessionCookieName = "Portmaster-API-Token"
-	sessionCookieTTL  = 5 * time.Minute
 )
 
 var (
@@ -31,9 +25,6 @@
 	authFnSet = abool.New()
 	authFn    AuthenticatorFunc
 
-	sessions     = make(map[string]*session)
-	sessionsLock sync.Mutex
-
 	// ErrAPIAccessDeniedMessage should be wrapped by errors returned by
 	// AuthenticatorFunc in order to signify a blocked request, including a error
 	// message for the user. This is an empty message on purpose, as to allow the
@@ -87,29 +78,15 @@ type AuthToken struct {
 	Read       Permission
 	Write      Permission
 	ValidUntil *time.Time
-}
-
-type session struct {
-	sync.Mutex
-
-	token      *AuthToken
-	validUntil time.Time
-}
 
-// Expired returns whether the session has expired.
-func (sess *session) Expired() bool {
-	sess.Lock()
-	defer sess.Unlock()
+	// Identity optionally identifies the holder of the token, such as an API
+	// key or a session. It is used for rate limiting and the audit log and
+	// must not contain any secrets.
+	Identity string
 
-	return time.Now().After(sess.validUntil)
-}
-
-// Refresh refreshes the validity of the session with the given TTL.
-func (sess *session) Refresh(ttl time.Duration) {
-	sess.Lock()
-	defer sess.Unlock()
-
-	sess.validUntil = time.Now().Add(ttl)
+	// Scopes optionally limits the token to endpoints whose path starts with
+	// one of the scopes. Other handlers are denied.
+	Scopes []string
 }
 
 // AuthenticatedHandler defines the handler interface to specify custom
@@ -134,7 +111,11 @@ func SetAuthenticator(fn AuthenticatorFunc) error {
 	return nil
 }
 
-func authenticateRequest(w http.ResponseWriter, r *http.Request, targetHandler http.Handler, readMethod bool) *AuthToken {
+// checkAuthFunc returns the token of the request. If handled is true, the
+// request was already replied to.
+type checkAuthFunc func(w http.ResponseWriter, r *http.Request, authRequired bool) (token *AuthToken, handled bool)
+
+func authenticateRequest(w http.ResponseWriter, r *http.Request, targetHandler http.Handler, readMethod bool, checkAuthFn checkAuthFunc) *AuthToken {
 	tracer := log.Tracer(r.Context())
 
 	// Get required permission for target handler.
@@ -184,7 +165,7 @@ func authenticateRequest(w http.ResponseWriter, r *http.Request, targetHandler h
 	}
 
 	// Authenticate request.
-	token, handled := checkAuth(w, r, requiredPermission > PermitAnyone)
+	token, handled := checkAuthFn(w, r, requiredPermission > PermitAnyone)
 	switch {
 	case handled:
 		return nil
@@ -232,30 +213,64 @@ func authenticateRequest(w http.ResponseWriter, r *http.Request, targetHandler h
 		return nil
 	}
 
+	// Check if the token is limited to certain endpoints.
+	if !token.inScope(r) {
+		tracer.Debugf("api: denying api access from %s: request is out of scope of token", r.RemoteAddr)
+		http.Error(w, "Insufficient permissions.", http.StatusForbidden)
+		return nil
+	}
+
 	tracer.Tracef("api: granted %s access to protected handler", r.RemoteAddr)
 
 	// Make a copy of the AuthToken in order mitigate the handler poisoning the
 	// token, as changes would apply to future requests.
 	return &AuthToken{
-		Read:  token.Read,
-		Write: token.Write,
+		Read:     token.Read,
+		Write:    token.Write,
+		Identity: token.Identity,
+		Scopes:   token.Scopes,
 	}
 }
 
+// inScope returns whether the request is within the scopes of the token.
+func (token *AuthToken) inScope(r *http.Request) bool {
+	if len(token.Scopes) == 0 {
+		return true
+	}
+
+	// Scopes only apply to endpoints.
+	apiEndpoint, _ := getAPIContext(r)
+	if apiEndpoint == nil {
+		return false
+	}
+	// The scopes are checked for every request of a batch.
+	if apiEndpoint.Version == batchEndpointVersion && apiEndpoint.Path == batchEndpointPath {
+		return true
+	}
+	for _, scope := range token.Scopes {
+		if strings.HasPrefix(apiEndpoint.Path, scope) {
+			return true
+		}
+	}
+	return false
+}
+
 func checkAuth(w http.ResponseWriter, r *http.Request, authRequired bool) (token *AuthToken, handled bool) {
 	// Return highest possible permissions in dev mode.
 	if devMode() {
 		return &AuthToken{
-			Read:  PermitSelf,
-			Write: PermitSelf,
+			Read:     PermitSelf,
+			Write:    PermitSelf,
+			Identity: "dev-mode",
 		}, false
 	}
 
 	// Database Bridge Access.
 	if r.RemoteAddr == endpointBridgeRemoteAddress {
 		return &AuthToken{
-			Read:  dbCompatibilityPermission,
-			Write: dbCompatibilityPermission,
+			Read:     dbCompatibilityPermission,
+			Write:    dbCompatibilityPermission,
+			Identity: endpointBridgeRemoteAddress,
 		}, false
 	}
 
@@ -302,11 +317,12 @@ func checkAuth(w http.ResponseWriter, r *http.Request, authRequired bool) (token
 	}
 
 	// Create session cookie for authenticated request.
-	err = createSession(w, r, token)
+	sessionToken, err := createSession(w, r, token)
 	if err != nil {
 		log.Tracer(r.Context()).Warningf("api: failed to create session: %s", err)
+		return token, false
 	}
-	return token, false
+	return sessionToken, false
 }
 
 func checkAPIKey(r *http.Request) *AuthToken {
@@ -330,11 +346,15 @@ func checkAPIKey(r *http.Request) *AuthToken {
 		return nil
 	}
 
+	// Check if the provided API key exists, either in the config or as a
+	// managed API key.
 	apiKeysLock.Lock()
-	defer apiKeysLock.Unlock()
-
-	// Check if the provided API key exists.
 	token, ok := apiKeys[key]
+	apiKeysLock.Unlock()
+	if !ok {
+		token = checkManagedAPIKey(key)
+		ok = token != nil
+	}
 	if !ok {
 		log.Tracer(r.Context()).Tracef(
 			"api: provided api key %s... is unknown", key[:4],
@@ -387,8 +407,9 @@ func updateAPIKeys(_ context.Context, _ interface{}) error {
 
 		// Create token with default permissions.
 		token := &AuthToken{
-			Read:  PermitAnyone,
-			Write: PermitAnyone,
+			Read:     PermitAnyone,
+			Write:    PermitAnyone,
+			Identity: "api-key:" + fingerprint(u.Path),
 		}
 
 		// Update with configured permissions.
@@ -447,84 +468,10 @@ func updateAPIKeys(_ context.Context, _ interface{}) error {
 	return nil
 }
 
-func checkSessionCookie(r *http.Request) *AuthToken {
-	// Get session cookie from request.
-	c, err := r.Cookie(sessionCookieName)
-	if err != nil {
-		return nil
-	}
-
-	// Check if session cookie is registered.
-	sessionsLock.Lock()
-	sess, ok := sessions[c.Value]
-	sessionsLock.Unlock()
-	if !ok {
-		log.Tracer(r.Context()).Tracef("api: provided session cookie %s is unknown", c.Value)
-		return nil
-	}
-
-	// Check if session is still valid.
-	if sess.Expired() {
-		log.Tracer(r.Context()).Tracef("api: provided session cookie %s has expired", c.Value)
-		return nil
-	}
-
-	// Refresh session and return.
-	sess.Refresh(sessionCookieTTL)
-	log.Tracer(r.Context()).Tracef("api: session cookie %s is valid, refreshing", c.Value)
-	return sess.token
-}
-
-func createSession(w http.ResponseWriter, r *http.Request, token *AuthToken) error {
-	// Generate new session key.
-	secret, err := rng.Bytes(32) // 256 bit
-	if err != nil {
-		return err
-	}
-	sessionKey := base64.RawURLEncoding.EncodeToString(secret)
-
-	// Set token cookie in response.
-	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieName,
-		Value:    sessionKey,
-		Path:     "/",
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
-	})
-
-	// Create session.
-	sess := &session{
-		token: token,
-	}
-	sess.Refresh(sessionCookieTTL)
-
-	// Save session.
-	sessionsLock.Lock()
-	defer sessionsLock.Unlock()
-	sessions[sessionKey] = sess
-	log.Tracer(r.Context()).Debug("api: issued session cookie")
-
-	return nil
-}
-
-func cleanSessions(_ context.Context, _ *modules.Task) error {
-	sessionsLock.Lock()
-	defer sessionsLock.Unlock()
-
-	for sessionKey, sess := range sessions {
-		if sess.Expired() {
-			delete(sessions, sessionKey)
-		}
-	}
-
-	return nil
-}
-
-func deleteSession(sessionKey string) {
-	sessionsLock.Lock()
-	defer sessionsLock.Unlock()
-
-	delete(sessions, sessionKey)
+// fingerprint returns a short, non-reversible identifier of the given secret.
+func fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:8])
 }
 
 func getEffectiveMethod(r *http.Request) (eMethod string, readMethod bool, ok bool) {
@@ -541,7 +488,7 @@ func getEffectiveMethod(r *http.Request) (eMethod string, readMethod bool, ok bo
 	switch method {
 	case http.MethodGet, http.MethodHead:
 		return http.MethodGet, true, true
-	case http.MethodPost, http.MethodPut, http.MethodDelete:
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
 		return method, false, true
 	default:
 		return "", false, false
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// batchEndpointVersion and batchEndpointPath identify the batch endpoint.
+	batchEndpointVersion = 1
+	batchEndpointPath    = "batch"
+
+	// maxBatchRequests defines how many requests a single batch may contain.
+	maxBatchRequests = 50
+)
+
+// BatchRequestItem is a single request of a batch.
+type BatchRequestItem struct {
+	// Method is the HTTP method of the request. Defaults to GET.
+	Method string `json:",omitempty"`
+	// Path is the path of the endpoint, relative to /api/v1/, and may include
+	// a query, eg. "config/options?key=core/". Endpoints of other versions are
+	// addressed by starting with the version, eg. "v2/config/options".
+	Path string
+	// Body is the optional JSON body of the request.
+	Body json.RawMessage `json:",omitempty"`
+}
+
+// BatchResponseItem is the response to a single request of a batch.
+type BatchResponseItem struct {
+	// Status is the HTTP status code of the response.
+	Status int
+	// ContentType is the content type of the response.
+	ContentType string `json:",omitempty"`
+	// Data holds the response body, if it is JSON.
+	Data json.RawMessage `json:",omitempty"`
+	// Text holds the response body, if it is not JSON.
+	Text string `json:",omitempty"`
+}
+
+func registerBatchEndpoint() error {
+	return RegisterEndpoint(Endpoint{
+		Path:         batchEndpointPath,
+		Version:      batchEndpointVersion,
+		Write:        Dynamic,
+		StructFunc:   handleBatchRequest,
+		RequestType:  []BatchRequestItem{},
+		ResponseType: []BatchResponseItem{},
+		Name:         "Batch Requests",
+		Description: fmt.Sprintf(
+			"Executes up to %d requests to other endpoints in order and returns their responses. "+
+				"Every request is authenticated and checked like a separate request, using the credentials of the batch request.",
+			maxBatchRequests,
+		),
+	})
+}
+
+func handleBatchRequest(ar *Request) (i interface{}, err error) {
+	// Parse request.
+	var items []BatchRequestItem
+	if err := json.Unmarshal(ar.InputData, &items); err != nil {
+		return nil, ErrorWithStatus(fmt.Errorf("failed to parse request: %w", err), http.StatusBadRequest)
+	}
+	if len(items) > maxBatchRequests {
+		return nil, ErrorWithStatus(fmt.Errorf("batch may contain at most %d requests", maxBatchRequests), http.StatusBadRequest)
+	}
+
+	// Build all requests first, so that nothing is executed if one is invalid.
+	requests := make([]*http.Request, 0, len(items))
+	for i, item := range items {
+		r, err := newBatchSubRequest(ar, item)
+		if err != nil {
+			return nil, ErrorWithStatus(fmt.Errorf("request %d: %w", i, err), http.StatusBadRequest)
+		}
+		requests = append(requests, r)
+	}
+
+	// Execute requests in order through the main handler, which checks
+	// permissions and rate limits of every request. Requests use the token of
+	// the batch request instead of authenticating again, which would create a
+	// session for every request.
+	token := ar.AuthToken
+	mh := &mainHandler{
+		mux: mainMux,
+		checkAuth: func(_ http.ResponseWriter, _ *http.Request, _ bool) (*AuthToken, bool) {
+			return token, false
+		},
+	}
+	responses := make([]BatchResponseItem, 0, len(requests))
+	for _, r := range requests {
+		w := newBatchResponseWriter()
+		_ = mh.handle(w, r)
+		responses = append(responses, w.response())
+	}
+
+	return responses, nil
+}
+
+// newBatchSubRequest returns the http request for the given batch item, with
+// the credentials and connection properties of the batch request.
+func newBatchSubRequest(ar *Request, item BatchRequestItem) (*http.Request, error) {
+	// Check method.
+	method := strings.ToUpper(item.Method)
+	switch method {
+	case "":
+		method = http.MethodGet
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return nil, fmt.Errorf("unsupported method %q", item.Method)
+	}
+
+	// Check path.
+	version, endpointPath := splitAPIVersion(strings.TrimPrefix(item.Path, "/"))
+	versionPath := apiVersionPath(version)
+	u, err := url.Parse(versionPath + endpointPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path: %w", err)
+	}
+	u.Path = cleanRequestPath(u.Path)
+	switch {
+	case !strings.HasPrefix(u.Path, versionPath) || u.Path == versionPath:
+		return nil, errors.New("path must be an endpoint")
+	case u.Path == apiVersionPath(batchEndpointVersion)+batchEndpointPath:
+		return nil, errors.New("batches may not be nested")
+	}
+
+	// Derive request from the batch request to keep authentication and
+	// connection properties.
+	r := ar.Request.Clone(ar.Context())
+	r.Method = method
+	r.URL = u
+	r.RequestURI = u.RequestURI()
+	r.Body = io.NopCloser(bytes.NewReader(item.Body))
+	r.ContentLength = int64(len(item.Body))
+	r.Header.Del("Content-Length")
+	r.Header.Del("If-Match")
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+	if len(item.Body) > 0 {
+		r.Header.Set("Content-Type", MimeTypeJSON)
+	} else {
+		r.Header.Del("Content-Type")
+	}
+	r.Header.Set("Accept", MimeTypeJSON)
+
+	return r, nil
+}
+
+// batchResponseWriter records the response of a batch sub-request.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{
+		header: make(http.Header),
+	}
+}
+
+// Header returns the response header.
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// Write writes the response body.
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// WriteHeader sets the response status code.
+func (w *batchResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *batchResponseWriter) response() BatchResponseItem {
+	resp := BatchResponseItem{
+		Status:      w.status,
+		ContentType: w.header.Get("Content-Type"),
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+
+	// Embed JSON responses directly.
+	if strings.HasPrefix(resp.ContentType, MimeTypeJSON) && json.Valid(w.body.Bytes()) {
+		resp.Data = w.body.Bytes()
+	} else {
+		resp.Text = w.body.String()
+	}
+	return resp
+}
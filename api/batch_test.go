@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchRequests(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/batch/struct",
+		Read: PermitAnyone,
+		StructFunc: func(_ *Request) (i interface{}, err error) {
+			return &actionTestRecord{Msg: successMsg}, nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path:  "test/batch/echo",
+		Write: PermitAnyone,
+		DataFunc: func(ar *Request) (data []byte, err error) {
+			return ar.InputData, nil
+		},
+	}))
+
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/batch/identity",
+		Read: PermitUser,
+		ActionFunc: func(ar *Request) (msg string, err error) {
+			return ar.AuthToken.Identity, nil
+		},
+	}))
+
+	// Authenticate batch requests with a user token.
+	var authenticated atomic.Int32
+	testHandler := &mainHandler{
+		mux: mainMux,
+		checkAuth: func(_ http.ResponseWriter, _ *http.Request, _ bool) (*AuthToken, bool) {
+			authenticated.Add(1)
+			return &AuthToken{Read: PermitUser, Write: PermitUser, Identity: "test-batch"}, false
+		},
+	}
+	batch := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, apiV1Path+"batch", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := batch(`[
+		{"Path": "test/batch/struct"},
+		{"Method": "POST", "Path": "/test/batch/echo", "Body": {"Msg": "echo"}},
+		{"Method": "POST", "Path": "test/batch/struct"},
+		{"Path": "test/batch/unknown"}
+	]`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var responses []BatchResponseItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responses))
+	require.Len(t, responses, 4)
+
+	assert.Equal(t, http.StatusOK, responses[0].Status)
+	assert.JSONEq(t, `{"Msg":"`+successMsg+`"}`, string(responses[0].Data))
+
+	assert.Equal(t, http.StatusOK, responses[1].Status)
+	assert.JSONEq(t, `{"Msg":"echo"}`, responses[1].Text)
+
+	// Every request is checked on its own.
+	assert.Equal(t, http.StatusMethodNotAllowed, responses[2].Status)
+	assert.Equal(t, http.StatusNotFound, responses[3].Status)
+
+	// Requests use the token of the batch request and are not authenticated
+	// again.
+	authenticated.Store(0)
+	w = batch(`[{"Path": "test/batch/identity"}, {"Path": "test/batch/identity"}]`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responses))
+	require.Len(t, responses, 2)
+	for _, resp := range responses {
+		assert.Equal(t, http.StatusOK, resp.Status)
+		assert.Equal(t, "test-batch", strings.TrimSpace(resp.Text))
+	}
+	assert.Equal(t, int32(1), authenticated.Load())
+
+	// Invalid batches are rejected as a whole.
+	w = batch(`[{"Path": "test/batch/struct"}, {"Path": "batch"}]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = batch(`[{"Path": "../database/v1"}]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = batch(`[{"Method": "CONNECT", "Path": "test/batch/struct"}]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
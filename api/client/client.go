@@ -28,6 +28,9 @@ type Client struct {
 	shutdownSignal chan struct{}
 	lastSignal     uint8
 
+	state         ConnectionState
+	stateWatchers []chan ConnectionState
+
 	send   chan *Message
 	resend chan *Message
 	recv   chan *Message
@@ -57,6 +60,9 @@ func NewClient(server string) *Client {
 
 // Connect connects to the API once.
 func (c *Client) Connect() error {
+	c.Lock()
+	c.setState(StateConnecting)
+	c.Unlock()
 	defer c.signalOffline()
 
 	err := c.wsConnect()
@@ -85,10 +91,14 @@ func (c *Client) StayConnected() {
 
 // Shutdown shuts the client down.
 func (c *Client) Shutdown() {
+	c.Lock()
+	defer c.Unlock()
+
 	select {
 	case <-c.shutdownSignal:
 	default:
 		close(c.shutdownSignal)
+		c.setState(StateShutdown)
 	}
 }
 
@@ -100,10 +110,13 @@ func (c *Client) signalOnline() {
 		c.offlineSignal = make(chan struct{})
 		close(c.onlineSignal)
 		c.lastSignal = onlineSignal
+		c.setState(StateOnline)
 
 		// resend unsent request
 		for _, op := range c.operations {
-			if op.resuscitationEnabled.IsSet() && op.request.sent != nil && op.request.sent.SetToIf(true, false) {
+			// The request is nil if resuscitation was enabled before sending.
+			if op.resuscitationEnabled.IsSet() && op.request != nil &&
+				op.request.sent != nil && op.request.sent.SetToIf(true, false) {
 				op.client.send <- op.request
 				log.Infof("client: resuscitated %s %s %s", op.request.OpID, op.request.Type, op.request.Key)
 			}
@@ -115,6 +128,7 @@ func (c *Client) signalOnline() {
 func (c *Client) signalOffline() {
 	c.Lock()
 	defer c.Unlock()
+	c.setState(StateOffline)
 	if c.lastSignal == onlineSignal {
 		log.Infof("client: went offline")
 		c.onlineSignal = make(chan struct{})
@@ -206,18 +220,25 @@ func (op *Operation) Cancel() {
 
 // Send sends a request to the API.
 func (op *Operation) Send(command, text string, data interface{}) {
-	op.request = &Message{
+	request := &Message{
 		OpID:  op.ID,
 		Type:  command,
 		Key:   text,
 		Value: data,
 		sent:  abool.NewBool(false),
 	}
-	log.Tracef("client: [%s] sending %s msg: %s", op.request.OpID, op.request.Type, op.request.Key)
-	op.client.send <- op.request
+	// The request is resent by the client when resuscitation is enabled.
+	op.client.Lock()
+	op.request = request
+	op.client.Unlock()
+
+	log.Tracef("client: [%s] sending %s msg: %s", request.OpID, request.Type, request.Key)
+	op.client.send <- request
 }
 
 // EnableResuscitation will resend the request after reconnecting to the API.
+// The responses are then received again. Use Client.Subscribe for
+// subscriptions that are reconciled after reconnecting.
 func (op *Operation) EnableResuscitation() {
 	op.resuscitationEnabled.Set()
 }
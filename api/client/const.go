@@ -1,5 +1,7 @@
 package client
 
+import "github.com/safing/portbase/formats/dsd"
+
 // Message Types.
 const (
 	msgRequestGet    = "get"
@@ -10,6 +12,7 @@
 	msgRequestUpdate = "update"
 	msgRequestInsert = "insert"
 	msgRequestDelete = "delete"
+	msgRequestCancel = "cancel"
 
 	MsgOk      = "ok"
 	MsgError   = "error"
@@ -25,4 +28,25 @@
 	apiSeperator = "|"
 )
 
+// Database API protocols, in order of preference. The v2 protocols batch
+// messages and send records in the given format with separate metadata.
+// Servers that do not support them fall back to the legacy protocol.
+const (
+	protocolV2MsgPack = "portbase.database.v2.msgpack"
+	protocolV2CBOR    = "portbase.database.v2.cbor"
+	protocolV2JSON    = "portbase.database.v2.json"
+)
+
+var protocols = []string{
+	protocolV2MsgPack,
+	protocolV2CBOR,
+	protocolV2JSON,
+}
+
+var protocolFormats = map[string]uint8{
+	protocolV2MsgPack: dsd.MsgPack,
+	protocolV2CBOR:    dsd.CBOR,
+	protocolV2JSON:    dsd.JSON,
+}
+
 var apiSeperatorBytes = []byte(apiSeperator)
@@ -0,0 +1,406 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/log"
+)
+
+// Endpoint Kinds, as defined by the API.
+const (
+	EndpointKindAction  = "action"
+	EndpointKindData    = "data"
+	EndpointKindStruct  = "struct"
+	EndpointKindRecord  = "record"
+	EndpointKindStream  = "stream"
+	EndpointKindHandler = "handler"
+)
+
+// HTTP Client Errors.
+var (
+	// ErrEndpointNotFound is returned when the API has no endpoint for a path.
+	ErrEndpointNotFound = errors.New("endpoint not found")
+
+	// ErrEndpointKindMismatch is returned when an endpoint is called with the
+	// helper of another kind of endpoint.
+	ErrEndpointKindMismatch = errors.New("endpoint is of another kind")
+
+	// ErrMethodNotSupported is returned when an endpoint supports neither
+	// reading nor writing.
+	ErrMethodNotSupported = errors.New("endpoint does not support any method")
+)
+
+// HTTPOptions holds the options of an HTTP client.
+type HTTPOptions struct {
+	// APIKey is sent as a bearer token with every request. If empty, the API
+	// may authenticate the client by other means and issue a session cookie.
+	APIKey string
+
+	// Version is the version of the API that is used.
+	// Defaults to 1.
+	Version int
+
+	// Format is the DSD format requested for struct responses.
+	// Defaults to JSON.
+	Format uint8
+
+	// HTTPClient is the http client used for requests. It should have a
+	// cookie jar in order to keep the session cookie.
+	// Defaults to a new client with a cookie jar.
+	HTTPClient *http.Client
+}
+
+// Endpoint describes an endpoint of the HTTP API, as exported by the API.
+type Endpoint struct {
+	Name        string
+	Description string
+	Path        string
+	Version     int
+	Deprecation *Deprecation
+	MimeType    string
+	Kind        string
+	Read        int8
+	ReadMethod  string
+	Write       int8
+	WriteMethod string
+}
+
+// Deprecation describes the deprecation of an endpoint, as exported by the API.
+type Deprecation struct {
+	Since       time.Time
+	Sunset      time.Time
+	Replacement string
+}
+
+// HTTPStatusError is returned when the API responds with an error status.
+// It implements the HTTPStatusProvider interface of the API, so that the
+// status is kept when the error is returned by an endpoint.
+type HTTPStatusError struct {
+	Status  int
+	Message string
+}
+
+// Error returns the error message.
+func (e *HTTPStatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("api responded with %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("api responded with %d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// HTTPStatus returns the HTTP status code of the error.
+func (e *HTTPStatusError) HTTPStatus() int {
+	return e.Status
+}
+
+// HTTPClient calls the endpoints of the HTTP API.
+type HTTPClient struct {
+	baseURL string
+	options HTTPOptions
+
+	endpoints       map[string]*Endpoint
+	endpointsRouter *mux.Router
+	endpointsLock   sync.Mutex
+
+	deprecationLogged sync.Map
+}
+
+// NewHTTPClient returns a new HTTP client for the API at the given server,
+// eg. "127.0.0.1:817" or "https://127.0.0.1:817".
+func NewHTTPClient(server string, opts *HTTPOptions) *HTTPClient {
+	c := &HTTPClient{}
+	if opts != nil {
+		c.options = *opts
+	}
+	if c.options.Version <= 0 {
+		c.options.Version = 1
+	}
+	if c.options.Format == 0 {
+		c.options.Format = dsd.JSON
+	}
+	if c.options.HTTPClient == nil {
+		jar, _ := cookiejar.New(nil) // Never fails without options.
+		c.options.HTTPClient = &http.Client{Jar: jar}
+	}
+
+	if !strings.Contains(server, "://") {
+		server = "http://" + server
+	}
+	c.baseURL = fmt.Sprintf("%s/api/v%d/", strings.TrimSuffix(server, "/"), c.options.Version)
+
+	return c
+}
+
+// Authenticate returns an error if the client is not authenticated. Without
+// an API key, the API may authenticate the client by other means and issue a
+// session cookie, which is then used for further requests.
+func (c *HTTPClient) Authenticate(ctx context.Context) error {
+	_, _, err := c.do(ctx, http.MethodGet, "auth/bearer", nil, "")
+	return err
+}
+
+// Endpoints returns all endpoints of the API, of all versions.
+func (c *HTTPClient) Endpoints(ctx context.Context) ([]*Endpoint, error) {
+	data, _, err := c.do(ctx, http.MethodGet, c.rootURL()+"v1/endpoints", nil, dsd.FormatToMimeType[dsd.JSON])
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []*Endpoint
+	if err := json.Unmarshal(data, &endpoints); err != nil {
+		return nil, fmt.Errorf("failed to parse endpoints: %w", err)
+	}
+
+	// Build router in order to find endpoints of the used version the same
+	// way as the API.
+	byPath := make(map[string]*Endpoint, len(endpoints))
+	router := mux.NewRouter()
+	for _, ep := range endpoints {
+		// Older versions of the API do not export the version.
+		if ep.Version == 0 {
+			ep.Version = 1
+		}
+		if ep.Version != c.options.Version {
+			continue
+		}
+		byPath[ep.Path] = ep
+		router.NewRoute().Path("/" + ep.Path).Name(ep.Path)
+	}
+
+	c.endpointsLock.Lock()
+	defer c.endpointsLock.Unlock()
+	c.endpoints = byPath
+	c.endpointsRouter = router
+
+	return endpoints, nil
+}
+
+// Endpoint returns the endpoint that handles the given path. The endpoints
+// are fetched from the API if they are not known yet.
+func (c *HTTPClient) Endpoint(ctx context.Context, path string) (*Endpoint, error) {
+	if ep := c.findEndpoint(path); ep != nil {
+		return ep, nil
+	}
+
+	// Update endpoints, as the endpoint may have been registered recently.
+	if _, err := c.Endpoints(ctx); err != nil {
+		return nil, err
+	}
+	if ep := c.findEndpoint(path); ep != nil {
+		return ep, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrEndpointNotFound, path)
+}
+
+func (c *HTTPClient) findEndpoint(path string) *Endpoint {
+	c.endpointsLock.Lock()
+	defer c.endpointsLock.Unlock()
+
+	if c.endpointsRouter == nil {
+		return nil
+	}
+
+	path, _, _ = strings.Cut(strings.TrimPrefix(path, "/"), "?")
+	var match mux.RouteMatch
+	if !c.endpointsRouter.Match(&http.Request{URL: &url.URL{Path: "/" + path}}, &match) ||
+		match.Route == nil {
+		return nil
+	}
+	return c.endpoints[match.Route.GetName()]
+}
+
+// Action calls an action endpoint and returns its message.
+// The body is sent as JSON, if not nil, and the endpoint is called with
+// its write method. Otherwise, it is called with its read method.
+// The path may include a query.
+func (c *HTTPClient) Action(ctx context.Context, path string, body interface{}) (msg string, err error) {
+	data, _, err := c.call(ctx, EndpointKindAction, path, body, "")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// Data calls a data endpoint and returns its data and content type.
+// See Action for how the endpoint is called.
+func (c *HTTPClient) Data(ctx context.Context, path string, body interface{}) (data []byte, mimeType string, err error) {
+	return c.call(ctx, EndpointKindData, path, body, "")
+}
+
+// Struct calls a struct endpoint and loads the response into v. The response
+// is requested in the configured format.
+// See Action for how the endpoint is called.
+func (c *HTTPClient) Struct(ctx context.Context, path string, body interface{}, v interface{}) error {
+	data, mimeType, err := c.call(ctx, EndpointKindStruct, path, body, dsd.FormatToMimeType[c.options.Format])
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if _, err := dsd.MimeLoad(data, mimeType, v); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// Record calls a record endpoint and returns the record, including its
+// metadata. The record is wrapped and may be unwrapped with record.Unwrap.
+// See Action for how the endpoint is called.
+func (c *HTTPClient) Record(ctx context.Context, path string, body interface{}) (record.Record, error) {
+	data, _, err := c.call(ctx, EndpointKindRecord, path, body, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil //nolint:nilnil // Endpoint returned no record.
+	}
+
+	// Parse metadata, which the API adds to the JSON data.
+	var withMeta struct {
+		Meta *struct {
+			record.Meta
+			Key string
+		} `json:"_meta"`
+	}
+	if err := json.Unmarshal(data, &withMeta); err != nil {
+		return nil, fmt.Errorf("failed to parse record: %w", err)
+	}
+	if withMeta.Meta == nil {
+		return nil, errors.New("failed to parse record: missing metadata")
+	}
+
+	return record.NewWrapper(withMeta.Meta.Key, &withMeta.Meta.Meta, dsd.JSON, data)
+}
+
+// rootURL returns the URL of the API, without the version.
+func (c *HTTPClient) rootURL() string {
+	return strings.TrimSuffix(c.baseURL, fmt.Sprintf("v%d/", c.options.Version))
+}
+
+// logDeprecation logs that a deprecated endpoint was used. The usage of an
+// endpoint is logged once per client.
+func (c *HTTPClient) logDeprecation(path string, header http.Header) {
+	if _, loaded := c.deprecationLogged.LoadOrStore(path, struct{}{}); loaded {
+		return
+	}
+
+	msg := fmt.Sprintf("client: endpoint %s is deprecated", path)
+	if sunset := header.Get("Sunset"); sunset != "" {
+		msg += ", will be removed at " + sunset
+	}
+	if link := header.Get("Link"); link != "" {
+		msg += ", see " + link
+	}
+	log.Warning(msg)
+}
+
+// call calls the endpoint at the given path, after checking its kind.
+func (c *HTTPClient) call(ctx context.Context, kind, path string, body interface{}, accept string) (data []byte, mimeType string, err error) {
+	ep, err := c.Endpoint(ctx, path)
+	if err != nil {
+		return nil, "", err
+	}
+	// Older versions of the API do not export the kind.
+	if ep.Kind != "" && ep.Kind != kind {
+		return nil, "", fmt.Errorf("%w: %s is a %s endpoint", ErrEndpointKindMismatch, ep.Path, ep.Kind)
+	}
+
+	// Use the write method for sending data.
+	var method string
+	switch {
+	case body == nil && ep.ReadMethod != "":
+		method = ep.ReadMethod
+	case ep.WriteMethod != "":
+		method = ep.WriteMethod
+	case ep.ReadMethod != "":
+		method = ep.ReadMethod
+	default:
+		return nil, "", fmt.Errorf("%w: %s", ErrMethodNotSupported, ep.Path)
+	}
+
+	return c.do(ctx, method, path, body, accept)
+}
+
+// do sends a request to the API and returns the response data and its
+// content type. Error responses are returned as HTTPStatusError.
+func (c *HTTPClient) do(ctx context.Context, method, path string, body interface{}, accept string) (data []byte, mimeType string, err error) {
+	// Encode body.
+	var bodyReader io.Reader
+	if body != nil {
+		var bodyData []byte
+		switch v := body.(type) {
+		case []byte:
+			bodyData = v
+		case json.RawMessage:
+			bodyData = v
+		default:
+			bodyData, err = json.Marshal(body)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to encode body: %w", err)
+			}
+		}
+		bodyReader = bytes.NewReader(bodyData)
+	}
+
+	// Create request.
+	reqURL := path
+	if !strings.Contains(path, "://") {
+		reqURL = c.baseURL + strings.TrimPrefix(path, "/")
+	}
+	r, err := http.NewRequestWithContext(ctx, method, reqURL, bodyReader)
+	if err != nil {
+		return nil, "", err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", dsd.FormatToMimeType[dsd.JSON])
+	}
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	if c.options.APIKey != "" {
+		r.Header.Set("Authorization", "Bearer "+c.options.APIKey)
+	}
+
+	// Send request and read response.
+	resp, err := c.options.HTTPClient.Do(r)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// Warn about the use of deprecated endpoints.
+	if resp.Header.Get("Deprecation") != "" {
+		c.logDeprecation(r.URL.Path, resp.Header)
+	}
+
+	// Return errors with their status.
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, "", &HTTPStatusError{
+			Status:  resp.StatusCode,
+			Message: strings.TrimSpace(string(data)),
+		}
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
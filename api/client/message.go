@@ -7,6 +7,7 @@
 	"github.com/tevino/abool"
 
 	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/database/record"
 	"github.com/safing/portbase/formats/dsd"
 )
 
@@ -20,11 +21,21 @@ type Message struct {
 	Key      string
 	RawValue []byte
 	Value    interface{}
-	sent     *abool.AtomicBool
+	// Meta holds the metadata of received records. It is only available with
+	// the v2 protocols, the legacy protocol embeds it in the JSON data.
+	Meta *record.Meta
+	sent *abool.AtomicBool
 }
 
-// ParseMessage parses the given raw data and returns a Message.
+// ParseMessage parses the given raw data of the legacy protocol and returns a
+// Message.
 func ParseMessage(data []byte) (*Message, error) {
+	return parseMessage(data, dsd.AUTO)
+}
+
+// parseMessage parses the given raw data and returns a Message. The format is
+// the record format of the v2 protocols or AUTO for the legacy protocol.
+func parseMessage(data []byte, format uint8) (*Message, error) {
 	parts := bytes.SplitN(data, apiSeperatorBytes, 4)
 	if len(parts) < 2 {
 		return nil, ErrMalformedMessage
@@ -46,6 +57,20 @@ func ParseMessage(data []byte) (*Message, error) {
 		}
 		m.Key = string(parts[2])
 		m.RawValue = parts[3]
+
+		// Split off metadata.
+		if format != dsd.AUTO {
+			c := container.New(parts[3])
+			metaData, err := c.GetNextBlock()
+			if err != nil {
+				return nil, ErrMalformedMessage
+			}
+			m.Meta = &record.Meta{}
+			if _, err := dsd.Load(metaData, m.Meta); err != nil {
+				return nil, err
+			}
+			m.RawValue = c.CompileData()
+		}
 	case MsgDelete:
 		// parse key
 		//    127|del|<key>
@@ -72,6 +97,12 @@ func ParseMessage(data []byte) (*Message, error) {
 
 // Pack serializes a message into a []byte slice.
 func (m *Message) Pack() ([]byte, error) {
+	return m.pack(dsd.JSON)
+}
+
+// pack serializes a message into a []byte slice and serializes the value in
+// the given format. Inserts always use JSON.
+func (m *Message) pack(format uint8) ([]byte, error) {
 	c := container.New([]byte(m.OpID), apiSeperatorBytes, []byte(m.Type))
 
 	if m.Key != "" {
@@ -82,7 +113,10 @@ func (m *Message) Pack() ([]byte, error) {
 			c.Append(m.RawValue)
 		} else if m.Value != nil {
 			var err error
-			m.RawValue, err = dsd.Dump(m.Value, dsd.JSON)
+			if m.Type == msgRequestInsert {
+				format = dsd.JSON
+			}
+			m.RawValue, err = dsd.Dump(m.Value, format)
 			if err != nil {
 				return nil, err
 			}
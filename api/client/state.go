@@ -0,0 +1,89 @@
+package client
+
+// ConnectionState is the state of the connection of a client to the API.
+type ConnectionState uint8
+
+// Connection States.
+const (
+	StateOffline ConnectionState = iota
+	StateConnecting
+	StateOnline
+	StateShutdown
+)
+
+// stateWatcherBufferSize defines how many state changes are buffered for a
+// watcher before the oldest are dropped.
+const stateWatcherBufferSize = 8
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateOffline:
+		return "offline"
+	case StateConnecting:
+		return "connecting"
+	case StateOnline:
+		return "online"
+	case StateShutdown:
+		return "shutdown"
+	default:
+		return "unknown"
+	}
+}
+
+// State returns the current connection state of the client.
+func (c *Client) State() ConnectionState {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.state
+}
+
+// WatchState returns a channel that receives every change of the connection
+// state. If the receiver falls behind, the oldest changes are dropped. The
+// channel is closed when the client shuts down.
+func (c *Client) WatchState() <-chan ConnectionState {
+	c.Lock()
+	defer c.Unlock()
+
+	watcher := make(chan ConnectionState, stateWatcherBufferSize)
+	if c.state == StateShutdown {
+		close(watcher)
+		return watcher
+	}
+	c.stateWatchers = append(c.stateWatchers, watcher)
+	return watcher
+}
+
+// setState sets the connection state and notifies the watchers.
+// The client lock must be held.
+func (c *Client) setState(state ConnectionState) {
+	// The state is final after shutting down.
+	if c.state == state || c.state == StateShutdown {
+		return
+	}
+	c.state = state
+
+	for _, watcher := range c.stateWatchers {
+		// Drop the oldest change if the watcher is full.
+		select {
+		case watcher <- state:
+			continue
+		default:
+		}
+		select {
+		case <-watcher:
+		default:
+		}
+		select {
+		case watcher <- state:
+		default:
+		}
+	}
+
+	if state == StateShutdown {
+		for _, watcher := range c.stateWatchers {
+			close(watcher)
+		}
+		c.stateWatchers = nil
+	}
+}
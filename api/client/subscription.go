@@ -0,0 +1,246 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// UpdateType is the type of a subscription update.
+type UpdateType uint8
+
+// Subscription Update Types.
+const (
+	// UpdateNew signifies a record that was added to the mirror.
+	UpdateNew UpdateType = iota + 1
+	// UpdateChanged signifies a record of the mirror that changed.
+	UpdateChanged
+	// UpdateDeleted signifies a record that was removed from the mirror. This
+	// includes records that were deleted while the client was offline.
+	UpdateDeleted
+	// UpdateSynced signifies that the mirror matches the API, after
+	// subscribing and after every reconnect.
+	UpdateSynced
+	// UpdateWarning signifies a failure with a single record.
+	UpdateWarning
+	// UpdateError signifies that the subscription failed. It is retried after
+	// the next reconnect.
+	UpdateError
+)
+
+func (t UpdateType) String() string {
+	switch t {
+	case UpdateNew:
+		return "new"
+	case UpdateChanged:
+		return "changed"
+	case UpdateDeleted:
+		return "deleted"
+	case UpdateSynced:
+		return "synced"
+	case UpdateWarning:
+		return "warning"
+	case UpdateError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// SubscriptionUpdate is an update of a subscription.
+type SubscriptionUpdate struct {
+	Type UpdateType
+	// Key is the key of the record, if the update is about a record.
+	Key string
+	// Message is the message with the record data. For deletions, it is the
+	// last known message of the record.
+	Message *Message
+	// Err is the error of warnings and errors.
+	Err error
+}
+
+// Subscription is a subscription to a query that maintains a local mirror of
+// the matching records. After reconnecting, the mirror is reconciled with the
+// API, so that only actual changes are reported.
+type Subscription struct {
+	sync.Mutex
+
+	op *Operation
+
+	records map[string]*Message
+	seen    map[string]struct{}
+	syncing bool
+
+	queue   []*SubscriptionUpdate
+	signal  chan struct{}
+	updates chan *SubscriptionUpdate
+	done    chan struct{}
+	cancel  sync.Once
+}
+
+// Subscribe subscribes to the given query and returns a subscription, which
+// mirrors the matching records and reports changes to them.
+func (c *Client) Subscribe(query string) *Subscription {
+	sub := &Subscription{
+		records: make(map[string]*Message),
+		seen:    make(map[string]struct{}),
+		syncing: true,
+		signal:  make(chan struct{}, 1),
+		updates: make(chan *SubscriptionUpdate),
+		done:    make(chan struct{}),
+	}
+	go sub.deliver()
+
+	sub.op = c.NewOperation(sub.handle)
+	sub.op.EnableResuscitation()
+	sub.op.Send(msgRequestQsub, query, nil)
+	return sub
+}
+
+// Updates returns the channel of updates. Updates are queued without limit
+// until they are received. The channel is closed when the subscription is
+// canceled.
+func (sub *Subscription) Updates() <-chan *SubscriptionUpdate {
+	return sub.updates
+}
+
+// Records returns a copy of the mirrored records by key.
+func (sub *Subscription) Records() map[string]*Message {
+	sub.Lock()
+	defer sub.Unlock()
+
+	records := make(map[string]*Message, len(sub.records))
+	for key, m := range sub.records {
+		records[key] = m
+	}
+	return records
+}
+
+// Synced returns whether the mirror currently matches the API.
+func (sub *Subscription) Synced() bool {
+	sub.Lock()
+	defer sub.Unlock()
+
+	return !sub.syncing
+}
+
+// Cancel cancels the subscription and closes the updates channel.
+func (sub *Subscription) Cancel() {
+	sub.cancel.Do(func() {
+		close(sub.done)
+		sub.op.Cancel()
+
+		// Cancel subscription in the API, if connected.
+		select {
+		case sub.op.client.send <- &Message{OpID: sub.op.ID, Type: msgRequestCancel}:
+		default:
+		}
+	})
+}
+
+func (sub *Subscription) handle(m *Message) {
+	sub.Lock()
+	defer sub.Unlock()
+
+	switch m.Type {
+	case MsgOk, MsgNew, MsgUpdate:
+		// Records of the query are sent as "ok" after (re)subscribing.
+		if sub.syncing {
+			sub.seen[m.Key] = struct{}{}
+		}
+		sub.set(m)
+
+	case MsgDelete:
+		delete(sub.seen, m.Key)
+		sub.remove(m.Key)
+
+	case MsgDone:
+		if !sub.syncing {
+			return
+		}
+		// Remove records that vanished while offline.
+		for key := range sub.records {
+			if _, ok := sub.seen[key]; !ok {
+				sub.remove(key)
+			}
+		}
+		sub.seen = make(map[string]struct{})
+		sub.syncing = false
+		sub.push(&SubscriptionUpdate{Type: UpdateSynced})
+
+	case MsgOffline:
+		// The subscription is sent again when online, start syncing again.
+		sub.syncing = true
+		sub.seen = make(map[string]struct{})
+
+	case MsgWarning:
+		sub.push(&SubscriptionUpdate{Type: UpdateWarning, Err: errors.New(m.Key)})
+
+	case MsgError:
+		sub.push(&SubscriptionUpdate{Type: UpdateError, Err: errors.New(m.Key)})
+	}
+}
+
+// set adds or updates the record in the mirror and reports the change.
+// The subscription lock must be held.
+func (sub *Subscription) set(m *Message) {
+	existing, ok := sub.records[m.Key]
+	sub.records[m.Key] = m
+
+	switch {
+	case !ok:
+		sub.push(&SubscriptionUpdate{Type: UpdateNew, Key: m.Key, Message: m})
+	case !bytes.Equal(existing.RawValue, m.RawValue) ||
+		(m.Meta != nil && (existing.Meta == nil || *existing.Meta != *m.Meta)):
+		sub.push(&SubscriptionUpdate{Type: UpdateChanged, Key: m.Key, Message: m})
+	}
+}
+
+// remove removes the record from the mirror and reports the deletion.
+// The subscription lock must be held.
+func (sub *Subscription) remove(key string) {
+	existing, ok := sub.records[key]
+	if !ok {
+		return
+	}
+	delete(sub.records, key)
+	sub.push(&SubscriptionUpdate{Type: UpdateDeleted, Key: key, Message: existing})
+}
+
+// push queues an update for delivery.
+// The subscription lock must be held.
+func (sub *Subscription) push(u *SubscriptionUpdate) {
+	sub.queue = append(sub.queue, u)
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+}
+
+// deliver delivers the queued updates until the subscription is canceled.
+func (sub *Subscription) deliver() {
+	defer close(sub.updates)
+
+	for {
+		sub.Lock()
+		if len(sub.queue) == 0 {
+			sub.Unlock()
+			select {
+			case <-sub.signal:
+				continue
+			case <-sub.done:
+				return
+			}
+		}
+		u := sub.queue[0]
+		sub.queue[0] = nil
+		sub.queue = sub.queue[1:]
+		sub.Unlock()
+
+		select {
+		case sub.updates <- u:
+		case <-sub.done:
+			return
+		}
+	}
+}
@@ -7,6 +7,7 @@
 	"github.com/gorilla/websocket"
 	"github.com/tevino/abool"
 
+	"github.com/safing/portbase/container"
 	"github.com/safing/portbase/log"
 )
 
@@ -15,6 +16,11 @@ type wsState struct {
 	wg         sync.WaitGroup
 	failing    *abool.AtomicBool
 	failSignal chan struct{}
+
+	// format is the record format of the negotiated v2 protocol. It is AUTO
+	// for the legacy protocol.
+	format   uint8
+	batching bool
 }
 
 func (c *Client) wsConnect() error {
@@ -23,11 +29,21 @@ func (c *Client) wsConnect() error {
 		failSignal: make(chan struct{}),
 	}
 
+	dialer := &websocket.Dialer{
+		Proxy:             websocket.DefaultDialer.Proxy,
+		HandshakeTimeout:  websocket.DefaultDialer.HandshakeTimeout,
+		Subprotocols:      protocols,
+		EnableCompression: true,
+	}
+
 	var err error
-	state.wsConn, _, err = websocket.DefaultDialer.Dial(fmt.Sprintf("ws://%s/api/database/v1", c.server), nil)
+	state.wsConn, _, err = dialer.Dial(fmt.Sprintf("ws://%s/api/database/v1", c.server), nil)
 	if err != nil {
 		return err
 	}
+	// Servers that do not support the v2 protocols do not select a protocol.
+	state.format, state.batching = protocolFormats[state.wsConn.Subprotocol()]
+	state.wsConn.EnableWriteCompression(false)
 
 	c.signalOnline()
 
@@ -61,10 +77,23 @@ func (c *Client) wsReader(state *wsState) {
 			return
 		}
 		log.Tracef("client: received message: %s", string(data))
-		m, err := ParseMessage(data)
-		if err != nil {
-			log.Warningf("client: failed to parse message: %s", err)
-		} else {
+
+		// Split batched messages.
+		msgs := [][]byte{data}
+		if state.batching {
+			msgs, err = unpackBatch(data)
+			if err != nil {
+				log.Warningf("client: failed to unpack batch: %s", err)
+				continue
+			}
+		}
+
+		for _, msg := range msgs {
+			m, err := parseMessage(msg, state.format)
+			if err != nil {
+				log.Warningf("client: failed to parse message: %s", err)
+				continue
+			}
 			select {
 			case c.recv <- m:
 			case <-state.failSignal:
@@ -81,7 +110,7 @@ func (c *Client) wsWriter(state *wsState) {
 		case <-state.failSignal:
 			return
 		case m := <-c.resend:
-			data, err := m.Pack()
+			data, err := state.pack(m)
 			if err == nil {
 				err = state.wsConn.WriteMessage(websocket.BinaryMessage, data)
 			}
@@ -94,7 +123,7 @@ func (c *Client) wsWriter(state *wsState) {
 				m.sent.Set()
 			}
 		case m := <-c.send:
-			data, err := m.Pack()
+			data, err := state.pack(m)
 			if err == nil {
 				err = state.wsConn.WriteMessage(websocket.BinaryMessage, data)
 			}
@@ -111,6 +140,36 @@ func (c *Client) wsWriter(state *wsState) {
 	}
 }
 
+// pack serializes a message for sending it with the negotiated protocol.
+// Messages are not batched, but sent as a batch of one message.
+func (state *wsState) pack(m *Message) ([]byte, error) {
+	if !state.batching {
+		return m.Pack()
+	}
+
+	data, err := m.pack(state.format)
+	if err != nil {
+		return nil, err
+	}
+	c := container.New()
+	c.AppendAsBlock(data)
+	return c.CompileData(), nil
+}
+
+// unpackBatch returns the messages of a batched frame.
+func unpackBatch(frame []byte) ([][]byte, error) {
+	var msgs [][]byte
+	c := container.New(frame)
+	for c.HoldsData() {
+		msg, err := c.GetNextBlock()
+		if err != nil {
+			return nil, ErrMalformedMessage
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
 func (state *wsState) Error(message string) {
 	if state.failing.SetToIf(false, true) {
 		close(state.failSignal)
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/api/client"
+	"github.com/safing/portbase/database"
+)
+
+// trackingListener tracks accepted connections in order to close them.
+type trackingListener struct {
+	net.Listener
+
+	conns []net.Conn
+	lock  sync.Mutex
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.lock.Lock()
+		l.conns = append(l.conns, conn)
+		l.lock.Unlock()
+	}
+	return conn, err
+}
+
+func (l *trackingListener) closeConns() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.conns = nil
+}
+
+func TestClientSubscription(t *testing.T) {
+	t.Parallel()
+
+	_, err := database.Register(&database.Database{
+		Name:        "testing-client",
+		Description: "Unit Test Database for the API Client",
+		StorageType: "hashmap",
+	})
+	require.NoError(t, err)
+	RequireDatabasePermissions("testing-client:", PermitUser, PermitUser)
+	db := database.NewInterface(nil)
+	put := func(key, msg string) {
+		r := &actionTestRecord{Msg: msg}
+		r.SetKey("testing-client:" + key)
+		require.NoError(t, db.Put(r))
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		put(key, key)
+	}
+
+	// Serve the websocket API with a user token.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ar := &Request{
+			Request:   r,
+			AuthToken: &AuthToken{Read: PermitUser, Write: PermitUser},
+		}
+		r = r.WithContext(context.WithValue(r.Context(), RequestContextKey, ar))
+		startDatabaseWebsocketAPI(w, r)
+	}))
+	listener := &trackingListener{Listener: srv.Listener}
+	srv.Listener = listener
+	srv.Start()
+	defer srv.Close()
+
+	c := client.NewClient(strings.TrimPrefix(srv.URL, "http://"))
+	states := c.WatchState()
+	go c.StayConnected()
+
+	sub := c.Subscribe("query testing-client:")
+
+	// collect returns the updates of records until the mirror is synced.
+	collect := func() map[string]client.UpdateType {
+		updates := make(map[string]client.UpdateType)
+		for {
+			select {
+			case u := <-sub.Updates():
+				if u.Type == client.UpdateSynced {
+					return updates
+				}
+				updates[u.Key] = u.Type
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for subscription to sync")
+			}
+		}
+	}
+	next := func() *client.SubscriptionUpdate {
+		select {
+		case u := <-sub.Updates():
+			return u
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for subscription update")
+			return nil
+		}
+	}
+
+	// The initial records are new.
+	assert.Equal(t, map[string]client.UpdateType{
+		"testing-client:a": client.UpdateNew,
+		"testing-client:b": client.UpdateNew,
+		"testing-client:c": client.UpdateNew,
+	}, collect())
+	assert.True(t, sub.Synced())
+
+	// Live updates are reported.
+	put("b", "b2")
+	u := next()
+	assert.Equal(t, client.UpdateChanged, u.Type)
+	assert.Equal(t, "testing-client:b", u.Key)
+
+	// Changes while offline are reconciled after reconnecting.
+	listener.closeConns()
+	require.Eventually(t, func() bool {
+		return c.State() != client.StateOnline
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, db.Delete("testing-client:c"))
+	put("a", "a2")
+	put("d", "d")
+	assert.Equal(t, map[string]client.UpdateType{
+		"testing-client:a": client.UpdateChanged,
+		"testing-client:c": client.UpdateDeleted,
+		"testing-client:d": client.UpdateNew,
+	}, collect())
+	assert.Len(t, sub.Records(), 3)
+
+	// Canceling closes the updates channel.
+	sub.Cancel()
+	for range sub.Updates() { //nolint:revive // Drain channel.
+	}
+
+	// Connection state transitions are reported until shutdown.
+	c.Shutdown()
+	var transitions []client.ConnectionState
+	for state := range states {
+		transitions = append(transitions, state)
+	}
+	assert.Equal(t, []client.ConnectionState{
+		client.StateConnecting,
+		client.StateOnline,
+		client.StateOffline,
+		client.StateConnecting,
+		client.StateOnline,
+		client.StateShutdown,
+	}, transitions)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/database/record"
+)
+
+// ErrPreconditionFailed is returned when the If-Match header of a request does
+// not match the current version of the resource.
+var ErrPreconditionFailed = errors.New("resource was modified")
+
+// conditionalWriteLocks serialize conditional writes, so that the resource
+// cannot change between checking the If-Match header and writing.
+var conditionalWriteLocks [64]sync.Mutex
+
+// conditionalWriteLock returns the lock for conditional writes of the given
+// resource key.
+func conditionalWriteLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &conditionalWriteLocks[h.Sum32()%uint32(len(conditionalWriteLocks))]
+}
+
+// RecordETag returns the ETag of the given record, which is derived from a
+// hash of its data and metadata, so that it changes with every change of the
+// record, even within the same second. It is empty if the record cannot be
+// serialized. The record must be locked.
+func RecordETag(r record.Record) string {
+	data, err := r.MarshalRecord(r)
+	if err != nil {
+		return ""
+	}
+	return contentETag(data)
+}
+
+// recordLastModified returns the modification time of the given record.
+// The record must be locked.
+func recordLastModified(r record.Record) time.Time {
+	return time.Unix(r.Meta().Modified, 0)
+}
+
+// contentETag returns the ETag of the given response data, which is derived
+// from its hash.
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// CheckIfMatch checks the If-Match header of the request against the given
+// ETag of the current version of the resource. An empty ETag signifies that
+// the resource does not exist. If the precondition fails, an error with the
+// status 412 is returned.
+func (ar *Request) CheckIfMatch(etag string) error {
+	ifMatch := ar.Header.Get("If-Match")
+	if ifMatch == "" {
+		return nil
+	}
+
+	if etag != "" && (strings.TrimSpace(ifMatch) == "*" || etagListContains(ifMatch, etag, false)) {
+		return nil
+	}
+	return ErrorWithStatus(ErrPreconditionFailed, http.StatusPreconditionFailed)
+}
+
+// writeValidators sets the ETag and Last-Modified headers, if available, and
+// returns whether the client already has the current version of the resource,
+// as indicated by the If-None-Match and If-Modified-Since headers.
+func writeValidators(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) (notModified bool) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since.
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etag != "" && (strings.TrimSpace(ifNoneMatch) == "*" || etagListContains(ifNoneMatch, etag, true))
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(ifModifiedSince)
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// etagListContains returns whether the given comma separated list of ETags
+// contains the given ETag. Weak comparison ignores the weak indicator.
+func etagListContains(list, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	} else if strings.HasPrefix(etag, "W/") {
+		// Weak ETags never match with strong comparison.
+		return false
+	}
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/database/record"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	t.Parallel()
+
+	testRecord := &actionTestRecord{Msg: successMsg}
+	testRecord.SetKey("test:conditional")
+	testRecord.UpdateMeta()
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/conditional/record",
+		Read: PermitAnyone,
+		RecordFunc: func(_ *Request) (r record.Record, err error) {
+			return testRecord, nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path:        "test/conditional/struct",
+		Read:        PermitAnyone,
+		ContentETag: true,
+		StructFunc: func(_ *Request) (i interface{}, err error) {
+			return &actionTestRecord{Msg: successMsg}, nil
+		},
+	}))
+
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, apiV1Path+path, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Records.
+	w := get("test/conditional/record", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, lastModified)
+
+	w = get("test/conditional/record", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	w = get("test/conditional/record", http.Header{"If-None-Match": {`"other", W/` + etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = get("test/conditional/record", http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// Modify record.
+	testRecord.Lock()
+	testRecord.Meta().Modified += 10
+	testRecord.Unlock()
+	w = get("test/conditional/record", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	w = get("test/conditional/record", http.Header{"If-Modified-Since": {lastModified}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Content ETags.
+	w = get("test/conditional/struct", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag = w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	w = get("test/conditional/struct", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = get("test/conditional/struct", http.Header{"Accept": {"application/yaml"}, "If-None-Match": {etag}})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestEndpointIfMatch(t *testing.T) {
+	t.Parallel()
+
+	testRecord := &actionTestRecord{Msg: successMsg}
+	testRecord.SetKey("test:if-match")
+	testRecord.UpdateMeta()
+	var writes int
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path:        "test/conditional/write",
+		Read:        PermitAnyone,
+		Write:       PermitAnyone,
+		WriteMethod: http.MethodPut,
+		RecordFunc: func(ar *Request) (r record.Record, err error) {
+			if ar.Method == http.MethodPut {
+				testRecord.Lock()
+				testRecord.Msg = string(ar.InputData)
+				testRecord.Unlock()
+				writes++
+			}
+			return testRecord, nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path:        "test/conditional/write-only",
+		Write:       PermitAnyone,
+		WriteMethod: http.MethodPut,
+		StructFunc: func(_ *Request) (i interface{}, err error) {
+			return &actionTestRecord{Msg: successMsg}, nil
+		},
+	}))
+
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+	serve := func(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, apiV1Path+path, strings.NewReader(body))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodGet, "test/conditional/write", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// Write with the current ETag.
+	w = serve(http.MethodPut, "test/conditional/write", etag, "first")
+	require.Equal(t, http.StatusOK, w.Code)
+	newETag := w.Header().Get("ETag")
+	assert.NotEqual(t, etag, newETag)
+	assert.Equal(t, 1, writes)
+
+	// Write with an outdated ETag.
+	w = serve(http.MethodPut, "test/conditional/write", etag, "second")
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, 1, writes)
+	assert.Equal(t, "first", testRecord.Msg)
+
+	// Write with the ETag returned by the last write and with any ETag.
+	w = serve(http.MethodPut, "test/conditional/write", newETag, "third")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(http.MethodPut, "test/conditional/write", "*", "fourth")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(http.MethodPut, "test/conditional/write", "", "fifth")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 4, writes)
+
+	// Endpoints that cannot be read never match.
+	w = serve(http.MethodPut, "test/conditional/write-only", "*", "")
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve(http.MethodPut, "test/conditional/write-only", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	t.Parallel()
+
+	check := func(ifMatch, etag string) error {
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		ar := &Request{Request: r}
+		return ar.CheckIfMatch(etag)
+	}
+
+	assert.NoError(t, check("", `"1"`))
+	assert.NoError(t, check("", ""))
+	assert.NoError(t, check(`"1"`, `"1"`))
+	assert.NoError(t, check(`"0", "1"`, `"1"`))
+	assert.NoError(t, check("*", `"1"`))
+	assert.ErrorIs(t, check(`"0"`, `"1"`), ErrPreconditionFailed)
+	assert.ErrorIs(t, check(`W/"1"`, `"1"`), ErrPreconditionFailed)
+	assert.ErrorIs(t, check("*", ""), ErrPreconditionFailed)
+	assert.ErrorIs(t, check(`"1"`, ""), ErrPreconditionFailed)
+
+	// Modification times have a precision of a second.
+	lastModified := time.Unix(1000, 0)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-Modified-Since", lastModified.UTC().Format(http.TimeFormat))
+	assert.True(t, writeValidators(httptest.NewRecorder(), r, "", lastModified.Add(500*time.Millisecond)))
+	assert.False(t, writeValidators(httptest.NewRecorder(), r, "", lastModified.Add(time.Second)))
+}
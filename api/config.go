@@ -8,8 +8,19 @@
 
 // Config Keys.
 const (
-	CfgDefaultListenAddressKey = "core/listenAddress"
-	CfgAPIKeys                 = "core/apiKeys"
+	CfgDefaultListenAddressKey  = "core/listenAddress"
+	CfgAPIKeys                  = "core/apiKeys"
+	CfgAPIRateLimitPerToken     = "core/apiRateLimitPerToken"
+	CfgAPIRateLimitPerAddress   = "core/apiRateLimitPerAddress"
+	CfgAPIAuditLogRetention     = "core/apiAuditLogRetention"
+	CfgAPITLS                   = "core/apiTLS"
+	CfgAPISocketPath            = "core/apiSocketPath"
+	CfgAPISocketMode            = "core/apiSocketMode"
+	CfgAPIAllowedOrigins        = "core/apiAllowedOrigins"
+	CfgAPIContentSecurityPolicy = "core/apiContentSecurityPolicy"
+	CfgAPISecurityHeaders       = "core/apiSecurityHeaders"
+	CfgAPIPersistSessions       = "core/apiPersistSessions"
+	CfgAPISessionMaxLifetime    = "core/apiSessionMaxLifetime"
 )
 
 var (
@@ -19,6 +30,23 @@
 
 	configuredAPIKeys config.StringArrayOption
 
+	rateLimitPerToken   config.IntOption
+	rateLimitPerAddress config.IntOption
+
+	auditLogRetention config.IntOption
+
+	tlsEnabled config.BoolOption
+
+	socketPathConfig config.StringOption
+	socketModeConfig config.StringOption
+
+	allowedOriginsConfig  config.StringArrayOption
+	cspDirectivesConfig   config.StringArrayOption
+	securityHeadersConfig config.StringArrayOption
+
+	persistSessions    config.BoolOption
+	sessionMaxLifetime config.IntOption
+
 	devMode config.BoolOption
 )
 
@@ -44,12 +72,12 @@ func registerConfig() error {
 	err := config.Register(&config.Option{
 		Name:            "API Listen Address",
 		Key:             CfgDefaultListenAddressKey,
-		Description:     "Defines the IP address and port on which the internal API listens.",
+		Description:     "Defines the IP address and port on which the internal API listens. Leave empty to only listen on the unix socket.",
 		OptType:         config.OptTypeString,
 		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
 		ReleaseLevel:    config.ReleaseLevelStable,
 		DefaultValue:    getDefaultListenAddress(),
-		ValidationRegex: "^([0-9]{1,3}.[0-9]{1,3}.[0-9]{1,3}.[0-9]{1,3}:[0-9]{1,5}|\\[[:0-9A-Fa-f]+\\]:[0-9]{1,5})$",
+		ValidationRegex: "^([0-9]{1,3}.[0-9]{1,3}.[0-9]{1,3}.[0-9]{1,3}:[0-9]{1,5}|\\[[:0-9A-Fa-f]+\\]:[0-9]{1,5}|)$",
 		RequiresRestart: true,
 		Annotations: config.Annotations{
 			config.DisplayOrderAnnotation: 513,
@@ -80,6 +108,223 @@ func registerConfig() error {
 	}
 	configuredAPIKeys = config.GetAsStringArray(CfgAPIKeys, []string{})
 
+	err = config.Register(&config.Option{
+		Name:           "API Rate Limit per Client",
+		Key:            CfgAPIRateLimitPerToken,
+		Description:    "Defines how many API requests per minute a single authenticated client, identified by its API key or session, may make. Requests of the software itself are not limited. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   0,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 515,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	rateLimitPerToken = config.Concurrent.GetAsInt(CfgAPIRateLimitPerToken, 0)
+
+	err = config.Register(&config.Option{
+		Name:           "API Rate Limit per Address",
+		Key:            CfgAPIRateLimitPerAddress,
+		Description:    "Defines how many API requests per minute may be made from a single remote address. Requests of the software itself are not limited. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   0,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 516,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	rateLimitPerAddress = config.Concurrent.GetAsInt(CfgAPIRateLimitPerAddress, 0)
+
+	err = config.Register(&config.Option{
+		Name:           "API Audit Log Retention",
+		Key:            CfgAPIAuditLogRetention,
+		Description:    "Defines for how many days write requests to the API, such as configuration changes, module events and database writes, are kept in the audit log. Set to 0 to disable the audit log.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelStable,
+		DefaultValue:   30,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 517,
+			config.CategoryAnnotation:     "Development",
+			config.UnitAnnotation:         "days",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	auditLogRetention = config.Concurrent.GetAsInt(CfgAPIAuditLogRetention, 30)
+
+	err = config.Register(&config.Option{
+		Name:            "API TLS",
+		Key:             CfgAPITLS,
+		Description:     "Serve the API over TLS with a self-signed certificate authority, which is generated and stored in the data directory. Clients may authenticate with certificates issued by this authority.",
+		OptType:         config.OptTypeBool,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    false,
+		RequiresRestart: true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 518,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	tlsEnabled = config.GetAsBool(CfgAPITLS, false)
+
+	err = config.Register(&config.Option{
+		Name:            "API Unix Socket",
+		Key:             CfgAPISocketPath,
+		Description:     "Defines the path of a unix socket on which the internal API additionally listens. The user and group of connecting processes are available for authentication. Leave empty to disable.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    "",
+		RequiresRestart: true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 519,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	socketPathConfig = config.GetAsString(CfgAPISocketPath, "")
+
+	err = config.Register(&config.Option{
+		Name:            "API Unix Socket File Mode",
+		Key:             CfgAPISocketMode,
+		Description:     "Defines the file mode of the API unix socket in octal notation, which controls which users may connect to it.",
+		OptType:         config.OptTypeString,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    "0660",
+		ValidationRegex: "^0?[0-7]{3}$",
+		RequiresRestart: true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 520,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	socketModeConfig = config.GetAsString(CfgAPISocketMode, "0660")
+
+	err = config.Register(&config.Option{
+		Name:           "API Allowed Origins",
+		Key:            CfgAPIAllowedOrigins,
+		Description:    "Defines additional origins that may make cross-origin requests to the API. Entries have the format `<scheme>://<host>[:<port>]`, eg. `https://example.com` or `moz-extension://<extension-id>`. Entries without a port only match the default port of the scheme, use `*` as the port to allow any port, eg. `http://localhost:*`. Browser extension origins may use `*` as the extension ID to allow any extension.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		ValidationFunc: validateStringArray(func(entry string) error {
+			_, err := parseOrigin(entry)
+			return err
+		}),
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 521,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	allowedOriginsConfig = config.Concurrent.GetAsStringArray(CfgAPIAllowedOrigins, []string{})
+
+	err = config.Register(&config.Option{
+		Name:           "API Content Security Policy",
+		Key:            CfgAPIContentSecurityPolicy,
+		Description:    "Defines directives of the Content-Security-Policy of the API, which replace the default directive with the same name. Every entry is a directive with its values, eg. `connect-src 'self' https://example.com`. The policy is not sent in development mode.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		ValidationFunc: validateStringArray(func(entry string) error {
+			_, _, err := parseCSPDirective(entry)
+			return err
+		}),
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 522,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	cspDirectivesConfig = config.Concurrent.GetAsStringArray(CfgAPIContentSecurityPolicy, []string{})
+
+	err = config.Register(&config.Option{
+		Name:           "API Security Headers",
+		Key:            CfgAPISecurityHeaders,
+		Description:    "Defines security headers that are sent with every API response, which replace the default header with the same name. Every entry has the format `<name>: <value>`, eg. `X-Frame-Options: sameorigin`. An entry without a value removes the header.",
+		OptType:        config.OptTypeStringArray,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   []string{},
+		ValidationFunc: validateStringArray(func(entry string) error {
+			_, _, err := parseSecurityHeader(entry)
+			return err
+		}),
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 523,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	securityHeadersConfig = config.Concurrent.GetAsStringArray(CfgAPISecurityHeaders, []string{})
+
+	err = config.Register(&config.Option{
+		Name:            "Persist API Sessions",
+		Key:             CfgAPIPersistSessions,
+		Description:     "Save API sessions in the database, so that clients stay authenticated when the software restarts. Only hashes of the session keys are saved. Previously saved sessions are deleted when this is disabled.",
+		OptType:         config.OptTypeBool,
+		ExpertiseLevel:  config.ExpertiseLevelDeveloper,
+		ReleaseLevel:    config.ReleaseLevelExperimental,
+		DefaultValue:    false,
+		RequiresRestart: true,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 524,
+			config.CategoryAnnotation:     "Development",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	persistSessions = config.GetAsBool(CfgAPIPersistSessions, false)
+
+	err = config.Register(&config.Option{
+		Name:           "API Session Max Lifetime",
+		Key:            CfgAPISessionMaxLifetime,
+		Description:    "Defines after how many hours API sessions expire, even if they are in use. Sessions always expire when they are not used for a few minutes. Set to 0 to disable.",
+		OptType:        config.OptTypeInt,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		ReleaseLevel:   config.ReleaseLevelExperimental,
+		DefaultValue:   0,
+		Annotations: config.Annotations{
+			config.DisplayOrderAnnotation: 525,
+			config.CategoryAnnotation:     "Development",
+			config.UnitAnnotation:         "hours",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	sessionMaxLifetime = config.Concurrent.GetAsInt(CfgAPISessionMaxLifetime, 0)
+
 	devMode = config.Concurrent.GetAsBool(config.CfgDevModeKey, false)
 
 	return nil
@@ -7,6 +7,7 @@
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/tevino/abool"
@@ -45,11 +46,37 @@
 func init() {
 	RegisterHandler("/api/database/v1", WrapInAuthHandler(
 		startDatabaseWebsocketAPI,
-		// Default to admin read/write permissions until the database gets support
-		// for api permissions.
-		dbCompatibilityPermission,
-		dbCompatibilityPermission,
+		// Users may connect, but only access records that permit access to users.
+		PermitUser,
+		PermitUser,
 	))
+
+	// Default to admin read/write permissions for all records that do not
+	// specify any required permissions.
+	RequireDatabasePermissions("", dbCompatibilityPermission, dbCompatibilityPermission)
+}
+
+// RequireDatabasePermissions sets the permissions that are required to read
+// and write database records with a key (including the database name)
+// starting with the given prefix through the database API. If multiple
+// prefixes match a key, the longest one is used. Permissions set on the
+// record itself take precedence. By default, all records require admin
+// permissions.
+func RequireDatabasePermissions(keyPrefix string, read, write Permission) {
+	database.RequirePermissions(keyPrefix, int8(read), int8(write))
+}
+
+// newDatabaseInterface returns a new database interface that enforces the
+// permissions of the given token.
+func newDatabaseInterface(token *AuthToken) *database.Interface {
+	if token == nil {
+		return database.NewInterface(nil)
+	}
+
+	return database.NewInterface(&database.Options{
+		ReadPermission:  int8(token.Read),
+		WritePermission: int8(token.Write),
+	})
 }
 
 // DatabaseAPI is a generic database API interface.
@@ -65,6 +92,19 @@ type DatabaseAPI struct {
 	db             *database.Interface
 
 	sendBytes func(data []byte)
+
+	// recordFormat is the format records are sent in. AUTO selects the legacy
+	// format: JSON with embedded metadata.
+	recordFormat uint8
+
+	// checkRateLimit optionally checks the rate limits before handling a
+	// message. It returns whether the message may be handled and, if not,
+	// after how much time it may be retried.
+	checkRateLimit func() (retryAfter time.Duration, ok bool)
+
+	// auditWrite optionally records a database write operation in the audit
+	// log.
+	auditWrite func(operation, key string, err error)
 }
 
 // DatabaseWebsocketAPI is a database websocket API interface.
@@ -73,6 +113,10 @@ type DatabaseWebsocketAPI struct {
 
 	sendQueue chan []byte
 	conn      *websocket.Conn
+
+	// batching defines whether multiple messages are sent and received in a
+	// single frame, as defined by the v2 protocols.
+	batching bool
 }
 
 func allowAnyOrigin(r *http.Request) bool {
@@ -93,9 +137,11 @@ func CreateDatabaseAPI(sendFunction func(data []byte)) DatabaseAPI {
 
 func startDatabaseWebsocketAPI(w http.ResponseWriter, r *http.Request) {
 	upgrader := websocket.Upgrader{
-		CheckOrigin:     allowAnyOrigin,
-		ReadBufferSize:  1024,
-		WriteBufferSize: 65536,
+		CheckOrigin:       allowAnyOrigin,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   65536,
+		Subprotocols:      dbProtocols,
+		EnableCompression: true,
 	}
 	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -105,17 +151,31 @@ func startDatabaseWebsocketAPI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Legacy clients do not request a protocol.
+	recordFormat, batching := dbProtocolFormats[wsConn.Subprotocol()]
+	wsConn.EnableWriteCompression(false)
+
+	token := GetAPIRequest(r).AuthToken
+	clientHost := remoteHost(r.RemoteAddr)
 	newDBAPI := &DatabaseWebsocketAPI{
 		DatabaseAPI: DatabaseAPI{
 			queries:        make(map[string]*iterator.Iterator),
 			subs:           make(map[string]*database.Subscription),
 			shutdownSignal: make(chan struct{}),
 			shuttingDown:   abool.NewBool(false),
-			db:             database.NewInterface(nil),
+			db:             newDatabaseInterface(token),
+			recordFormat:   recordFormat,
+			checkRateLimit: func() (time.Duration, bool) {
+				return checkRateLimits(clientHost, token, "", nil)
+			},
+			auditWrite: func(operation, key string, err error) {
+				auditDatabaseWrite(r.RemoteAddr, token, operation, key, err)
+			},
 		},
 
 		sendQueue: make(chan []byte, 100),
 		conn:      wsConn,
+		batching:  batching,
 	}
 
 	newDBAPI.sendBytes = func(data []byte) {
@@ -139,7 +199,11 @@ func (api *DatabaseWebsocketAPI) handler(context.Context) error {
 			return api.shutdown(err)
 		}
 
-		api.Handle(msg)
+		if api.batching {
+			api.handleBatch(msg)
+		} else {
+			api.Handle(msg)
+		}
 	}
 }
 
@@ -149,6 +213,7 @@ func (api *DatabaseWebsocketAPI) writer(ctx context.Context) error {
 	}()
 
 	var data []byte
+	var stop bool
 	var err error
 
 	for {
@@ -164,11 +229,19 @@ func (api *DatabaseWebsocketAPI) writer(ctx context.Context) error {
 			return nil
 		}
 
+		if api.batching {
+			data, stop = api.collectBatch(data)
+			api.conn.EnableWriteCompression(len(data) >= dbCompressionThreshold)
+		}
+
 		// log.Tracef("api: sending %s", string(*msg))
 		err = api.conn.WriteMessage(websocket.BinaryMessage, data)
 		if err != nil {
 			return api.shutdown(err)
 		}
+		if stop {
+			return nil
+		}
 	}
 }
 
@@ -244,6 +317,7 @@ func (api *DatabaseAPI) Handle(msg []byte) {
 		// 124|cancel
 		// 125|cancel
 		// 127|cancel
+		observeDatabaseOperation("cancel")
 		go api.handleCancel(parts[0])
 		return
 	}
@@ -253,6 +327,21 @@ func (api *DatabaseAPI) Handle(msg []byte) {
 		return
 	}
 
+	// Check rate limits.
+	if api.checkRateLimit != nil {
+		if retryAfter, ok := api.checkRateLimit(); !ok {
+			api.send(parts[0], dbMsgTypeError, fmt.Sprintf("too many requests: retry after %ds", retryAfterSeconds(retryAfter)), nil)
+			return
+		}
+	}
+
+	switch string(parts[1]) {
+	case "get", "query", "sub", "qsub", "create", "update", "insert", "delete":
+		observeDatabaseOperation(string(parts[1]))
+	default:
+		observeDatabaseOperation("unknown")
+	}
+
 	switch string(parts[1]) {
 	case "get":
 		// 123|get|<key>
@@ -277,17 +366,25 @@ func (api *DatabaseAPI) Handle(msg []byte) {
 		switch string(parts[1]) {
 		case "create":
 			// 128|create|<key>|<data>
-			go api.handlePut(parts[0], string(dataParts[0]), dataParts[1], true)
+			go api.audited("create", string(dataParts[0]), func() error {
+				return api.handlePut(parts[0], string(dataParts[0]), dataParts[1], true)
+			})
 		case "update":
 			// 129|update|<key>|<data>
-			go api.handlePut(parts[0], string(dataParts[0]), dataParts[1], false)
+			go api.audited("update", string(dataParts[0]), func() error {
+				return api.handlePut(parts[0], string(dataParts[0]), dataParts[1], false)
+			})
 		case "insert":
 			// 130|insert|<key>|<data>
-			go api.handleInsert(parts[0], string(dataParts[0]), dataParts[1])
+			go api.audited("insert", string(dataParts[0]), func() error {
+				return api.handleInsert(parts[0], string(dataParts[0]), dataParts[1])
+			})
 		}
 	case "delete":
 		// 131|delete|<key>
-		go api.handleDelete(parts[0], string(parts[2]))
+		go api.audited("delete", string(parts[2]), func() error {
+			return api.handleDelete(parts[0], string(parts[2]))
+		})
 	default:
 		api.send(parts[0], dbMsgTypeError, "bad request: unknown method", nil)
 	}
@@ -320,7 +417,7 @@ func (api *DatabaseAPI) handleGet(opID []byte, key string) {
 
 	r, err := api.db.Get(key)
 	if err == nil {
-		data, err = MarshalRecord(r, true)
+		data, err = api.marshalRecord(r)
 	}
 	if err != nil {
 		api.send(opID, dbMsgTypeError, err.Error(), nil)
@@ -378,7 +475,7 @@ func (api *DatabaseAPI) processQuery(opID []byte, q *query.Query) (ok bool) {
 			// process query feed
 			if r != nil {
 				// process record
-				data, err := MarshalRecord(r, true)
+				data, err := api.marshalRecord(r)
 				if err != nil {
 					api.send(opID, dbMsgTypeWarning, err.Error(), nil)
 					continue
@@ -455,7 +552,7 @@ func (api *DatabaseAPI) processSub(opID []byte, sub *database.Subscription) {
 			// process sub feed
 			if r != nil {
 				// process record
-				data, err := MarshalRecord(r, true)
+				data, err := api.marshalRecord(r)
 				if err != nil {
 					api.send(opID, dbMsgTypeWarning, err.Error(), nil)
 					continue
@@ -554,7 +651,15 @@ func (api *DatabaseAPI) cancelSub(opID []byte) {
 	// The subscription handler will end the communication with a done message.
 }
 
-func (api *DatabaseAPI) handlePut(opID []byte, key string, data []byte, create bool) {
+// audited runs the given write operation and records it in the audit log.
+func (api *DatabaseAPI) audited(operation, key string, fn func() error) {
+	err := fn()
+	if api.auditWrite != nil {
+		api.auditWrite(operation, key, err)
+	}
+}
+
+func (api *DatabaseAPI) handlePut(opID []byte, key string, data []byte, create bool) error {
 	// 128|create|<key>|<data>
 	//    128|success
 	//    128|error|<message>
@@ -565,7 +670,7 @@ func (api *DatabaseAPI) handlePut(opID []byte, key string, data []byte, create b
 
 	if len(data) < 2 {
 		api.send(opID, dbMsgTypeError, "bad request: malformed message", nil)
-		return
+		return errors.New("bad request: malformed message")
 	}
 
 	// TODO - staged for deletion: remove transition code
@@ -576,36 +681,55 @@ func (api *DatabaseAPI) handlePut(opID []byte, key string, data []byte, create b
 	// 	data = typedData
 	// }
 
-	r, err := record.NewWrapper(key, nil, data[0], data[1:])
+	err := putRecordData(api.db, key, data[0], data[1:], create)
 	if err != nil {
 		api.send(opID, dbMsgTypeError, err.Error(), nil)
-		return
+		return err
 	}
+	api.send(opID, dbMsgTypeSuccess, emptyString, nil)
+	return nil
+}
 
-	if create {
-		err = api.db.PutNew(r)
-	} else {
-		err = api.db.Put(r)
-	}
+// putRecordData saves the given serialized data as a record with the given key.
+func putRecordData(db *database.Interface, key string, format uint8, data []byte, create bool) error {
+	r, err := record.NewWrapper(key, nil, format, data)
 	if err != nil {
-		api.send(opID, dbMsgTypeError, err.Error(), nil)
-		return
+		return err
 	}
-	api.send(opID, dbMsgTypeSuccess, emptyString, nil)
+
+	if create {
+		return db.PutNew(r)
+	}
+	return db.Put(r)
 }
 
-func (api *DatabaseAPI) handleInsert(opID []byte, key string, data []byte) {
+func (api *DatabaseAPI) handleInsert(opID []byte, key string, data []byte) error {
 	// 130|insert|<key>|<data>
 	//    130|success
 	//    130|error|<message>
 
-	r, err := api.db.Get(key)
+	err := insertRecordValues(api.db, key, data)
 	if err != nil {
 		api.send(opID, dbMsgTypeError, err.Error(), nil)
-		return
+		return err
+	}
+
+	api.send(opID, dbMsgTypeSuccess, emptyString, nil)
+	return nil
+}
+
+// insertRecordValues sets the values of the given JSON map in the record with
+// the given key.
+func insertRecordValues(db *database.Interface, key string, data []byte) error {
+	r, err := db.Get(key)
+	if err != nil {
+		return err
 	}
 
 	acc := r.GetAccessor(r)
+	if acc == nil {
+		return errors.New("record does not support inserting values")
+	}
 
 	result := gjson.ParseBytes(data)
 	anythingPresent := false
@@ -629,24 +753,16 @@ func (api *DatabaseAPI) handleInsert(opID []byte, key string, data []byte) {
 	})
 
 	if insertError != nil {
-		api.send(opID, dbMsgTypeError, insertError.Error(), nil)
-		return
+		return insertError
 	}
 	if !anythingPresent {
-		api.send(opID, dbMsgTypeError, "could not find any valid values", nil)
-		return
+		return errors.New("could not find any valid values")
 	}
 
-	err = api.db.Put(r)
-	if err != nil {
-		api.send(opID, dbMsgTypeError, err.Error(), nil)
-		return
-	}
-
-	api.send(opID, dbMsgTypeSuccess, emptyString, nil)
+	return db.Put(r)
 }
 
-func (api *DatabaseAPI) handleDelete(opID []byte, key string) {
+func (api *DatabaseAPI) handleDelete(opID []byte, key string) error {
 	// 131|delete|<key>
 	//    131|success
 	//    131|error|<message>
@@ -654,9 +770,10 @@ func (api *DatabaseAPI) handleDelete(opID []byte, key string) {
 	err := api.db.Delete(key)
 	if err != nil {
 		api.send(opID, dbMsgTypeError, err.Error(), nil)
-		return
+		return err
 	}
 	api.send(opID, dbMsgTypeSuccess, emptyString, nil)
+	return nil
 }
 
 // MarshalRecord locks and marshals the given record, additionally adding
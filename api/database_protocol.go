@@ -0,0 +1,133 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/formats/dsd"
+)
+
+// Database websocket protocols are negotiated with the Sec-WebSocket-Protocol
+// header. Clients that do not request a protocol use the legacy protocol,
+// which sends every message in its own frame and records as JSON with
+// embedded metadata.
+//
+// The v2 protocols send one or more messages per frame, each prefixed with its
+// varint encoded length. The messages themselves are unchanged, except for
+// record data, which is sent as a block with the record metadata, followed by
+// the record. Both are serialized in the format of the protocol and include
+// their DSD format identifier. Wrapped records are always sent in the format
+// they are stored in. Large frames are compressed, if the client supports
+// per-message compression.
+const (
+	dbProtocolV2JSON    = "portbase.database.v2.json"
+	dbProtocolV2MsgPack = "portbase.database.v2.msgpack"
+	dbProtocolV2CBOR    = "portbase.database.v2.cbor"
+
+	// dbBatchMaxSize is the size at which no more messages are added to a
+	// batched frame.
+	dbBatchMaxSize = 65536
+
+	// dbCompressionThreshold is the minimum size of a frame to be compressed.
+	dbCompressionThreshold = 1024
+)
+
+var (
+	// dbProtocols holds the supported protocols in order of preference.
+	dbProtocols = []string{
+		dbProtocolV2MsgPack,
+		dbProtocolV2CBOR,
+		dbProtocolV2JSON,
+	}
+
+	dbProtocolFormats = map[string]uint8{
+		dbProtocolV2JSON:    dsd.JSON,
+		dbProtocolV2MsgPack: dsd.MsgPack,
+		dbProtocolV2CBOR:    dsd.CBOR,
+	}
+
+	errMalformedBatch = errors.New("malformed batch")
+)
+
+// marshalRecord marshals the given record for sending it to the client.
+func (api *DatabaseAPI) marshalRecord(r record.Record) ([]byte, error) {
+	if api.recordFormat == dsd.AUTO {
+		return MarshalRecord(r, true)
+	}
+	return marshalRecordWithMeta(r, api.recordFormat)
+}
+
+// marshalRecordWithMeta locks and marshals the given record in the given
+// format and prepends its metadata as a block.
+func marshalRecordWithMeta(r record.Record, format uint8) ([]byte, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	meta, err := dsd.Dump(r.Meta(), format)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wrapped records can only be marshaled in the format they are stored in.
+	recordFormat := format
+	if r.IsWrapped() {
+		recordFormat = dsd.AUTO
+	}
+	data, err := r.Marshal(r, recordFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	c := container.New()
+	c.AppendAsBlock(meta)
+	c.Append(data)
+	return c.CompileData(), nil
+}
+
+// handleBatch handles all messages of a batched frame.
+func (api *DatabaseAPI) handleBatch(frame []byte) {
+	msgs, err := unpackDBBatch(frame)
+	if err != nil {
+		api.send(nil, dbMsgTypeError, "bad request: "+err.Error(), nil)
+		return
+	}
+	for _, msg := range msgs {
+		api.Handle(msg)
+	}
+}
+
+// unpackDBBatch returns the messages of a batched frame.
+func unpackDBBatch(frame []byte) ([][]byte, error) {
+	var msgs [][]byte
+	c := container.New(frame)
+	for c.HoldsData() {
+		msg, err := c.GetNextBlock()
+		if err != nil {
+			return nil, errMalformedBatch
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// collectBatch packs the given message and any further queued messages into a
+// batched frame. It returns whether the writer should stop after sending the
+// frame.
+func (api *DatabaseWebsocketAPI) collectBatch(first []byte) (frame []byte, stop bool) {
+	c := container.New()
+	c.AppendAsBlock(first)
+
+	for c.Length() < dbBatchMaxSize {
+		select {
+		case data := <-api.sendQueue:
+			if len(data) == 0 {
+				return c.CompileData(), true
+			}
+			c.AppendAsBlock(data)
+		default:
+			return c.CompileData(), false
+		}
+	}
+	return c.CompileData(), false
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/formats/dsd"
+)
+
+func TestDatabaseProtocols(t *testing.T) {
+	t.Parallel()
+
+	_, err := database.Register(&database.Database{
+		Name:        "testing-ws",
+		Description: "Unit Test Database for the Websocket Protocols",
+		StorageType: "hashmap",
+	})
+	require.NoError(t, err)
+	RequireDatabasePermissions("testing-ws:", PermitUser, PermitUser)
+	db := database.NewInterface(nil)
+	for _, msg := range []string{"a", "b", "c"} {
+		r := &actionTestRecord{Msg: msg}
+		r.SetKey("testing-ws:list/" + msg)
+		require.NoError(t, db.Put(r))
+	}
+
+	// Serve the websocket API with a user token.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ar := &Request{
+			Request:   r,
+			AuthToken: &AuthToken{Read: PermitUser, Write: PermitUser},
+		}
+		r = r.WithContext(context.WithValue(r.Context(), RequestContextKey, ar))
+		startDatabaseWebsocketAPI(w, r)
+	}))
+	defer srv.Close()
+
+	dial := func(protocols ...string) *websocket.Conn {
+		dialer := &websocket.Dialer{
+			Subprotocols:      protocols,
+			EnableCompression: true,
+		}
+		conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil) //nolint:bodyclose // Not needed.
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		return conn
+	}
+
+	t.Run("legacy", func(t *testing.T) { //nolint:paralleltest // Shares the server.
+		conn := dial()
+		defer func() {
+			_ = conn.Close()
+		}()
+		assert.Equal(t, "", conn.Subprotocol())
+
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("1|query|query testing-ws:list/")))
+		var records int
+		for {
+			_, msg, err := conn.ReadMessage()
+			require.NoError(t, err)
+			if string(msg) == "1|done" {
+				break
+			}
+			// Every message has its own frame.
+			parts := bytes.SplitN(msg, []byte("|"), 4)
+			require.Len(t, parts, 4, string(msg))
+			assert.Equal(t, "ok", string(parts[1]))
+			assert.Equal(t, byte(dsd.JSON), parts[3][0])
+			assert.Contains(t, string(parts[3]), `"_meta"`)
+			records++
+		}
+		assert.Equal(t, 3, records)
+	})
+
+	t.Run("v2", func(t *testing.T) { //nolint:paralleltest // Shares the server.
+		conn := dial(dbProtocolV2MsgPack)
+		defer func() {
+			_ = conn.Close()
+		}()
+		require.Equal(t, dbProtocolV2MsgPack, conn.Subprotocol())
+
+		// Send multiple requests in one frame.
+		value, err := dsd.Dump(&actionTestRecord{Msg: "d"}, dsd.MsgPack)
+		require.NoError(t, err)
+		c := container.New()
+		c.AppendAsBlock([]byte("1|query|query testing-ws:list/"))
+		c.AppendAsBlock(append([]byte("2|update|testing-ws:d|"), value...))
+		require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, c.CompileData()))
+
+		var records int
+		var queryDone, updateDone bool
+		for !queryDone || !updateDone {
+			_, frame, err := conn.ReadMessage()
+			require.NoError(t, err)
+			msgs, err := unpackDBBatch(frame)
+			require.NoError(t, err)
+
+			for _, msg := range msgs {
+				parts := bytes.SplitN(msg, []byte("|"), 4)
+				switch string(parts[0]) + "|" + string(parts[1]) {
+				case "1|done":
+					queryDone = true
+				case "2|success":
+					updateDone = true
+				case "1|ok":
+					require.Len(t, parts, 4)
+					// Records are sent with separate metadata.
+					data := container.New(parts[3])
+					metaData, err := data.GetNextBlock()
+					require.NoError(t, err)
+					meta := &record.Meta{}
+					_, err = dsd.Load(metaData, meta)
+					require.NoError(t, err)
+					assert.NotZero(t, meta.Created)
+
+					recordData := data.CompileData()
+					assert.Equal(t, byte(dsd.MsgPack), recordData[0])
+					r := &actionTestRecord{}
+					_, err = dsd.Load(recordData, r)
+					require.NoError(t, err)
+					assert.Equal(t, strings.TrimPrefix(string(parts[2]), "testing-ws:list/"), r.Msg)
+					records++
+				default:
+					t.Fatalf("unexpected message: %s", msg)
+				}
+			}
+		}
+		assert.Equal(t, 3, records)
+
+		// The record was saved in the format it was sent in.
+		r, err := db.Get("testing-ws:d")
+		require.NoError(t, err)
+		wrapper, ok := r.(*record.Wrapper)
+		require.True(t, ok)
+		assert.Equal(t, uint8(dsd.MsgPack), wrapper.Format)
+	})
+}
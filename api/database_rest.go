@@ -0,0 +1,270 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+)
+
+const databaseRESTPath = "/api/database/v1/records"
+
+func init() {
+	RegisterHandler(databaseRESTPath, &databaseRESTHandler{})
+	RegisterHandler(databaseRESTPath+"/{key:.+}", &databaseRESTHandler{})
+}
+
+// databaseRESTHandler maps the operations of the database API to HTTP methods:
+//
+//	GET    /records?q=<query>  query records
+//	GET    /records/<key>      get record
+//	POST   /records/<key>      create record
+//	PUT    /records/<key>      update record
+//	PATCH  /records/<key>      insert values into record
+//	DELETE /records/<key>      delete record
+//
+// Record data is sent and received in the format of the Content-Type and
+// Accept headers. Records sent by clients are kept in the format they were
+// sent in. Queries and inserts only support JSON.
+//
+// Records have an ETag and Last-Modified, which may be used for conditional
+// requests. Writes may use If-Match to only change the record if it was not
+// modified in the meantime. This is only guaranteed in regard to other
+// conditional writes through this handler.
+type databaseRESTHandler struct{}
+
+// ReadPermission returns the read permission for the handler.
+func (h *databaseRESTHandler) ReadPermission(*http.Request) Permission {
+	return PermitUser
+}
+
+// WritePermission returns the write permission for the handler.
+func (h *databaseRESTHandler) WritePermission(*http.Request) Permission {
+	return PermitUser
+}
+
+// ServeHTTP handles the http request.
+func (h *databaseRESTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ar := GetAPIRequest(r)
+	if ar == nil {
+		http.Error(w, "Missing API request.", http.StatusInternalServerError)
+		return
+	}
+	db := newDatabaseInterface(ar.AuthToken)
+	key := ar.URLVars["key"]
+
+	// Queries do not have a key.
+	if key == "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleQuery(w, r, db)
+		return
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		h.handleGet(w, r, db, key)
+		return
+	}
+
+	// Check precondition of write. Conditional writes through this handler
+	// are serialized per key, so that the check and the write are atomic.
+	// Writes through other APIs are not covered.
+	if ar.Header.Get("If-Match") != "" {
+		lock := conditionalWriteLock(key)
+		lock.Lock()
+		defer lock.Unlock()
+	}
+	if err := h.checkIfMatch(ar, db, key); err != nil {
+		http.Error(w, err.Error(), databaseErrorStatus(err))
+		return
+	}
+
+	// Limit the size of record data.
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+
+	var err error
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		err = h.handlePut(r, db, key, r.Method == http.MethodPost)
+	case http.MethodPatch:
+		var data []byte
+		data, err = io.ReadAll(r.Body)
+		if err == nil {
+			err = insertRecordValues(db, key, data)
+		}
+	case http.MethodDelete:
+		err = db.Delete(key)
+	default:
+		http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), databaseErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *databaseRESTHandler) handleGet(w http.ResponseWriter, r *http.Request, db *database.Interface, key string) {
+	rec, err := db.Get(key)
+	if err != nil {
+		http.Error(w, err.Error(), databaseErrorStatus(err))
+		return
+	}
+
+	// Add validators and check if the client already has the record.
+	rec.Lock()
+	notModified := writeValidators(w, r, RecordETag(rec), recordLastModified(rec))
+	rec.Unlock()
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Marshal record in the requested format.
+	format := dsd.FormatFromAccept(r.Header.Get("Accept"))
+	if format == dsd.AUTO {
+		http.Error(w, "Unsupported format requested.", http.StatusNotAcceptable)
+		return
+	}
+	// Wrapped records can only be returned in the format they were saved in.
+	if wrapper, ok := rec.(*record.Wrapper); ok && wrapper.Format != format {
+		http.Error(w, fmt.Sprintf("Record is only available as %s.", dsd.FormatToMimeType[wrapper.Format]), http.StatusNotAcceptable)
+		return
+	}
+
+	var data []byte
+	switch format {
+	case dsd.JSON:
+		// Include metadata in JSON.
+		data, err = MarshalRecord(rec, false)
+	default:
+		rec.Lock()
+		data, err = rec.Marshal(rec, format)
+		rec.Unlock()
+		data = bytes.TrimPrefix(data, varint.Pack8(format))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dsd.FormatToMimeType[format])
+	_, _ = w.Write(data)
+}
+
+// checkIfMatch checks the If-Match header against the current version of the
+// record. The conditionalWriteLock of the key must be held, if the header is set.
+func (h *databaseRESTHandler) checkIfMatch(ar *Request, db *database.Interface, key string) error {
+	if ar.Header.Get("If-Match") == "" {
+		return nil
+	}
+
+	var etag string
+	rec, err := db.Get(key)
+	switch {
+	case err == nil:
+		rec.Lock()
+		etag = RecordETag(rec)
+		rec.Unlock()
+	case !errors.Is(err, database.ErrNotFound):
+		return err
+	}
+	return ar.CheckIfMatch(etag)
+}
+
+func (h *databaseRESTHandler) handlePut(r *http.Request, db *database.Interface, key string, create bool) error {
+	// Get format from the content type.
+	format := uint8(dsd.DefaultSerializationFormat)
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		format = dsd.FormatFromAccept(contentType)
+		if format == dsd.AUTO {
+			return ErrorWithStatus(
+				fmt.Errorf("unsupported content type: %s", contentType),
+				http.StatusUnsupportedMediaType,
+			)
+		}
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return ErrorWithStatus(dsd.ErrMissingBody, http.StatusBadRequest)
+	}
+
+	return putRecordData(db, key, format, data, create)
+}
+
+func (h *databaseRESTHandler) handleQuery(w http.ResponseWriter, r *http.Request, db *database.Interface) {
+	q, err := query.ParseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	it, err := db.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), databaseErrorStatus(err))
+		return
+	}
+
+	// Stream records as a JSON array.
+	w.Header().Set("Content-Type", MimeTypeJSON)
+	_, _ = w.Write([]byte("["))
+	first := true
+	for rec := range it.Next {
+		data, err := MarshalRecord(rec, false)
+		if err != nil {
+			log.Tracer(r.Context()).Warningf("api: failed to marshal record %s: %s", rec.Key(), err)
+			continue
+		}
+		if !first {
+			_, _ = w.Write([]byte(","))
+		}
+		first = false
+		if _, err := w.Write(data); err != nil {
+			it.Cancel()
+			return
+		}
+	}
+	_, _ = w.Write([]byte("]"))
+
+	// The status was already sent, so errors can only be logged.
+	if it.Err() != nil {
+		log.Tracer(r.Context()).Warningf("api: database query failed: %s", it.Err())
+	}
+}
+
+// databaseErrorStatus returns the HTTP status code for the given database
+// error.
+func databaseErrorStatus(err error) int {
+	var statusProvider HTTPStatusProvider
+	switch {
+	case errors.As(err, &statusProvider):
+		return statusProvider.HTTPStatus()
+	case errors.As(err, new(*http.MaxBytesError)):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, database.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, database.ErrReadOnly):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, database.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/database"
+)
+
+func TestDatabaseREST(t *testing.T) {
+	t.Parallel()
+
+	_, err := database.Register(&database.Database{
+		Name:        "testing-rest",
+		Description: "Unit Test Database for the REST API",
+		StorageType: "hashmap",
+	})
+	require.NoError(t, err)
+	RequireDatabasePermissions("testing-rest:", PermitUser, PermitUser)
+	RequireDatabasePermissions("testing-rest:admin/", PermitAdmin, PermitAdmin)
+
+	// serve handles a request with a user token.
+	serve := func(method, key, body string, header http.Header) *httptest.ResponseRecorder {
+		target := databaseRESTPath
+		if key != "" {
+			target += "/" + key
+		}
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		ar := &Request{
+			Request:   r,
+			AuthToken: &AuthToken{Read: PermitUser, Write: PermitUser},
+			URLVars:   map[string]string{},
+		}
+		if key != "" {
+			ar.URLVars["key"] = key
+		}
+		r = r.WithContext(context.WithValue(r.Context(), RequestContextKey, ar))
+		w := httptest.NewRecorder()
+		(&databaseRESTHandler{}).ServeHTTP(w, r)
+		return w
+	}
+
+	// Create and get a record.
+	w := serve(http.MethodPost, "testing-rest:a", `{"Msg":"a"}`, nil)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = serve(http.MethodGet, "testing-rest:a", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, MimeTypeJSON, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"Msg":"a"`)
+	assert.Contains(t, w.Body.String(), `"_meta"`)
+
+	// Wrapped records keep their format.
+	w = serve(http.MethodGet, "testing-rest:a", "", http.Header{"Accept": {"application/yaml"}})
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+
+	// Create a record with YAML.
+	w = serve(http.MethodPut, "testing-rest:b", "msg: b\n", http.Header{"Content-Type": {"application/yaml"}})
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = serve(http.MethodGet, "testing-rest:b", "", http.Header{"Accept": {"application/yaml"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+	assert.Equal(t, "msg: b\n", w.Body.String())
+
+	// Unsupported formats are rejected.
+	w = serve(http.MethodPut, "testing-rest:c", "b", http.Header{"Content-Type": {"text/plain"}})
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	// Update and insert a value.
+	w = serve(http.MethodPut, "testing-rest:a", `{"Msg":"b"}`, nil)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = serve(http.MethodPatch, "testing-rest:a", `{"Count":1}`, nil)
+	require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	w = serve(http.MethodGet, "testing-rest:a", "", nil)
+	assert.Contains(t, w.Body.String(), `"Msg":"b"`)
+	assert.Contains(t, w.Body.String(), `"Count":1`)
+
+	// Conditional requests.
+	w = serve(http.MethodGet, "testing-rest:a", "", nil)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	w = serve(http.MethodGet, "testing-rest:a", "", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = serve(http.MethodPut, "testing-rest:a", `{"Msg":"b","Count":2}`, http.Header{"If-Match": {`"0"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = serve(http.MethodPut, "testing-rest:a", `{"Msg":"b","Count":2}`, http.Header{"If-Match": {etag}})
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	// Writes within the same second change the ETag, so that only one of
+	// concurrent conditional writes succeeds.
+	w = serve(http.MethodGet, "testing-rest:a", "", nil)
+	etag = w.Header().Get("ETag")
+	codes := make(chan int, 2)
+	for _, body := range []string{`{"Msg":"c","Count":3}`, `{"Msg":"d","Count":3}`} {
+		go func(body string) {
+			codes <- serve(http.MethodPut, "testing-rest:a", body, http.Header{"If-Match": {etag}}).Code
+		}(body)
+	}
+	assert.ElementsMatch(t, []int{http.StatusNoContent, http.StatusPreconditionFailed}, []int{<-codes, <-codes})
+	w = serve(http.MethodGet, "testing-rest:a", "", nil)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	w = serve(http.MethodPost, "testing-rest:new", `{"Msg":"b"}`, http.Header{"If-Match": {"*"}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	// Query records.
+	r := httptest.NewRequest(http.MethodGet, databaseRESTPath+"?"+url.Values{"q": {"query testing-rest:"}}.Encode(), nil)
+	ar := &Request{
+		Request:   r,
+		AuthToken: &AuthToken{Read: PermitUser, Write: PermitUser},
+		URLVars:   map[string]string{},
+	}
+	w = httptest.NewRecorder()
+	(&databaseRESTHandler{}).ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), RequestContextKey, ar)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var records []map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &records))
+	assert.Len(t, records, 1) // The YAML record cannot be returned as JSON.
+
+	// Permissions of the database interface apply.
+	w = serve(http.MethodPost, "testing-rest:admin/a", `{"Msg":"a"}`, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Record data is limited in size.
+	tooLarge := `{"Msg":"` + strings.Repeat("a", maxRequestBodySize) + `"}`
+	w = serve(http.MethodPut, "testing-rest:large", tooLarge, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	w = serve(http.MethodPatch, "testing-rest:a", tooLarge, nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Delete the record.
+	w = serve(http.MethodDelete, "testing-rest:a", "", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = serve(http.MethodGet, "testing-rest:a", "", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+)
+
+const (
+	sseModeSub  = "sub"
+	sseModeQsub = "qsub"
+
+	sseKeepAliveInterval = 30 * time.Second
+)
+
+// sseEvent is the JSON payload of a database server-sent event.
+type sseEvent struct {
+	Key     string          `json:"key,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+func registerDatabaseEndpoints() error {
+	return RegisterEndpoint(Endpoint{
+		Path: "database/events",
+		Read: PermitUser,
+		Parameters: []Parameter{{
+			Method:      http.MethodGet,
+			Field:       "query",
+			Type:        ParamTypeString,
+			Required:    true,
+			Description: "Database query, eg. `query config:`.",
+		}, {
+			Method:      http.MethodGet,
+			Field:       "mode",
+			Type:        ParamTypeEnum,
+			Enum:        []string{sseModeSub, sseModeQsub},
+			Default:     sseModeQsub,
+			Description: "Either only subscribe to changes (sub) or query the current records first (qsub).",
+		}},
+		MimeType:    "text/event-stream",
+		HandlerFunc: handleDatabaseEvents,
+		Name:        "Subscribe to Database Records",
+		Description: "Streams database records matching the query as Server-Sent Events. " +
+			"The event type is the message type of the websocket database API and the data is a JSON object with the key and record, or a message. " +
+			"Changes and the end of the query carry an event ID: When reconnecting with a Last-Event-ID, only records modified since then are sent before subscribing. Deletions during the disconnect are not sent.",
+	})
+}
+
+func handleDatabaseEvents(w http.ResponseWriter, r *http.Request) {
+	ar := GetAPIRequest(r)
+	if ar == nil {
+		http.Error(w, "Missing API request.", http.StatusInternalServerError)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	// Parse query.
+	q, err := query.ParseQuery(ar.Params.String("query"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Parse Last-Event-ID to resume a previous stream.
+	var since int64
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		since, err = strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID.", http.StatusBadRequest)
+			return
+		}
+	}
+	queryFirst := ar.Params.String("mode") == sseModeQsub || since > 0
+
+	// Subscribe before querying in order to not miss any changes. All records
+	// modified before the subscription are covered by the query.
+	subscribed := time.Now().Unix()
+	db := newDatabaseInterface(ar.AuthToken)
+	sub, err := db.Subscribe(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = sub.Cancel()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// send sends an event. The ID must only be set when all records modified
+	// before it were sent, as the stream resumes from there.
+	send := func(eventType string, id int64, rec record.Record, message string) bool {
+		event := &sseEvent{Message: message}
+		if rec != nil {
+			event.Key = rec.Key()
+			if eventType != dbMsgTypeDel {
+				data, err := MarshalRecord(rec, false)
+				if err != nil {
+					eventType = dbMsgTypeWarning
+					event.Message = err.Error()
+				} else {
+					event.Data = data
+				}
+			}
+		}
+		if err := writeSSEvent(w, eventType, id, event); err != nil {
+			log.Tracer(r.Context()).Debugf("api: failed to write database event: %s", err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// Send current records.
+	if queryFirst {
+		it, err := db.Query(q)
+		if err != nil {
+			send(dbMsgTypeError, 0, nil, err.Error())
+			return
+		}
+		// Records are sent in key order, so they do not carry an ID.
+		for rec := range it.Next {
+			rec.Lock()
+			modified := rec.Meta().Modified
+			rec.Unlock()
+			// Use >= as modification times only have a precision of one second.
+			if modified >= since && !send(dbMsgTypeOk, 0, rec, "") {
+				it.Cancel()
+				return
+			}
+		}
+		if it.Err() != nil {
+			send(dbMsgTypeError, 0, nil, it.Err().Error())
+			return
+		}
+		if !send(dbMsgTypeDone, subscribed, nil, "") {
+			return
+		}
+	}
+
+	// Stream changes.
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-module.Stopping():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case rec := <-sub.Feed:
+			if rec == nil {
+				send(dbMsgTypeDone, 0, nil, "")
+				return
+			}
+			// Changes are sent in order, so they carry their modification time.
+			rec.Lock()
+			modified := rec.Meta().Modified
+			rec.Unlock()
+			if !send(sseChangeType(rec), modified, rec, "") {
+				return
+			}
+		}
+	}
+}
+
+// sseChangeType returns the message type of a changed record.
+func sseChangeType(r record.Record) string {
+	r.Lock()
+	defer r.Unlock()
+
+	switch {
+	case r.Meta().IsDeleted():
+		return dbMsgTypeDel
+	case r.Meta().Created == r.Meta().Modified:
+		return dbMsgTypeNew
+	default:
+		return dbMsgTypeUpd
+	}
+}
+
+// writeSSEvent writes a server-sent event with the given type, ID and JSON
+// encoded data.
+func writeSSEvent(w http.ResponseWriter, eventType string, id int64, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id > 0 {
+		_, err = fmt.Fprintf(w, "event: %s\nid: %d\ndata: %s\n\n", eventType, id, jsonData)
+	} else {
+		_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, jsonData)
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/database"
+	_ "github.com/safing/portbase/database/storage/hashmap"
+)
+
+type sseTestEvent struct {
+	Type string
+	ID   string
+	Data sseEvent
+}
+
+func TestDatabaseEvents(t *testing.T) {
+	t.Parallel()
+
+	_, err := database.Register(&database.Database{
+		Name:        "testing-sse",
+		Description: "Unit Test Database for Server-Sent Events",
+		StorageType: "hashmap",
+	})
+	require.NoError(t, err)
+	RequireDatabasePermissions("testing-sse:", PermitUser, PermitUser)
+	db := database.NewInterface(nil)
+
+	putRecord := func(key, msg string) {
+		r := &actionTestRecord{Msg: msg}
+		r.SetKey(key)
+		require.NoError(t, db.Put(r))
+	}
+	putRecord("testing-sse:a", "a")
+
+	// Serve events with a user token.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ar := &Request{
+			Request:   r,
+			AuthToken: &AuthToken{Read: PermitUser, Write: PermitUser},
+			Params: ParameterValues{
+				"query": "query testing-sse:",
+				"mode":  r.URL.Query().Get("mode"),
+			},
+		}
+		r = r.WithContext(context.WithValue(r.Context(), RequestContextKey, ar))
+		handleDatabaseEvents(w, r)
+	}))
+	defer srv.Close()
+
+	// stream connects and returns a function that reads the next event.
+	stream := func(mode, lastEventID string) (next func() sseTestEvent, cancel func()) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?mode="+mode, nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req) //nolint:bodyclose // Closed via context.
+		require.NoError(t, err)
+		require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		scanner := bufio.NewScanner(resp.Body)
+		return func() sseTestEvent {
+			var event sseTestEvent
+			for scanner.Scan() {
+				line := scanner.Text()
+				switch {
+				case line == "" && event.Type != "":
+					return event
+				case strings.HasPrefix(line, "event: "):
+					event.Type = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "id: "):
+					event.ID = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "data: "):
+					require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Data))
+				}
+			}
+			t.Fatalf("stream ended: %v", scanner.Err())
+			return event
+		}, cancel
+	}
+
+	// Query and subscribe.
+	next, cancel := stream(sseModeQsub, "")
+	defer cancel()
+	event := next()
+	assert.Equal(t, dbMsgTypeOk, event.Type)
+	assert.Equal(t, "testing-sse:a", event.Data.Key)
+	assert.Empty(t, event.ID, "records of the query are not sent in order")
+	assert.Contains(t, string(event.Data.Data), `"Msg":"a"`)
+	event = next()
+	assert.Equal(t, dbMsgTypeDone, event.Type)
+	assert.NotEmpty(t, event.ID)
+
+	putRecord("testing-sse:b", "b")
+	event = next()
+	assert.Equal(t, dbMsgTypeNew, event.Type)
+	assert.Equal(t, "testing-sse:b", event.Data.Key)
+	assert.NotEmpty(t, event.ID)
+
+	// Resuming sends the records modified since the last event.
+	resumeNext, resumeCancel := stream(sseModeSub, event.ID)
+	defer resumeCancel()
+	for {
+		event = resumeNext()
+		if event.Type == dbMsgTypeDone {
+			break
+		}
+		assert.Equal(t, dbMsgTypeOk, event.Type)
+		if event.Data.Key == "testing-sse:b" {
+			break
+		}
+	}
+	assert.Equal(t, "testing-sse:b", event.Data.Key)
+
+	// Resuming skips records that were not modified since the last event.
+	skipNext, skipCancel := stream(sseModeSub, "9999999999")
+	defer skipCancel()
+	assert.Equal(t, dbMsgTypeDone, skipNext().Type)
+}
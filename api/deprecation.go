@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+// Deprecation describes the deprecation of an endpoint.
+type Deprecation struct {
+	// Since optionally is the time the endpoint was deprecated.
+	Since time.Time `json:",omitempty"`
+	// Sunset optionally is the time the endpoint will be removed.
+	Sunset time.Time `json:",omitempty"`
+	// Replacement optionally is the URL path of the endpoint that replaces
+	// the deprecated endpoint, eg. "/api/v2/config/options".
+	Replacement string `json:",omitempty"`
+}
+
+// deprecationLogInterval defines how often the usage of a deprecated endpoint
+// is logged.
+const deprecationLogInterval = time.Hour
+
+var (
+	deprecationLogged     = make(map[string]time.Time)
+	deprecationLoggedLock sync.Mutex
+)
+
+// writeHeaders writes the Deprecation, Sunset and Link headers.
+func (d *Deprecation) writeHeaders(w http.ResponseWriter) {
+	if d.Since.IsZero() {
+		w.Header().Set("Deprecation", "true")
+	} else {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+	}
+	if !d.Sunset.IsZero() {
+		w.Header().Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Replacement != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, d.Replacement))
+	}
+}
+
+// logDeprecatedUsage logs that a deprecated endpoint was used. The usage of
+// an endpoint is logged at most once per deprecationLogInterval.
+func logDeprecatedUsage(e *Endpoint, ar *Request) {
+	key := endpointKey(e.Version, e.Path)
+	now := time.Now()
+
+	deprecationLoggedLock.Lock()
+	defer deprecationLoggedLock.Unlock()
+
+	if last, ok := deprecationLogged[key]; ok && now.Sub(last) < deprecationLogInterval {
+		return
+	}
+	deprecationLogged[key] = now
+
+	client := ar.RemoteAddr
+	if ar.AuthToken != nil && ar.AuthToken.Identity != "" {
+		client = ar.AuthToken.Identity
+	}
+	msg := fmt.Sprintf("api: deprecated endpoint %s used by %s", e.URLPath(), client)
+	if e.Deprecation.Replacement != "" {
+		msg += ", use " + e.Deprecation.Replacement + " instead"
+	}
+	if !e.Deprecation.Sunset.IsZero() {
+		msg += ", will be removed at " + e.Deprecation.Sunset.Format(time.RFC3339)
+	}
+	log.Warning(msg)
+}
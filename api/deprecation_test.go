@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/api/client"
+)
+
+func TestEndpointVersions(t *testing.T) {
+	t.Parallel()
+
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/versioned",
+		Read: PermitAnyone,
+		Deprecation: &Deprecation{
+			Since:       since,
+			Sunset:      sunset,
+			Replacement: "/api/v2/test/versioned",
+		},
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return "v1", nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path:    "test/versioned",
+		Version: 2,
+		Read:    PermitAnyone,
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return "v2", nil
+		},
+	}))
+	assert.ErrorIs(t, RegisterEndpoint(Endpoint{
+		Path:    "test/versioned",
+		Version: 2,
+		Read:    PermitAnyone,
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return "", nil
+		},
+	}), ErrAlreadyRegistered)
+	assert.Error(t, RegisterEndpoint(Endpoint{
+		Path:        "test/versioned-invalid",
+		Read:        PermitAnyone,
+		Deprecation: &Deprecation{Replacement: "api/v2/test/versioned"},
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return "", nil
+		},
+	}))
+
+	// Versions are served side by side.
+	assert.HTTPBodyContains(t, testHandler.ServeHTTP, http.MethodGet, "/api/v1/test/versioned", nil, "v1")
+	assert.HTTPBodyContains(t, testHandler.ServeHTTP, http.MethodGet, "/api/v2/test/versioned", nil, "v2")
+	assert.HTTPStatusCode(t, testHandler.ServeHTTP, http.MethodGet, "/api/v3/test/versioned", nil, http.StatusNotFound)
+
+	ep, err := GetEndpointByPath("test/versioned")
+	require.NoError(t, err)
+	assert.Equal(t, 1, ep.Version)
+	ep, err = GetEndpoint(2, "test/versioned")
+	require.NoError(t, err)
+	assert.Equal(t, "/api/v2/test/versioned", ep.URLPath())
+
+	// Deprecated endpoints announce their deprecation.
+	rec := httptest.NewRecorder()
+	testHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/test/versioned", nil))
+	assert.Equal(t, "@1767225600", rec.Header().Get("Deprecation"))
+	assert.Equal(t, "Fri, 01 Jan 2027 00:00:00 GMT", rec.Header().Get("Sunset"))
+	assert.Equal(t, `</api/v2/test/versioned>; rel="successor-version"`, rec.Header().Get("Link"))
+
+	rec = httptest.NewRecorder()
+	testHandler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/test/versioned", nil))
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+
+	// Clients use their configured version.
+	srv := httptest.NewServer(testHandler)
+	defer srv.Close()
+	ctx := context.Background()
+	msg, err := client.NewHTTPClient(srv.URL, nil).Action(ctx, "test/versioned", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", msg)
+	v2Client := client.NewHTTPClient(srv.URL, &client.HTTPOptions{Version: 2})
+	msg, err = v2Client.Action(ctx, "test/versioned", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", msg)
+	cep, err := v2Client.Endpoint(ctx, "test/versioned")
+	require.NoError(t, err)
+	assert.Equal(t, 2, cep.Version)
+	assert.Nil(t, cep.Deprecation)
+}
+
+func TestVersionedPaths(t *testing.T) {
+	t.Parallel()
+
+	for p, expected := range map[string]struct {
+		version int
+		path    string
+	}{
+		"ping":      {1, "ping"},
+		"v2/ping":   {2, "ping"},
+		"v1/v2/a":   {1, "v2/a"},
+		"v0/ping":   {1, "v0/ping"},
+		"vx/ping":   {1, "vx/ping"},
+		"v/ping":    {1, "v/ping"},
+		"v+2/ping":  {1, "v+2/ping"},
+		"version/a": {1, "version/a"},
+	} {
+		version, endpointPath := splitAPIVersion(p)
+		assert.Equal(t, expected.version, version, p)
+		assert.Equal(t, expected.path, endpointPath, p)
+	}
+
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path:    "test/versioned-paths",
+		Version: 2,
+		Read:    PermitAnyone,
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return "v2", nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path:    batchEndpointPath,
+		Version: 2,
+		Read:    PermitUser,
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return "not a batch", nil
+		},
+	}))
+
+	// The API bridge reaches other versions.
+	r, err := callAPI(&EndpointBridgeRequest{Path: "v2/test/versioned-paths"})
+	require.NoError(t, err)
+	resp, ok := r.(*EndpointBridgeResponse)
+	require.True(t, ok)
+	assert.Equal(t, "v2\n", resp.Body)
+	_, err = callAPI(&EndpointBridgeRequest{Path: "test/versioned-paths"})
+	assert.Error(t, err)
+
+	// Batches reach other versions.
+	scoped := &AuthToken{Read: PermitUser, Write: PermitUser, Scopes: []string{"test/"}}
+	testHandler := &mainHandler{
+		mux: mainMux,
+		checkAuth: func(_ http.ResponseWriter, _ *http.Request, _ bool) (*AuthToken, bool) {
+			return scoped, false
+		},
+	}
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		return w
+	}
+	w := serve(http.MethodPost, "/api/v1/batch", `[{"Path": "v2/test/versioned-paths"}, {"Path": "v2/batch"}]`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var responses []BatchResponseItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &responses))
+	require.Len(t, responses, 2)
+	assert.Equal(t, http.StatusOK, responses[0].Status)
+	assert.Equal(t, "v2\n", responses[0].Text)
+	assert.Equal(t, http.StatusForbidden, responses[1].Status)
+
+	// Only the batch endpoint itself skips the scope check.
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, "/api/v2/batch", "").Code)
+
+	// Batches may not be nested in any way.
+	w = serve(http.MethodPost, "/api/v1/batch", `[{"Method": "POST", "Path": "v1/batch"}]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = serve(http.MethodPost, "/api/v1/batch", `[{"Path": "v2/../v1/test/versioned-paths"}]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -6,10 +6,12 @@
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -33,9 +35,23 @@ type Endpoint struct {
 	// Path describes the URL path of the endpoint.
 	Path string
 
+	// Version is the version of the API the endpoint is served at, eg. 2 for
+	// "/api/v2/". Multiple versions of an endpoint may be registered with the
+	// same path. Defaults to 1.
+	Version int `json:",omitempty"`
+
+	// Deprecation optionally marks the endpoint as deprecated. Responses of
+	// deprecated endpoints have Deprecation and Sunset headers and their use
+	// is logged.
+	Deprecation *Deprecation `json:",omitempty"`
+
 	// MimeType defines the content type of the returned data.
 	MimeType string
 
+	// Kind is the kind of the endpoint function. It is set when the endpoint
+	// is registered and lets clients know how to handle the response.
+	Kind EndpointKind `json:",omitempty"`
+
 	// Read defines the required read permission.
 	Read Permission `json:",omitempty"`
 
@@ -55,6 +71,7 @@ type Endpoint struct {
 	// Available methods are:
 	// POST: Create a new resource; Change a status; Execute a function
 	// PUT: Update an existing resource
+	// PATCH: Partially update an existing resource
 	// DELETE: Remove an existing resource
 	// If omitted, defaults to POST.
 	//
@@ -62,6 +79,28 @@ type Endpoint struct {
 	// access if the write method does not match.
 	WriteMethod string `json:",omitempty"`
 
+	// RateLimit optionally defines how many requests a single client may make
+	// to this endpoint. Clients are identified by their remote address.
+	RateLimit *RateLimit `json:",omitempty"`
+
+	// RequestType optionally defines the type of the request body, by example
+	// value. It is used to describe the endpoint in the OpenAPI document.
+	RequestType interface{} `json:"-"`
+
+	// ResponseType optionally defines the type of the response data, by
+	// example value. For streams, it is the type of the streamed values. It is
+	// used to describe the endpoint in the OpenAPI document.
+	ResponseType interface{} `json:"-"`
+
+	// ContentETag enables ETags for DataFunc and StructFunc responses, which
+	// are derived from a hash of the response data. Clients may then use
+	// If-None-Match to only receive changed data. RecordFunc responses always
+	// have an ETag derived from a hash of the response data and a
+	// Last-Modified derived from the record metadata.
+	// Write requests with an If-Match header are only executed if it matches
+	// the ETag of the response to a read request.
+	ContentETag bool `json:",omitempty"`
+
 	// BelongsTo defines which module this endpoint belongs to.
 	// The endpoint will not be accessible if the module is not online.
 	BelongsTo *modules.Module `json:"-"`
@@ -79,16 +118,36 @@ type Endpoint struct {
 	// and marshalled including metadata.
 	RecordFunc RecordFunc `json:"-"`
 
+	// StreamFunc is for streaming any number of values.
+	StreamFunc StreamFunc `json:"-"`
+
 	// HandlerFunc is the raw http handler.
 	HandlerFunc http.HandlerFunc `json:"-"`
 }
 
 // Parameter describes a parameterized variation of an endpoint.
+// If a Type is set, the parameter is parsed and validated before the endpoint
+// function is called and the parsed value is available in Request.Params.
+// Parameters without a Type are for documentation only.
 type Parameter struct {
 	Method      string
 	Field       string
 	Value       string
 	Description string
+
+	// Type defines the type of the parameter value.
+	Type ParameterType `json:",omitempty"`
+	// Required defines whether the parameter must be present.
+	Required bool `json:",omitempty"`
+	// Default defines the value that is used if the parameter is not present.
+	Default string `json:",omitempty"`
+	// Enum defines the allowed values for the enum type.
+	Enum []string `json:",omitempty"`
+	// Pattern defines a regular expression that values of the string type
+	// must match.
+	Pattern string `json:",omitempty"`
+
+	pattern *regexp.Regexp
 }
 
 // HTTPStatusProvider is an interface for errors to provide a custom HTTP
@@ -139,6 +198,25 @@ func ErrorWithStatus(err error, code int) error {
 	// RecordFunc is for returning a database record. It will be properly locked
 	// and marshalled including metadata.
 	RecordFunc func(ar *Request) (r record.Record, err error)
+
+	// StreamFunc is for streaming any number of values. Every value written to
+	// the stream writer is sent to the client right away, as NDJSON or in the
+	// requested DSD format. The stream function must stop when writing fails or
+	// the request context is canceled.
+	StreamFunc func(ar *Request, w *StreamWriter) error
+)
+
+// EndpointKind describes the kind of an endpoint function.
+type EndpointKind string
+
+// Endpoint Kinds.
+const (
+	EndpointKindAction  EndpointKind = "action"
+	EndpointKindData    EndpointKind = "data"
+	EndpointKindStruct  EndpointKind = "struct"
+	EndpointKindRecord  EndpointKind = "record"
+	EndpointKindStream  EndpointKind = "stream"
+	EndpointKindHandler EndpointKind = "handler"
 )
 
 // MIME Types.
@@ -147,10 +225,40 @@ func ErrorWithStatus(err error, code int) error {
 	MimeTypeText string = "text/plain"
 
 	apiV1Path = "/api/v1/"
+
+	// maxRequestBodySize is the maximum size of request bodies.
+	maxRequestBodySize = 20000000 // 20MB
 )
 
 func init() {
-	RegisterHandler(apiV1Path+"{endpointPath:.+}", &endpointHandler{})
+	RegisterHandler("/api/v{apiVersion:[0-9]+}/{endpointPath:.+}", &endpointHandler{})
+}
+
+// endpointKey returns the key of the endpoint with the given version and path.
+func endpointKey(version int, path string) string {
+	return fmt.Sprintf("v%d/%s", version, path)
+}
+
+// apiVersionPath returns the URL path prefix of the given API version.
+func apiVersionPath(version int) string {
+	return "/api/v" + strconv.Itoa(version) + "/"
+}
+
+// splitAPIVersion splits the API version from the given endpoint path, if it
+// starts with one, eg. "v2/ping". Other paths refer to version 1.
+func splitAPIVersion(p string) (version int, endpointPath string) {
+	if v, rest, ok := strings.Cut(p, "/"); ok && len(v) > 1 && v[0] == 'v' {
+		if version, err := strconv.ParseUint(v[1:], 10, 31); err == nil && version > 0 {
+			return int(version), rest
+		}
+	}
+	return 1, p
+}
+
+// URLPath returns the URL path the endpoint is served at.
+// Path variables are not replaced.
+func (e *Endpoint) URLPath() string {
+	return "/api/" + endpointKey(e.Version, e.Path)
 }
 
 var (
@@ -215,23 +323,30 @@ func RegisterEndpoint(e Endpoint) error {
 	endpointsLock.Lock()
 	defer endpointsLock.Unlock()
 
-	_, ok := endpoints[e.Path]
+	key := endpointKey(e.Version, e.Path)
+	_, ok := endpoints[key]
 	if ok {
 		return ErrAlreadyRegistered
 	}
 
-	endpoints[e.Path] = &e
-	endpointsMux.Handle(apiV1Path+e.Path, &e)
+	endpoints[key] = &e
+	endpointsMux.Handle(e.URLPath(), &e)
 	return nil
 }
 
-// GetEndpointByPath returns the endpoint registered with the given path.
+// GetEndpointByPath returns the endpoint registered with the given path in
+// version 1 of the API.
 func GetEndpointByPath(path string) (*Endpoint, error) {
+	return GetEndpoint(1, path)
+}
+
+// GetEndpoint returns the endpoint registered with the given version and path.
+func GetEndpoint(version int, path string) (*Endpoint, error) {
 	endpointsLock.Lock()
 	defer endpointsLock.Unlock()
-	endpoint, ok := endpoints[path]
+	endpoint, ok := endpoints[endpointKey(version, path)]
 	if !ok {
-		return nil, fmt.Errorf("no registered endpoint on path: %q", path)
+		return nil, fmt.Errorf("no registered endpoint on path: %q (v%d)", path, version)
 	}
 
 	return endpoint, nil
@@ -243,6 +358,20 @@ func (e *Endpoint) check() error {
 		return errors.New("path is missing")
 	}
 
+	// Check version.
+	switch {
+	case e.Version == 0:
+		e.Version = 1
+	case e.Version < 0:
+		return errors.New("invalid version")
+	}
+
+	// Check deprecation.
+	if e.Deprecation != nil && e.Deprecation.Replacement != "" &&
+		!strings.HasPrefix(e.Deprecation.Replacement, "/") {
+		return errors.New("deprecation replacement must be an absolute URL path")
+	}
+
 	// Check permissions.
 	if e.Read < Dynamic || e.Read > PermitSelf {
 		return errors.New("invalid read permission")
@@ -269,6 +398,7 @@ func (e *Endpoint) check() error {
 		switch e.WriteMethod {
 		case http.MethodPost,
 			http.MethodPut,
+			http.MethodPatch,
 			http.MethodDelete:
 			// All good.
 		case "":
@@ -281,27 +411,51 @@ func (e *Endpoint) check() error {
 		e.WriteMethod = ""
 	}
 
+	// Check rate limit.
+	if e.RateLimit != nil {
+		if err := e.RateLimit.check(); err != nil {
+			return err
+		}
+	}
+
+	// Check parameters.
+	for i := range e.Parameters {
+		if err := e.Parameters[i].check(); err != nil {
+			return err
+		}
+	}
+
 	// Check functions.
 	var defaultMimeType string
 	fnCnt := 0
 	if e.ActionFunc != nil {
 		fnCnt++
+		e.Kind = EndpointKindAction
 		defaultMimeType = MimeTypeText
 	}
 	if e.DataFunc != nil {
 		fnCnt++
+		e.Kind = EndpointKindData
 		defaultMimeType = MimeTypeText
 	}
 	if e.StructFunc != nil {
 		fnCnt++
+		e.Kind = EndpointKindStruct
 		defaultMimeType = MimeTypeJSON
 	}
 	if e.RecordFunc != nil {
 		fnCnt++
+		e.Kind = EndpointKindRecord
 		defaultMimeType = MimeTypeJSON
 	}
+	if e.StreamFunc != nil {
+		fnCnt++
+		e.Kind = EndpointKindStream
+		defaultMimeType = MimeTypeNDJSON
+	}
 	if e.HandlerFunc != nil {
 		fnCnt++
+		e.Kind = EndpointKindHandler
 		defaultMimeType = MimeTypeText
 	}
 	if fnCnt != 1 {
@@ -334,13 +488,22 @@ func ExportEndpoints() []*Endpoint {
 
 type sortByPath []*Endpoint
 
-func (eps sortByPath) Len() int           { return len(eps) }
-func (eps sortByPath) Less(i, j int) bool { return eps[i].Path < eps[j].Path }
-func (eps sortByPath) Swap(i, j int)      { eps[i], eps[j] = eps[j], eps[i] }
+func (eps sortByPath) Len() int { return len(eps) }
+func (eps sortByPath) Less(i, j int) bool {
+	if eps[i].Path == eps[j].Path {
+		return eps[i].Version < eps[j].Version
+	}
+	return eps[i].Path < eps[j].Path
+}
+func (eps sortByPath) Swap(i, j int) { eps[i], eps[j] = eps[j], eps[i] }
 
 type endpointHandler struct{}
 
-var _ AuthenticatedHandler = &endpointHandler{} // Compile time interface check.
+// Compile time interface checks.
+var (
+	_ AuthenticatedHandler = &endpointHandler{}
+	_ RateLimitedHandler   = &endpointHandler{}
+)
 
 // ReadPermission returns the read permission for the handler.
 func (eh *endpointHandler) ReadPermission(r *http.Request) Permission {
@@ -360,6 +523,15 @@ func (eh *endpointHandler) WritePermission(r *http.Request) Permission {
 	return NotFound
 }
 
+// RateLimit returns the rate limit for the handler.
+func (eh *endpointHandler) RateLimit(r *http.Request) *RateLimit {
+	apiEndpoint, _ := getAPIContext(r)
+	if apiEndpoint != nil {
+		return apiEndpoint.RateLimit
+	}
+	return nil
+}
+
 // ServeHTTP handles the http request.
 func (eh *endpointHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	apiEndpoint, apiRequest := getAPIContext(r)
@@ -379,6 +551,12 @@ func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Inform clients about the deprecation.
+	if e.Deprecation != nil {
+		e.Deprecation.writeHeaders(w)
+		logDeprecatedUsage(e, apiRequest)
+	}
+
 	// Wait for the owning module to be ready.
 	if !moduleIsReady(e.BelongsTo) {
 		http.Error(w, "The API endpoint is not ready yet or the its module is not enabled. Reload (F5) to try again.", http.StatusServiceUnavailable)
@@ -417,10 +595,18 @@ func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Parse and validate parameters.
+	params, err := e.parseParameters(apiRequest, eMethod)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	apiRequest.Params = params
+
 	switch eMethod {
 	case http.MethodGet, http.MethodDelete:
 		// Nothing to do for these.
-	case http.MethodPost, http.MethodPut:
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
 		// Read body data.
 		inputData, ok := readBody(w, r)
 		if !ok {
@@ -441,47 +627,39 @@ func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Execute action function and get response data
 	var responseData []byte
-	var err error
+	var etag string
+	var lastModified time.Time
 
 	switch {
-	case e.ActionFunc != nil:
-		var msg string
-		msg, err = e.ActionFunc(apiRequest)
-		if !strings.HasSuffix(msg, "\n") {
-			msg += "\n"
-		}
+	case e.StreamFunc != nil:
+		var sw *StreamWriter
+		sw, err = newStreamWriter(w, r)
 		if err == nil {
-			responseData = []byte(msg)
-		}
-
-	case e.DataFunc != nil:
-		responseData, err = e.DataFunc(apiRequest)
-
-	case e.StructFunc != nil:
-		var v interface{}
-		v, err = e.StructFunc(apiRequest)
-		if err == nil && v != nil {
-			var mimeType string
-			responseData, mimeType, _, err = dsd.MimeDump(v, r.Header.Get("Accept"))
-			if err == nil {
-				w.Header().Set("Content-Type", mimeType)
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNoContent)
+				return
 			}
-		}
-
-	case e.RecordFunc != nil:
-		var rec record.Record
-		rec, err = e.RecordFunc(apiRequest)
-		if err == nil && r != nil {
-			responseData, err = MarshalRecord(rec, false)
+			sw.finish(e.StreamFunc(apiRequest, sw))
+			return
 		}
 
 	case e.HandlerFunc != nil:
 		e.HandlerFunc(w, r)
 		return
 
+	case !readMethod && r.Header.Get("If-Match") != "":
+		// Only write if the client has the current version of the resource.
+		lock := conditionalWriteLock(r.URL.Path)
+		lock.Lock()
+		defer lock.Unlock()
+
+		err = e.checkIfMatch(apiRequest)
+		if err == nil {
+			responseData, etag, lastModified, err = e.execute(apiRequest)
+		}
+
 	default:
-		http.Error(w, "missing handler", http.StatusInternalServerError)
-		return
+		responseData, etag, lastModified, err = e.execute(apiRequest)
 	}
 
 	// Check for handler error.
@@ -495,6 +673,14 @@ func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Add validators and check if the client already has the response.
+	if (etag != "" || !lastModified.IsZero()) &&
+		writeValidators(w, r, etag, lastModified) &&
+		readMethod {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	// Return no content if there is none, or if request is HEAD.
 	if len(responseData) == 0 || r.Method == http.MethodHead {
 		w.WriteHeader(http.StatusNoContent)
@@ -515,9 +701,101 @@ func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// execute executes the action, data, struct or record function of the
+// endpoint and returns the response data and its validators.
+func (e *Endpoint) execute(ar *Request) (responseData []byte, etag string, lastModified time.Time, err error) {
+	switch {
+	case e.ActionFunc != nil:
+		var msg string
+		msg, err = e.ActionFunc(ar)
+		if !strings.HasSuffix(msg, "\n") {
+			msg += "\n"
+		}
+		if err == nil {
+			responseData = []byte(msg)
+		}
+
+	case e.DataFunc != nil:
+		responseData, err = e.DataFunc(ar)
+
+	case e.StructFunc != nil:
+		var v interface{}
+		v, err = e.StructFunc(ar)
+		if err == nil && v != nil {
+			var mimeType string
+			responseData, mimeType, _, err = dsd.MimeDump(v, ar.Header.Get("Accept"))
+			if err == nil {
+				ar.ResponseHeader.Set("Content-Type", mimeType)
+			}
+		}
+
+	case e.RecordFunc != nil:
+		var rec record.Record
+		rec, err = e.RecordFunc(ar)
+		if err == nil && rec != nil {
+			rec.Lock()
+			lastModified = recordLastModified(rec)
+			rec.Unlock()
+
+			responseData, err = MarshalRecord(rec, false)
+		}
+
+	default:
+		return nil, "", time.Time{}, errors.New("missing handler")
+	}
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	// Records always have an ETag, other responses only if enabled.
+	if (e.RecordFunc != nil || e.ContentETag) && len(responseData) > 0 {
+		etag = contentETag(responseData)
+	}
+	return responseData, etag, lastModified, nil
+}
+
+// checkIfMatch checks the If-Match header of the write request against the
+// ETag of the current version of the resource, which is the response to a
+// read request with the same URL. Endpoints that cannot be read by the
+// client, as well as action endpoints, never match.
+func (e *Endpoint) checkIfMatch(ar *Request) error {
+	var etag string
+	if e.ActionFunc == nil && e.Read != NotSupported &&
+		(e.Read <= PermitAnyone || (ar.AuthToken != nil && ar.AuthToken.Read >= e.Read)) {
+		// Copy the request as a read request.
+		readRequest := *ar
+		readRequest.Request = ar.Request.Clone(ar.Context())
+		readRequest.Method = e.ReadMethod
+		readRequest.Body = http.NoBody
+		readRequest.ContentLength = 0
+		readRequest.InputData = nil
+		readRequest.ResponseHeader = make(http.Header)
+
+		params, err := e.parseParameters(&readRequest, e.ReadMethod)
+		if err != nil {
+			return err
+		}
+		readRequest.Params = params
+
+		responseData, _, _, err := e.execute(&readRequest)
+		var statusProvider HTTPStatusProvider
+		switch {
+		case err == nil:
+			if len(responseData) > 0 {
+				etag = contentETag(responseData)
+			}
+		case errors.As(err, &statusProvider) && statusProvider.HTTPStatus() == http.StatusNotFound:
+			// The resource does not exist.
+		default:
+			return err
+		}
+	}
+	return ar.CheckIfMatch(etag)
+}
+
 func readBody(w http.ResponseWriter, r *http.Request) (inputData []byte, ok bool) {
 	// Check for too long content in order to prevent death.
-	if r.ContentLength > 20000000 { // 20MB
+	if r.ContentLength > maxRequestBodySize {
 		http.Error(w, "too much input data", http.StatusRequestEntityTooLarge)
 		return nil, false
 	}
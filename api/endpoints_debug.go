@@ -11,11 +11,15 @@
 	"strings"
 	"time"
 
+	"github.com/safing/portbase/database"
 	"github.com/safing/portbase/info"
 	"github.com/safing/portbase/modules"
 	"github.com/safing/portbase/utils/debug"
 )
 
+// profileRateLimit limits the expensive profiling endpoints.
+var profileRateLimit = &RateLimit{Requests: 6, Per: time.Minute}
+
 func registerDebugEndpoints() error {
 	if err := RegisterEndpoint(Endpoint{
 		Path:        "ping",
@@ -58,11 +62,12 @@ func registerDebugEndpoints() error {
 	}
 
 	if err := RegisterEndpoint(Endpoint{
-		Path:     "debug/cpu",
-		MimeType: "application/octet-stream",
-		Read:     PermitAnyone,
-		DataFunc: handleCPUProfile,
-		Name:     "Get CPU Profile",
+		Path:      "debug/cpu",
+		MimeType:  "application/octet-stream",
+		Read:      PermitAnyone,
+		RateLimit: profileRateLimit,
+		DataFunc:  handleCPUProfile,
+		Name:      "Get CPU Profile",
 		Description: strings.ReplaceAll(`Gather and return the CPU profile.
 This data needs to gathered over a period of time, which is specified using the duration parameter.
 
@@ -73,18 +78,41 @@ func registerDebugEndpoints() error {
 			Method:      http.MethodGet,
 			Field:       "duration",
 			Value:       "10s",
-			Description: "Specify the formatting style. The default is simple markdown formatting.",
+			Description: "Specify the duration of the CPU profile. The default is 10 seconds.",
+			Type:        ParamTypeDuration,
+			Default:     "10s",
 		}},
 	}); err != nil {
 		return err
 	}
 
 	if err := RegisterEndpoint(Endpoint{
-		Path:     "debug/heap",
-		MimeType: "application/octet-stream",
-		Read:     PermitAnyone,
-		DataFunc: handleHeapProfile,
-		Name:     "Get Heap Profile",
+		Path:        "debug/database/caches",
+		Read:        PermitAnyone,
+		StructFunc:  databaseCacheStats,
+		Name:        "Get Database Cache Statistics",
+		Description: "Returns statistics about the caches of all named database interfaces.",
+	}); err != nil {
+		return err
+	}
+
+	if err := RegisterEndpoint(Endpoint{
+		Path:        "debug/security-policy",
+		Read:        PermitUser,
+		StructFunc:  getEffectiveSecurityPolicy,
+		Name:        "Get Security Policy",
+		Description: "Returns the security policy of the API in use, including the allowed origins, the Content-Security-Policy and the security headers. The Content-Security-Policy is not sent in development mode.",
+	}); err != nil {
+		return err
+	}
+
+	if err := RegisterEndpoint(Endpoint{
+		Path:      "debug/heap",
+		MimeType:  "application/octet-stream",
+		Read:      PermitAnyone,
+		RateLimit: profileRateLimit,
+		DataFunc:  handleHeapProfile,
+		Name:      "Get Heap Profile",
 		Description: strings.ReplaceAll(`Gather and return the heap memory profile.
 		
 		You can easily view this data in your browser with this command (with Go installed):
@@ -95,11 +123,12 @@ func registerDebugEndpoints() error {
 	}
 
 	if err := RegisterEndpoint(Endpoint{
-		Path:     "debug/allocs",
-		MimeType: "application/octet-stream",
-		Read:     PermitAnyone,
-		DataFunc: handleAllocsProfile,
-		Name:     "Get Allocs Profile",
+		Path:      "debug/allocs",
+		MimeType:  "application/octet-stream",
+		Read:      PermitAnyone,
+		RateLimit: profileRateLimit,
+		DataFunc:  handleAllocsProfile,
+		Name:      "Get Allocs Profile",
 		Description: strings.ReplaceAll(`Gather and return the memory allocation profile.
 		
 		You can easily view this data in your browser with this command (with Go installed):
@@ -128,6 +157,19 @@ func registerDebugEndpoints() error {
 	return nil
 }
 
+// databaseCacheStats returns the cache statistics of all named database
+// interfaces.
+func databaseCacheStats(_ *Request) (i interface{}, err error) {
+	ifaces := database.GetCachedInterfaces()
+	stats := make([]*database.CacheStats, 0, len(ifaces))
+	for _, iface := range ifaces {
+		if s := iface.CacheStats(); s != nil {
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
 // ping responds with pong.
 func ping(ar *Request) (msg string, err error) {
 	// TODO: Remove upgrade to "ready" when all UI components have transitioned.
@@ -173,15 +215,7 @@ func printStack(_ *Request) (msg string, err error) {
 
 // handleCPUProfile returns the CPU profile.
 func handleCPUProfile(ar *Request) (data []byte, err error) {
-	// Parse duration.
-	duration := 10 * time.Second
-	if durationOption := ar.Request.URL.Query().Get("duration"); durationOption != "" {
-		parsedDuration, err := time.ParseDuration(durationOption)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse duration: %w", err)
-		}
-		duration = parsedDuration
-	}
+	duration := ar.Params.Duration("duration")
 
 	// Indicate download and filename.
 	ar.ResponseHeader.Set(
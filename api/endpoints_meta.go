@@ -18,6 +18,50 @@ func registerMetaEndpoints() error {
 		return err
 	}
 
+	if err := RegisterEndpoint(Endpoint{
+		Path:        "openapi",
+		Read:        PermitAnyone,
+		StructFunc:  openAPI,
+		Name:        "Export OpenAPI Document",
+		Description: "Returns an OpenAPI 3 document describing all registered endpoints.",
+	}); err != nil {
+		return err
+	}
+
+	if err := RegisterEndpoint(Endpoint{
+		Path: "audit",
+		Read: PermitAdmin,
+		Parameters: []Parameter{{
+			Method:      http.MethodGet,
+			Field:       "since",
+			Type:        ParamTypeDuration,
+			Default:     "24h",
+			Description: "Only return entries newer than the given duration.",
+		}, {
+			Method:      http.MethodGet,
+			Field:       "identity",
+			Type:        ParamTypeString,
+			Description: "Only return entries of the given API key or session identity.",
+		}, {
+			Method:      http.MethodGet,
+			Field:       "path",
+			Type:        ParamTypeString,
+			Description: "Only return entries with a request path or database key starting with the given prefix.",
+		}, {
+			Method:      http.MethodGet,
+			Field:       "limit",
+			Type:        ParamTypeInt,
+			Default:     "100",
+			Description: "Return at most the given amount of entries, up to 1000.",
+		}},
+		StructFunc:   queryAuditLog,
+		ResponseType: []*AuditEntry{},
+		Name:         "Query Audit Log",
+		Description:  "Returns the newest entries of the audit log of privileged API actions.",
+	}); err != nil {
+		return err
+	}
+
 	if err := RegisterEndpoint(Endpoint{
 		Path:        "auth/permissions",
 		Read:        Dynamic,
@@ -58,7 +102,7 @@ func registerMetaEndpoints() error {
 		return err
 	}
 
-	return nil
+	return registerBatchEndpoint()
 }
 
 func listEndpoints(ar *Request) (data []byte, err error) {
@@ -14,6 +14,8 @@ type LoggingResponseWriter struct {
 	ResponseWriter http.ResponseWriter
 	Request        *http.Request
 	Status         int
+
+	hijacked bool
 }
 
 // NewLoggingResponseWriter wraps a http.ResponseWriter.
@@ -31,6 +33,10 @@ func (lrw *LoggingResponseWriter) Header() http.Header {
 
 // Write wraps the original Write method.
 func (lrw *LoggingResponseWriter) Write(b []byte) (int, error) {
+	// Writing without a header implies a status of OK.
+	if lrw.Status == 0 {
+		lrw.Status = http.StatusOK
+	}
 	return lrw.ResponseWriter.Write(b)
 }
 
@@ -48,12 +54,20 @@ func (lrw *LoggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error)
 		if err != nil {
 			return nil, nil, err
 		}
+		lrw.hijacked = true
 		log.Tracer(lrw.Request.Context()).Infof("api request: %s HIJ %s", lrw.Request.RemoteAddr, lrw.Request.RequestURI)
 		return c, b, nil
 	}
 	return nil, nil, errors.New("response does not implement http.Hijacker")
 }
 
+// Flush wraps the original Flush method, if available.
+func (lrw *LoggingResponseWriter) Flush() {
+	if flusher, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // RequestLogger is a logging middleware.
 func RequestLogger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
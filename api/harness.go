@@ -0,0 +1,325 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// testHarnessTokenKey is the context key of the auth token that is used for
+// requests served by a test harness.
+type testHarnessTokenKey struct{}
+
+// TestHarness serves endpoints in-process, without starting the api module or
+// listening on the network. It is meant for testing endpoints: Requests are
+// served with a given auth token, but otherwise pass through the same request
+// handling as requests to the API server.
+type TestHarness struct {
+	handler *mainHandler
+
+	endpoints map[string]struct{}
+	lock      sync.Mutex
+
+	server   *http.Server
+	listener *pipeListener
+}
+
+// NewTestHarness returns a new test harness. It serves the database websocket
+// API and the endpoints that are added to it.
+func NewTestHarness() *TestHarness {
+	h := &TestHarness{
+		handler: &mainHandler{
+			mux:       mux.NewRouter(),
+			checkAuth: checkTestHarnessAuth,
+		},
+		endpoints: make(map[string]struct{}),
+	}
+	h.handler.mux.Handle("/api/database/v1", WrapInAuthHandler(
+		startDatabaseWebsocketAPI,
+		PermitUser,
+		PermitUser,
+	))
+	return h
+}
+
+// checkTestHarnessAuth authenticates requests with the auth token that the
+// test harness added to the request context.
+func checkTestHarnessAuth(_ http.ResponseWriter, r *http.Request, _ bool) (token *AuthToken, handled bool) {
+	token, _ = r.Context().Value(testHarnessTokenKey{}).(*AuthToken)
+	return token, false
+}
+
+// AddEndpoint adds the registered endpoint with the given path in version 1
+// of the API to the harness.
+func (h *TestHarness) AddEndpoint(path string) error {
+	return h.AddEndpointVersion(1, path)
+}
+
+// AddEndpointVersion adds the registered endpoint with the given version and
+// path to the harness.
+func (h *TestHarness) AddEndpointVersion(version int, path string) error {
+	e, err := GetEndpoint(version, path)
+	if err != nil {
+		return err
+	}
+	return h.serve(e)
+}
+
+// Register adds the given endpoint to the harness only. It is not registered
+// with the API.
+func (h *TestHarness) Register(e Endpoint) error {
+	if err := e.check(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidEndpoint, err)
+	}
+	return h.serve(&e)
+}
+
+func (h *TestHarness) serve(e *Endpoint) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	key := endpointKey(e.Version, e.Path)
+	if _, ok := h.endpoints[key]; ok {
+		return ErrAlreadyRegistered
+	}
+	h.endpoints[key] = struct{}{}
+	h.handler.mux.Handle(e.URLPath(), e)
+	return nil
+}
+
+// Do serves the request with the given auth token and returns the recorded
+// response. A nil token serves the request without any permissions.
+func (h *TestHarness) Do(r *http.Request, token *AuthToken) *httptest.ResponseRecorder {
+	r = r.WithContext(context.WithValue(r.Context(), testHarnessTokenKey{}, token))
+	rec := httptest.NewRecorder()
+	h.handler.ServeHTTP(rec, r)
+	return rec
+}
+
+// Request serves a request to the given URL path, eg. "/api/v1/ping", with
+// the given permission for reading and writing and returns the recorded
+// response. The body is sent as JSON, if not nil.
+func (h *TestHarness) Request(method, path string, body []byte, permission Permission) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if body != nil {
+		r.Header.Set("Content-Type", MimeTypeJSON)
+	}
+	return h.Do(r, &AuthToken{
+		Read:  permission,
+		Write: permission,
+	})
+}
+
+// DatabaseLoopback connects to the database websocket API of the harness with
+// the given auth token. The connection is in-process and uses the legacy
+// protocol, where every message has its own frame.
+func (h *TestHarness) DatabaseLoopback(token *AuthToken) (*DatabaseLoopback, error) {
+	h.lock.Lock()
+	if h.server == nil {
+		h.listener = newPipeListener()
+		h.server = &http.Server{
+			Handler:           h.handler,
+			ReadHeaderTimeout: 10 * time.Second,
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				if tc, ok := c.(*tokenConn); ok {
+					return context.WithValue(ctx, testHarnessTokenKey{}, tc.token)
+				}
+				return ctx
+			},
+		}
+		go func(server *http.Server, listener net.Listener) {
+			_ = server.Serve(listener)
+		}(h.server, h.listener)
+	}
+	listener := h.listener
+	h.lock.Unlock()
+
+	dialer := &websocket.Dialer{
+		NetDialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+			return listener.dial(token)
+		},
+		HandshakeTimeout: 10 * time.Second,
+	}
+	conn, resp, err := dialer.Dial("ws://harness/api/database/v1", nil)
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to connect: %w (%s)", err, resp.Status)
+		}
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	return &DatabaseLoopback{
+		conn:    conn,
+		Timeout: 5 * time.Second,
+	}, nil
+}
+
+// Close stops serving database loopbacks. Connected loopbacks must be closed
+// separately.
+func (h *TestHarness) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.server == nil {
+		return nil
+	}
+	return h.server.Close()
+}
+
+// DatabaseLoopback is a connection to the database websocket API of a test
+// harness.
+type DatabaseLoopback struct {
+	conn *websocket.Conn
+
+	// Timeout is the time to wait for a message to be received.
+	// Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// DatabaseMessage is a message received from the database websocket API.
+type DatabaseMessage struct {
+	OpID string
+	Type string
+	Key  string
+	Data []byte
+}
+
+// String returns the operation ID, type and key of the message.
+func (m *DatabaseMessage) String() string {
+	if m.Key == "" {
+		return m.OpID + dbAPISeperator + m.Type
+	}
+	return m.OpID + dbAPISeperator + m.Type + dbAPISeperator + m.Key
+}
+
+// Send sends a message with the given operation ID, type and arguments, eg.
+// Send("1", "qsub", "query config:").
+func (l *DatabaseLoopback) Send(opID, msgType string, args ...string) error {
+	msg := strings.Join(append([]string{opID, msgType}, args...), dbAPISeperator)
+	return l.conn.WriteMessage(websocket.BinaryMessage, []byte(msg))
+}
+
+// Receive returns the next message.
+func (l *DatabaseLoopback) Receive() (*DatabaseMessage, error) {
+	if err := l.conn.SetReadDeadline(time.Now().Add(l.Timeout)); err != nil {
+		return nil, err
+	}
+	_, data, err := l.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	parts := bytes.SplitN(data, dbAPISeperatorBytes, 4)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("malformed message: %q", data)
+	}
+	m := &DatabaseMessage{
+		OpID: string(parts[0]),
+		Type: string(parts[1]),
+	}
+	if len(parts) > 2 {
+		m.Key = string(parts[2])
+	}
+	if len(parts) > 3 {
+		m.Data = parts[3]
+	}
+	return m, nil
+}
+
+// ReceiveUntil returns the messages of the given operation until, and
+// including, the first message of the given type. Messages of other
+// operations are discarded.
+func (l *DatabaseLoopback) ReceiveUntil(opID, msgType string) ([]*DatabaseMessage, error) {
+	var msgs []*DatabaseMessage
+	for {
+		m, err := l.Receive()
+		if err != nil {
+			return msgs, err
+		}
+		if m.OpID != opID {
+			continue
+		}
+		msgs = append(msgs, m)
+		if m.Type == msgType {
+			return msgs, nil
+		}
+	}
+}
+
+// Close closes the connection.
+func (l *DatabaseLoopback) Close() error {
+	return l.conn.Close()
+}
+
+// pipeListener is a listener for in-process connections.
+type pipeListener struct {
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// tokenConn is a connection that is served with an auth token.
+type tokenConn struct {
+	net.Conn
+
+	token *AuthToken
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// dial returns a new connection to the listener, which is served with the
+// given auth token.
+func (l *pipeListener) dial(token *AuthToken) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- &tokenConn{Conn: server, token: token}:
+		return client, nil
+	case <-l.done:
+		_ = client.Close()
+		_ = server.Close()
+		return nil, errors.New("test harness is closed")
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
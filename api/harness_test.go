@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/database"
+)
+
+func TestHarnessEndpoints(t *testing.T) {
+	t.Parallel()
+
+	h := NewTestHarness()
+	defer func() {
+		_ = h.Close()
+	}()
+
+	// Registered endpoints are served with the given permissions.
+	require.NoError(t, h.AddEndpoint("auth/permissions"))
+	assert.ErrorIs(t, h.AddEndpoint("auth/permissions"), ErrAlreadyRegistered)
+	assert.Error(t, h.AddEndpoint("test/harness/missing"))
+	rec := h.Request(http.MethodGet, "/api/v1/auth/permissions", nil, PermitUser)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"Read":2`)
+
+	// Endpoints that are only registered with the harness are not served by the API.
+	require.NoError(t, h.Register(Endpoint{
+		Path: "test/harness/{id:[0-9]+}",
+		Read: PermitUser,
+		Parameters: []Parameter{{
+			Method: http.MethodGet,
+			Field:  "id",
+			Type:   ParamTypeInt,
+		}},
+		ActionFunc: func(ar *Request) (msg string, err error) {
+			if ar.Params.Int("id") == 0 {
+				return "", errors.New(failedMsg)
+			}
+			return successMsg, nil
+		},
+	}))
+	_, err := GetEndpointByPath("test/harness/{id:[0-9]+}")
+	assert.Error(t, err)
+
+	rec = h.Request(http.MethodGet, "/api/v1/test/harness/1", nil, PermitUser)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, successMsg, strings.TrimSpace(rec.Body.String()))
+	rec = h.Request(http.MethodGet, "/api/v1/test/harness/0", nil, PermitUser)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), failedMsg)
+	rec = h.Request(http.MethodGet, "/api/v1/test/harness/x", nil, PermitUser)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Permissions and scopes are enforced.
+	rec = h.Request(http.MethodGet, "/api/v1/test/harness/1", nil, PermitAnyone)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	r, err := http.NewRequest(http.MethodGet, "/api/v1/test/harness/1", nil)
+	require.NoError(t, err)
+	rec = h.Do(r, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = h.Do(r, &AuthToken{Read: PermitAdmin, Write: PermitAdmin, Scopes: []string{"auth/"}})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestHarnessDatabaseLoopback(t *testing.T) {
+	t.Parallel()
+
+	_, err := database.Register(&database.Database{
+		Name:        "testing-harness",
+		Description: "Unit Test Database for the Test Harness",
+		StorageType: "hashmap",
+	})
+	require.NoError(t, err)
+	RequireDatabasePermissions("testing-harness:", PermitUser, PermitUser)
+	db := database.NewInterface(nil)
+	records := make(map[string]*actionTestRecord)
+	put := func(key, msg string) {
+		r, ok := records[key]
+		if !ok {
+			r = &actionTestRecord{}
+			r.SetKey("testing-harness:" + key)
+			records[key] = r
+		}
+		r.Msg = msg
+		require.NoError(t, db.Put(r))
+	}
+	put("a", "a")
+	put("b", "b")
+
+	h := NewTestHarness()
+	defer func() {
+		_ = h.Close()
+	}()
+
+	// Anonymous clients may not connect.
+	_, err = h.DatabaseLoopback(nil)
+	require.Error(t, err)
+
+	l, err := h.DatabaseLoopback(&AuthToken{Read: PermitUser, Write: PermitUser})
+	require.NoError(t, err)
+	defer func() {
+		_ = l.Close()
+	}()
+
+	// Subscriptions send the existing records first.
+	require.NoError(t, l.Send("1", "qsub", "query testing-harness:"))
+	msgs, err := l.ReceiveUntil("1", "done")
+	require.NoError(t, err)
+	var seq []string
+	for _, m := range msgs {
+		seq = append(seq, m.String())
+	}
+	assert.ElementsMatch(t, []string{
+		"1|ok|testing-harness:a",
+		"1|ok|testing-harness:b",
+		"1|done",
+	}, seq)
+	assert.Equal(t, "1|done", seq[len(seq)-1])
+
+	// Changes follow in order.
+	// Records are only reported as updated if they were modified after the
+	// second they were created in.
+	records["a"].Meta().Created--
+	put("a", "a2")
+	put("c", "c")
+	require.NoError(t, db.Delete("testing-harness:b"))
+	for _, expected := range []string{
+		"1|upd|testing-harness:a",
+		"1|new|testing-harness:c",
+		"1|del|testing-harness:b",
+	} {
+		m, err := l.Receive()
+		require.NoError(t, err)
+		assert.Equal(t, expected, m.String())
+	}
+
+	// Records outside of the permitted scope are denied.
+	require.NoError(t, l.Send("2", "get", "core:config/x"))
+	msgs, err = l.ReceiveUntil("2", "error")
+	require.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/api/client"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/formats/dsd"
+)
+
+type httpClientTestStruct struct {
+	ID  int64
+	Msg string
+}
+
+func TestHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv := httptest.NewServer(&mainHandler{mux: mainMux})
+	defer srv.Close()
+
+	// Register test endpoints.
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path:  "test/client/action",
+		Read:  PermitAnyone,
+		Write: PermitAnyone,
+		ActionFunc: func(ar *Request) (msg string, err error) {
+			if len(ar.InputData) > 0 {
+				return string(ar.InputData), nil
+			}
+			return successMsg, nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/client/struct/{id:[0-9]+}",
+		Read: PermitAnyone,
+		Parameters: []Parameter{{
+			Method: http.MethodGet,
+			Field:  "id",
+			Type:   ParamTypeInt,
+		}},
+		StructFunc: func(ar *Request) (i interface{}, err error) {
+			id := ar.Params.Int("id")
+			if id == 0 {
+				return nil, ErrorWithStatus(errors.New("no such struct"), http.StatusNotFound)
+			}
+			return &httpClientTestStruct{ID: id, Msg: successMsg}, nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/client/record",
+		Read: PermitAnyone,
+		RecordFunc: func(ar *Request) (r record.Record, err error) {
+			r = &actionTestRecord{
+				Msg: successMsg,
+			}
+			r.SetKey("test:client/record")
+			r.UpdateMeta()
+			return r, nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/client/admin",
+		Read: PermitAdmin,
+		StructFunc: func(ar *Request) (i interface{}, err error) {
+			return &httpClientTestStruct{Msg: successMsg}, nil
+		},
+	}))
+
+	c := client.NewHTTPClient(srv.URL, nil)
+
+	// Endpoints are discovered with their kind.
+	ep, err := c.Endpoint(ctx, "test/client/struct/1?x=y")
+	require.NoError(t, err)
+	assert.Equal(t, "test/client/struct/{id:[0-9]+}", ep.Path)
+	assert.Equal(t, client.EndpointKindStruct, ep.Kind)
+	_, err = c.Endpoint(ctx, "test/client/missing")
+	assert.ErrorIs(t, err, client.ErrEndpointNotFound)
+
+	// Actions are called with the read method, or the write method when sending data.
+	msg, err := c.Action(ctx, "test/client/action", nil)
+	require.NoError(t, err)
+	assert.Equal(t, successMsg, msg)
+	msg, err = c.Action(ctx, "test/client/action", "sent")
+	require.NoError(t, err)
+	assert.Equal(t, `"sent"`, msg)
+
+	// Structs are loaded.
+	s := &httpClientTestStruct{}
+	require.NoError(t, c.Struct(ctx, "test/client/struct/1", nil, s))
+	assert.Equal(t, httpClientTestStruct{ID: 1, Msg: successMsg}, *s)
+	_, err = c.Action(ctx, "test/client/struct/1", nil)
+	assert.ErrorIs(t, err, client.ErrEndpointKindMismatch)
+	_, _, err = c.Data(ctx, "test/client/struct/1", nil)
+	assert.ErrorIs(t, err, client.ErrEndpointKindMismatch)
+
+	// Errors keep their status.
+	err = c.Struct(ctx, "test/client/struct/0", nil, s)
+	var statusErr *client.HTTPStatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusNotFound, statusErr.HTTPStatus())
+	assert.Equal(t, "no such struct", statusErr.Message)
+
+	// Records are returned with their metadata.
+	r, err := c.Record(ctx, "test/client/record", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "test:client/record", r.Key())
+	assert.NotZero(t, r.Meta().Created)
+	rec := &actionTestRecord{}
+	require.NoError(t, record.Unwrap(r, rec))
+	assert.Equal(t, successMsg, rec.Msg)
+
+	// Other formats are negotiated.
+	msgpackClient := client.NewHTTPClient(srv.URL, &client.HTTPOptions{
+		Format: dsd.MsgPack,
+	})
+	s = &httpClientTestStruct{}
+	require.NoError(t, msgpackClient.Struct(ctx, "test/client/struct/2", nil, s))
+	assert.Equal(t, int64(2), s.ID)
+
+	// API keys are sent as bearer tokens.
+	i, err := createAPIKey(&Request{
+		InputData: []byte(`{"Label":"client test","Read":"user"}`),
+	})
+	require.NoError(t, err)
+	created, ok := i.(*APIKeyResponse)
+	require.True(t, ok)
+	defer func() {
+		_, _ = revokeAPIKey(&Request{Params: ParameterValues{"id": created.ID}})
+	}()
+	userClient := client.NewHTTPClient(srv.URL, &client.HTTPOptions{
+		APIKey: created.Key,
+	})
+	require.NoError(t, userClient.Authenticate(ctx))
+	err = userClient.Struct(ctx, "test/client/admin", nil, s)
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusForbidden, statusErr.Status)
+}
@@ -53,11 +53,33 @@ func prep() error {
 		return err
 	}
 
+	if err := registerAPIKeyEndpoints(); err != nil {
+		return err
+	}
+
+	if err := registerSessionEndpoints(); err != nil {
+		return err
+	}
+
+	if err := registerDatabaseEndpoints(); err != nil {
+		return err
+	}
+
 	return registerMetaEndpoints()
 }
 
 func start() error {
-	startServer()
+	if err := registerAPIKeysDB(); err != nil {
+		return err
+	}
+
+	if err := loadSessions(); err != nil {
+		return err
+	}
+
+	if err := startServer(); err != nil {
+		return err
+	}
 
 	_ = updateAPIKeys(module.Ctx, nil)
 	err := module.RegisterEventHook("config", "config change", "update API keys", updateAPIKeys)
@@ -70,6 +92,10 @@ func start() error {
 		module.NewTask("clean api sessions", cleanSessions).Repeat(5 * time.Minute)
 	}
 
+	if err := registerAuditDB(); err != nil {
+		return err
+	}
+
 	return registerEndpointBridgeDB()
 }
 
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	requestObservers           []func(route string, status int, duration time.Duration)
+	databaseOperationObservers []func(command string)
+	observersLock              sync.RWMutex
+)
+
+// ObserveRequests registers a function that is called after every handled
+// request with the path template of the matched route, the response status
+// code and the duration of the request. The route is empty if the request did
+// not match any route. Hijacked requests, such as websockets, are not
+// observed.
+func ObserveRequests(fn func(route string, status int, duration time.Duration)) {
+	observersLock.Lock()
+	defer observersLock.Unlock()
+
+	requestObservers = append(requestObservers, fn)
+}
+
+// ObserveDatabaseOperations registers a function that is called with the
+// command of every operation of the database API, eg. "get" or "qsub".
+func ObserveDatabaseOperations(fn func(command string)) {
+	observersLock.Lock()
+	defer observersLock.Unlock()
+
+	databaseOperationObservers = append(databaseOperationObservers, fn)
+}
+
+// observeRequest notifies the request observers about the given request.
+func observeRequest(ar *Request, status int, started time.Time) {
+	// Ignore hijacked requests.
+	if status == 0 {
+		return
+	}
+
+	observersLock.RLock()
+	defer observersLock.RUnlock()
+
+	if len(requestObservers) == 0 {
+		return
+	}
+
+	var route string
+	if ar.Route != nil {
+		route, _ = ar.Route.GetPathTemplate()
+	}
+	duration := time.Since(started)
+	for _, fn := range requestObservers {
+		fn(route, status, duration)
+	}
+}
+
+// observeDatabaseOperation notifies the database operation observers about
+// the given command.
+func observeDatabaseOperation(command string) {
+	observersLock.RLock()
+	defer observersLock.RUnlock()
+
+	for _, fn := range databaseOperationObservers {
+		fn(command)
+	}
+}
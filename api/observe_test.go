@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObservers(t *testing.T) {
+	t.Parallel()
+
+	var (
+		observedStatus   int
+		observedCommands []string
+		lock             sync.Mutex
+	)
+	ObserveRequests(func(route string, status int, duration time.Duration) {
+		if route != apiV1Path+"test/observe/{id:[0-9]+}" {
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		observedStatus = status
+	})
+	ObserveDatabaseOperations(func(command string) {
+		lock.Lock()
+		defer lock.Unlock()
+		observedCommands = append(observedCommands, command)
+	})
+
+	// Requests are observed by their route template.
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/observe/{id:[0-9]+}",
+		Read: PermitAnyone,
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return successMsg, nil
+		},
+	}))
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+	assert.HTTPStatusCode(t, testHandler.ServeHTTP, http.MethodGet, apiV1Path+"test/observe/1", nil, http.StatusOK)
+	lock.Lock()
+	assert.Equal(t, http.StatusOK, observedStatus)
+	lock.Unlock()
+
+	// Database operations are observed by their command.
+	dbAPI := CreateDatabaseAPI(func(data []byte) {})
+	dbAPI.Handle([]byte("1|get|testing:observe"))
+	dbAPI.Handle([]byte("1|cancel"))
+	dbAPI.Handle([]byte("1|foo|bar"))
+	lock.Lock()
+	assert.Subset(t, observedCommands, []string{"get", "cancel", "unknown"})
+	lock.Unlock()
+}
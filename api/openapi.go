@@ -0,0 +1,481 @@
+package api
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/info"
+)
+
+const openAPIVersion = "3.0.3"
+
+// Security scheme names used in the OpenAPI document.
+var openAPISecurity = []map[string][]string{
+	{"bearerAuth": {}},
+	{"basicAuth": {}},
+	{"sessionCookie": {}},
+}
+
+// openAPIDocument is an OpenAPI 3 document. Only the parts needed to describe
+// the registered endpoints are defined.
+type openAPIDocument struct {
+	OpenAPI    string                     `json:"openapi"`
+	Info       openAPIInfo                `json:"info"`
+	Servers    []openAPIServer            `json:"servers"`
+	Tags       []openAPITag               `json:"tags,omitempty"`
+	Paths      map[string]openAPIPathItem `json:"paths"`
+	Components openAPIComponents          `json:"components"`
+
+	// schemaNames holds the component schema names of Go types.
+	schemaNames map[reflect.Type]string
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPITag struct {
+	Name string `json:"name"`
+}
+
+// openAPIPathItem maps lower case HTTP methods to operations.
+type openAPIPathItem map[string]*openAPIOperation
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []*openAPIParameter        `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses"`
+	Security    []map[string][]string      `json:"security,omitempty"`
+	Deprecated  bool                       `json:"deprecated,omitempty"`
+
+	// Permission is the required permission as an extension field.
+	Permission string `json:"x-permission"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+	Example     string         `json:"example,omitempty"`
+}
+
+type openAPIRequestBody struct {
+	Content map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Pattern              string                    `json:"pattern,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Default              string                    `json:"default,omitempty"`
+}
+
+func openAPI(_ *Request) (i interface{}, err error) {
+	return exportOpenAPI(), nil
+}
+
+// exportOpenAPI generates an OpenAPI document from the registered endpoints.
+func exportOpenAPI() *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:   "Portmaster API",
+			Version: info.Version(),
+		},
+		Servers: []openAPIServer{{
+			URL: "/api",
+		}},
+		Paths: make(map[string]openAPIPathItem),
+		Components: openAPIComponents{
+			Schemas: make(map[string]*openAPISchema),
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"bearerAuth": {
+					Type:   "http",
+					Scheme: "bearer",
+				},
+				"basicAuth": {
+					Type:   "http",
+					Scheme: "basic",
+				},
+				"sessionCookie": {
+					Type: "apiKey",
+					In:   "cookie",
+					Name: sessionCookieName,
+				},
+			},
+		},
+		schemaNames: make(map[reflect.Type]string),
+	}
+
+	tags := make(map[string]struct{})
+	for _, e := range ExportEndpoints() {
+		path, pathParams := openAPIPath(e.Path)
+		path = fmt.Sprintf("/v%d%s", e.Version, path)
+		item := openAPIPathItem{}
+
+		if e.Read != NotSupported {
+			item[strings.ToLower(e.ReadMethod)] = doc.operation(e, e.ReadMethod, e.Read, pathParams)
+		}
+		if e.Write != NotSupported {
+			item[strings.ToLower(e.WriteMethod)] = doc.operation(e, e.WriteMethod, e.Write, pathParams)
+		}
+		if len(item) == 0 {
+			continue
+		}
+		doc.Paths[path] = item
+
+		tag := openAPITagName(e.Path)
+		if _, ok := tags[tag]; !ok {
+			tags[tag] = struct{}{}
+			doc.Tags = append(doc.Tags, openAPITag{Name: tag})
+		}
+	}
+
+	return doc
+}
+
+// operation creates the OpenAPI operation of an endpoint for the given method.
+func (doc *openAPIDocument) operation(e *Endpoint, method string, permission Permission, pathParams []*openAPIParameter) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: strings.ToLower(method) + "-" + strings.NewReplacer("/", "-", "{", "", "}", "").Replace(e.Path),
+		Deprecated:  e.Deprecation != nil,
+		Summary:     e.Name,
+		Description: e.Description,
+		Tags:        []string{openAPITagName(e.Path)},
+		Parameters:  append([]*openAPIParameter{}, pathParams...),
+		Responses: map[string]openAPIResponse{
+			"default": {
+				Description: "Error",
+				Content: map[string]openAPIMediaType{
+					MimeTypeText: {Schema: &openAPISchema{Type: "string"}},
+				},
+			},
+		},
+	}
+
+	// Keep operation IDs unique across versions.
+	if e.Version > 1 {
+		op.OperationID += fmt.Sprintf("-v%d", e.Version)
+	}
+
+	// Add permission and security.
+	switch permission {
+	case Dynamic:
+		op.Permission = "Dynamic"
+		op.Security = openAPISecurity
+	case PermitAnyone:
+		op.Permission = permission.Role()
+	default:
+		op.Permission = permission.Role()
+		op.Security = openAPISecurity
+	}
+
+	// Add query parameters and complete path parameters.
+paramLoop:
+	for _, param := range e.Parameters {
+		if param.Method != "" && param.Method != method {
+			continue
+		}
+
+		for i, pathParam := range op.Parameters {
+			if pathParam.In == "path" && pathParam.Name == param.Field {
+				completed := *pathParam
+				completed.Description = param.Description
+				completed.Example = param.Value
+				completed.Schema = openAPIParameterSchema(param, pathParam.Schema.Pattern)
+				op.Parameters[i] = &completed
+				continue paramLoop
+			}
+		}
+
+		op.Parameters = append(op.Parameters, &openAPIParameter{
+			Name:        param.Field,
+			In:          "query",
+			Description: param.Description,
+			Required:    param.Required,
+			Schema:      openAPIParameterSchema(param, ""),
+			Example:     param.Value,
+		})
+	}
+
+	// Add request body.
+	if method == http.MethodPost || method == http.MethodPut || method == http.MethodPatch {
+		if e.RequestType != nil {
+			op.RequestBody = &openAPIRequestBody{
+				Content: map[string]openAPIMediaType{
+					MimeTypeJSON: {Schema: doc.schema(reflect.TypeOf(e.RequestType))},
+				},
+			}
+		}
+	}
+
+	// Add success response.
+	var responseSchema *openAPISchema
+	switch {
+	case e.ResponseType != nil:
+		responseSchema = doc.schema(reflect.TypeOf(e.ResponseType))
+	case e.MimeType == MimeTypeJSON:
+		responseSchema = &openAPISchema{}
+	case strings.HasPrefix(e.MimeType, "text/"):
+		responseSchema = &openAPISchema{Type: "string"}
+	default:
+		responseSchema = &openAPISchema{Type: "string", Format: "binary"}
+	}
+	op.Responses["200"] = openAPIResponse{
+		Description: "OK",
+		Content: map[string]openAPIMediaType{
+			e.MimeType: {Schema: responseSchema},
+		},
+	}
+
+	return op
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schema returns the schema of the given Go type. Named structs are added to
+// the components and referenced.
+func (doc *openAPIDocument) schema(t reflect.Type) *openAPISchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Check special types.
+	switch {
+	case t == timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case t == durationType:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case t == rawMessageType,
+		t.Implements(jsonMarshalerType),
+		reflect.PointerTo(t).Implements(jsonMarshalerType):
+		return &openAPISchema{}
+	case t.Implements(textMarshalerType),
+		reflect.PointerTo(t).Implements(textMarshalerType):
+		return &openAPISchema{Type: "string"}
+	}
+
+	switch t.Kind() { //nolint:exhaustive // Remaining kinds cannot be described.
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: doc.schema(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: doc.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return doc.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + doc.schemaName(t)}
+	default:
+		return &openAPISchema{}
+	}
+}
+
+// schemaName returns the component schema name of the given named struct
+// type and adds the schema to the components, if not yet done.
+func (doc *openAPIDocument) schemaName(t reflect.Type) string {
+	if name, ok := doc.schemaNames[t]; ok {
+		return name
+	}
+
+	// Use the type name, and add the package path if the name is taken.
+	name := t.Name()
+	if _, taken := doc.Components.Schemas[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + t.Name()
+	}
+
+	// Register name before creating the schema in order to support recursion.
+	doc.schemaNames[t] = name
+	doc.Components.Schemas[name] = &openAPISchema{Type: "object"}
+	doc.Components.Schemas[name] = doc.structSchema(t)
+
+	return name
+}
+
+// structSchema returns the object schema of the given struct type, following
+// the rules of encoding/json.
+func (doc *openAPIDocument) structSchema(t reflect.Type) *openAPISchema {
+	s := &openAPISchema{
+		Type:       "object",
+		Properties: make(map[string]*openAPISchema),
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		// Get name from tag.
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		// Inline embedded structs without a name.
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for propName, prop := range doc.structSchema(ft).Properties {
+					if _, ok := s.Properties[propName]; !ok {
+						s.Properties[propName] = prop
+					}
+				}
+				continue
+			}
+		}
+
+		// Ignore unexported fields.
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = doc.schema(field.Type)
+	}
+
+	return s
+}
+
+// openAPIParameterSchema returns the schema of the given parameter.
+func openAPIParameterSchema(param Parameter, pattern string) *openAPISchema {
+	schema := &openAPISchema{
+		Type:    "string",
+		Pattern: pattern,
+		Default: param.Default,
+	}
+
+	switch param.Type {
+	case ParamTypeString:
+		if param.Pattern != "" {
+			schema.Pattern = param.Pattern
+		}
+	case ParamTypeInt:
+		schema.Type = "integer"
+		schema.Format = "int64"
+	case ParamTypeBool:
+		schema.Type = "boolean"
+	case ParamTypeDuration:
+		schema.Format = "duration"
+	case ParamTypeEnum:
+		schema.Enum = param.Enum
+	}
+
+	return schema
+}
+
+// openAPIPath converts an endpoint path to an OpenAPI path and returns the
+// path parameters.
+func openAPIPath(endpointPath string) (path string, params []*openAPIParameter) {
+	var (
+		b        strings.Builder
+		varStart = -1
+		depth    int
+	)
+	b.WriteString("/")
+
+	for i, c := range endpointPath {
+		switch {
+		case c == '{':
+			if depth == 0 {
+				varStart = i + 1
+			}
+			depth++
+		case c == '}' && depth > 0:
+			depth--
+			if depth == 0 {
+				name, pattern, _ := strings.Cut(endpointPath[varStart:i], ":")
+				param := &openAPIParameter{
+					Name:     name,
+					In:       "path",
+					Required: true,
+					Schema:   &openAPISchema{Type: "string"},
+				}
+				if pattern != "" {
+					param.Schema.Pattern = "^" + pattern + "$"
+				}
+				params = append(params, param)
+
+				b.WriteString("{" + name + "}")
+			}
+		case depth == 0:
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String(), params
+}
+
+// openAPITagName returns the tag of the endpoint, which is the first segment
+// of the path.
+func openAPITagName(endpointPath string) string {
+	tag, _, _ := strings.Cut(endpointPath, "/")
+	return tag
+}
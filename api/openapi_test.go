@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type openAPITestRequest struct {
+	Name    string `json:"name"`
+	Ignored string `json:"-"`
+	Nested  *openAPITestRequest
+}
+
+type openAPITestResponse struct {
+	Items []openAPITestRequest
+	Count int64 `json:"count,omitempty"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, RegisterEndpoint(Endpoint{
+		Path:         "test/openapi/{id:[0-9]+}",
+		Read:         PermitUser,
+		Write:        PermitAdmin,
+		WriteMethod:  http.MethodPut,
+		RequestType:  openAPITestRequest{},
+		ResponseType: &openAPITestResponse{},
+		Parameters: []Parameter{{
+			Method: http.MethodGet,
+			Field:  "filter",
+			Value:  "all",
+		}},
+		StructFunc: func(_ *Request) (i interface{}, err error) {
+			return &openAPITestResponse{}, nil
+		},
+		Name: "OpenAPI Test",
+	}))
+
+	doc := exportOpenAPI()
+	item, ok := doc.Paths["/v1/test/openapi/{id}"]
+	if !assert.True(t, ok, "path should exist") {
+		return
+	}
+
+	// Check read operation.
+	get := item["get"]
+	if assert.NotNil(t, get) {
+		assert.Equal(t, "User", get.Permission)
+		assert.NotEmpty(t, get.Security)
+		if assert.Len(t, get.Parameters, 2) {
+			assert.Equal(t, "id", get.Parameters[0].Name)
+			assert.Equal(t, "path", get.Parameters[0].In)
+			assert.Equal(t, "^[0-9]+$", get.Parameters[0].Schema.Pattern)
+			assert.Equal(t, "filter", get.Parameters[1].Name)
+			assert.Equal(t, "query", get.Parameters[1].In)
+		}
+		assert.Equal(t, "#/components/schemas/openAPITestResponse", get.Responses["200"].Content[MimeTypeJSON].Schema.Ref)
+	}
+
+	// Check write operation.
+	put := item["put"]
+	if assert.NotNil(t, put) {
+		assert.Equal(t, "Admin", put.Permission)
+		assert.Len(t, put.Parameters, 1)
+		if assert.NotNil(t, put.RequestBody) {
+			assert.Equal(t, "#/components/schemas/openAPITestRequest", put.RequestBody.Content[MimeTypeJSON].Schema.Ref)
+		}
+	}
+
+	// Check schemas.
+	reqSchema := doc.Components.Schemas["openAPITestRequest"]
+	if assert.NotNil(t, reqSchema) {
+		assert.Contains(t, reqSchema.Properties, "name")
+		assert.NotContains(t, reqSchema.Properties, "Ignored")
+		assert.Equal(t, "#/components/schemas/openAPITestRequest", reqSchema.Properties["Nested"].Ref)
+	}
+	respSchema := doc.Components.Schemas["openAPITestResponse"]
+	if assert.NotNil(t, respSchema) {
+		assert.Equal(t, "array", respSchema.Properties["Items"].Type)
+		assert.Equal(t, "int64", respSchema.Properties["count"].Format)
+	}
+
+	// Check that the document is served.
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+	body := assert.HTTPBody(testHandler.ServeHTTP, http.MethodGet, apiV1Path+"openapi", nil)
+	served := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal([]byte(body), &served))
+	assert.Equal(t, openAPIVersion, served["openapi"])
+}
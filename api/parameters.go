@@ -0,0 +1,199 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ParameterType defines the type of a parameter value.
+type ParameterType string
+
+// Parameter Types.
+const (
+	// ParamTypeString is any string, optionally validated with a pattern.
+	ParamTypeString ParameterType = "string"
+	// ParamTypeInt is a signed integer.
+	ParamTypeInt ParameterType = "int"
+	// ParamTypeBool is a boolean, as parsed by strconv.ParseBool. A parameter
+	// without a value is true.
+	ParamTypeBool ParameterType = "bool"
+	// ParamTypeDuration is a duration, as parsed by time.ParseDuration.
+	ParamTypeDuration ParameterType = "duration"
+	// ParamTypeEnum is one of the values defined in Enum.
+	ParamTypeEnum ParameterType = "enum"
+)
+
+// ErrInvalidParameter is returned when a request parameter is missing or
+// invalid. It is sent to the client with the status 400 Bad Request.
+var ErrInvalidParameter = errors.New("invalid parameter")
+
+// ParameterValues holds the parsed parameter values of a request.
+type ParameterValues map[string]interface{}
+
+// Has returns whether the parameter with the given field is set, either by
+// the request or by its default value.
+func (pv ParameterValues) Has(field string) bool {
+	_, ok := pv[field]
+	return ok
+}
+
+// String returns the value of a string or enum parameter.
+func (pv ParameterValues) String(field string) string {
+	v, _ := pv[field].(string)
+	return v
+}
+
+// Int returns the value of an int parameter.
+func (pv ParameterValues) Int(field string) int64 {
+	v, _ := pv[field].(int64)
+	return v
+}
+
+// Bool returns the value of a bool parameter.
+func (pv ParameterValues) Bool(field string) bool {
+	v, _ := pv[field].(bool)
+	return v
+}
+
+// Duration returns the value of a duration parameter.
+func (pv ParameterValues) Duration(field string) time.Duration {
+	v, _ := pv[field].(time.Duration)
+	return v
+}
+
+// check checks the parameter definition and prepares it for use.
+func (p *Parameter) check() error {
+	if p.Type == "" {
+		if p.Required || p.Default != "" || p.Pattern != "" || len(p.Enum) > 0 {
+			return fmt.Errorf("parameter %q needs a type for validation", p.Field)
+		}
+		return nil
+	}
+	if p.Field == "" {
+		return errors.New("parameter field is missing")
+	}
+
+	switch p.Type {
+	case ParamTypeString:
+		if p.Pattern != "" {
+			pattern, err := regexp.Compile(p.Pattern)
+			if err != nil {
+				return fmt.Errorf("parameter %q has invalid pattern: %w", p.Field, err)
+			}
+			p.pattern = pattern
+		}
+	case ParamTypeEnum:
+		if len(p.Enum) == 0 {
+			return fmt.Errorf("enum parameter %q has no values", p.Field)
+		}
+	case ParamTypeInt, ParamTypeBool, ParamTypeDuration:
+	default:
+		return fmt.Errorf("parameter %q has unknown type %q", p.Field, p.Type)
+	}
+
+	// Check default value.
+	if p.Default != "" {
+		if _, err := p.parse(p.Default); err != nil {
+			return fmt.Errorf("parameter %q has invalid default: %w", p.Field, err)
+		}
+	}
+
+	return nil
+}
+
+// parse parses and validates the given parameter value.
+func (p *Parameter) parse(value string) (interface{}, error) {
+	switch p.Type {
+	case ParamTypeString:
+		if p.pattern != nil && !p.pattern.MatchString(value) {
+			return nil, fmt.Errorf("must match %s", p.Pattern)
+		}
+		return value, nil
+
+	case ParamTypeInt:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+		return v, nil
+
+	case ParamTypeBool:
+		if value == "" {
+			return true, nil
+		}
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("must be a boolean")
+		}
+		return v, nil
+
+	case ParamTypeDuration:
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.New("must be a duration, eg. 10s")
+		}
+		return v, nil
+
+	case ParamTypeEnum:
+		for _, option := range p.Enum {
+			if value == option {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("must be one of %q", p.Enum)
+
+	default:
+		return value, nil
+	}
+}
+
+// parseParameters parses and validates the typed parameters of the endpoint
+// for the given effective method. Values are taken from the URL variables
+// first and the URL query second.
+func (e *Endpoint) parseParameters(ar *Request, method string) (ParameterValues, error) {
+	values := make(ParameterValues)
+	query := ar.URL.Query()
+
+	for i := range e.Parameters {
+		p := &e.Parameters[i]
+		if p.Type == "" || (p.Method != "" && p.Method != method) {
+			continue
+		}
+
+		// Get the raw value.
+		value, ok := ar.URLVars[p.Field]
+		if !ok {
+			ok = query.Has(p.Field)
+			value = query.Get(p.Field)
+		}
+		if !ok {
+			switch {
+			case p.Required:
+				return nil, ErrorWithStatus(
+					fmt.Errorf("%w %q: missing", ErrInvalidParameter, p.Field),
+					http.StatusBadRequest,
+				)
+			case p.Default != "":
+				value = p.Default
+			default:
+				continue
+			}
+		}
+
+		// Parse and validate.
+		v, err := p.parse(value)
+		if err != nil {
+			return nil, ErrorWithStatus(
+				fmt.Errorf("%w %q: %w", ErrInvalidParameter, p.Field, err),
+				http.StatusBadRequest,
+			)
+		}
+		values[p.Field] = v
+	}
+
+	return values, nil
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParameters(t *testing.T) {
+	t.Parallel()
+
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+
+	assert.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/params/{id}",
+		Read: PermitAnyone,
+		Parameters: []Parameter{
+			{Field: "id", Type: ParamTypeInt},
+			{Field: "count", Type: ParamTypeInt, Required: true},
+			{Field: "verbose", Type: ParamTypeBool},
+			{Field: "wait", Type: ParamTypeDuration, Default: "5s"},
+			{Field: "mode", Type: ParamTypeEnum, Enum: []string{"fast", "slow"}, Default: "fast"},
+			{Field: "name", Type: ParamTypeString, Pattern: "^[a-z]+$"},
+			{Field: "doc-only"},
+		},
+		ActionFunc: func(ar *Request) (msg string, err error) {
+			return fmt.Sprintf(
+				"%d %d %v %s %s %q %v",
+				ar.Params.Int("id"),
+				ar.Params.Int("count"),
+				ar.Params.Bool("verbose"),
+				ar.Params.Duration("wait"),
+				ar.Params.String("mode"),
+				ar.Params.String("name"),
+				ar.Params.Has("name"),
+			), nil
+		},
+	}))
+
+	// Valid requests.
+	for query, expected := range map[string]string{
+		"test/params/1?count=2": `1 2 false 5s fast "" false`,
+		"test/params/1?count=2&verbose&wait=1m&mode=slow&name=abc&doc-only=x": `1 2 true 1m0s slow "abc" true`,
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, apiV1Path+query, nil)
+		testHandler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, query)
+		assert.Contains(t, rec.Body.String(), expected, query)
+	}
+
+	// Invalid requests.
+	for _, query := range []string{
+		"test/params/x?count=2",
+		"test/params/1",
+		"test/params/1?count=a",
+		"test/params/1?count=2&verbose=maybe",
+		"test/params/1?count=2&wait=soon",
+		"test/params/1?count=2&mode=medium",
+		"test/params/1?count=2&name=ABC",
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, apiV1Path+query, nil)
+		testHandler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+		assert.Contains(t, rec.Body.String(), ErrInvalidParameter.Error(), query)
+	}
+
+	// Invalid definitions.
+	for _, param := range []Parameter{
+		{Field: "a", Type: "float"},
+		{Field: "a", Type: ParamTypeEnum},
+		{Field: "a", Type: ParamTypeString, Pattern: "("},
+		{Field: "a", Type: ParamTypeInt, Default: "one"},
+		{Field: "a", Required: true},
+	} {
+		assert.ErrorIs(t, RegisterEndpoint(Endpoint{
+			Path:       "test/params-invalid",
+			Read:       PermitAnyone,
+			Parameters: []Parameter{param},
+			ActionFunc: func(_ *Request) (msg string, err error) {
+				return "", nil
+			},
+		}), ErrInvalidEndpoint)
+	}
+}
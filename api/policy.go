@@ -0,0 +1,308 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/config"
+)
+
+// SecurityPolicy defines the cross-origin policy, the content security policy
+// and the security headers of the API.
+type SecurityPolicy struct {
+	// AllowedOrigins defines origins that may make cross-origin requests, in
+	// addition to the origin of the API itself. Entries have the format
+	// "<scheme>://<host>[:<port>]" and must match the origin exactly, with "*"
+	// as the port allowing any port. Browser extension origins use the extension
+	// ID as the host, which may be "*" to allow any extension, eg.
+	// "moz-extension://*".
+	AllowedOrigins []string
+
+	// ContentSecurityPolicy defines the directives of the
+	// Content-Security-Policy header, which is only sent in production mode.
+	// The key is the directive name and the value are its values.
+	ContentSecurityPolicy map[string]string
+
+	// Headers defines additional security headers that are sent with every
+	// response.
+	Headers map[string]string
+}
+
+// effectiveSecurityPolicy is the security policy in use, with the
+// configuration applied.
+type effectiveSecurityPolicy struct {
+	SecurityPolicy
+
+	// ContentSecurityPolicyHeader is the compiled Content-Security-Policy
+	// header.
+	ContentSecurityPolicyHeader string
+
+	origins []*url.URL
+}
+
+var (
+	basePolicy = defaultSecurityPolicy()
+
+	activePolicy         *effectiveSecurityPolicy
+	activePolicyValidity *config.ValidityFlag
+	policyLock           sync.Mutex
+
+	cspDirectiveNameRegex = regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)
+	headerNameRegex       = regexp.MustCompile(`^[A-Za-z0-9]+(-[A-Za-z0-9]+)*$`)
+)
+
+func defaultSecurityPolicy() SecurityPolicy {
+	return SecurityPolicy{
+		AllowedOrigins: []string{
+			// Allow access for the browser extension.
+			// TODO(ppacher):
+			// This currently allows access from any browser extension.
+			// Can we reduce that to only our browser extension?
+			// Also, what do we need to support Firefox?
+			"chrome-extension://*",
+		},
+		ContentSecurityPolicy: map[string]string{
+			"default-src": "'self'",
+			"connect-src": "https://*.safing.io 'self'",
+			"style-src":   "'self' 'unsafe-inline'",
+			"img-src":     "'self' data: blob:",
+		},
+		Headers: map[string]string{
+			"Referrer-Policy":        "same-origin",
+			"X-Content-Type-Options": "nosniff",
+			"X-Frame-Options":        "deny",
+			"X-XSS-Protection":       "1; mode=block",
+			"X-DNS-Prefetch-Control": "off",
+		},
+	}
+}
+
+// SetSecurityPolicy sets the security policy of the API. The configured
+// allowed origins, CSP directives and headers are applied on top of it.
+func SetSecurityPolicy(policy SecurityPolicy) error {
+	if err := policy.check(); err != nil {
+		return err
+	}
+
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	basePolicy = policy.copy()
+	activePolicy = nil
+	return nil
+}
+
+// GetSecurityPolicy returns the security policy in use, with the configuration
+// applied.
+func GetSecurityPolicy() SecurityPolicy {
+	return getSecurityPolicy().copy()
+}
+
+func getSecurityPolicy() *effectiveSecurityPolicy {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	// Return cached policy if the configuration did not change.
+	if activePolicy != nil && activePolicyValidity.IsValid() {
+		return activePolicy
+	}
+
+	// Apply the configuration to the base policy.
+	// Configured values were validated when they were set.
+	activePolicyValidity = config.NewValidityFlag()
+	policy := basePolicy.copy()
+	policy.AllowedOrigins = append(policy.AllowedOrigins, allowedOriginsConfig()...)
+	for _, entry := range cspDirectivesConfig() {
+		name, value, _ := parseCSPDirective(entry)
+		policy.ContentSecurityPolicy[name] = value
+	}
+	for _, entry := range securityHeadersConfig() {
+		name, value, _ := parseSecurityHeader(entry)
+		if value == "" {
+			delete(policy.Headers, name)
+		} else {
+			policy.Headers[name] = value
+		}
+	}
+
+	activePolicy = &effectiveSecurityPolicy{
+		SecurityPolicy:              policy,
+		ContentSecurityPolicyHeader: policy.compileCSP(),
+	}
+	for _, origin := range policy.AllowedOrigins {
+		if u, err := parseOrigin(origin); err == nil {
+			activePolicy.origins = append(activePolicy.origins, u)
+		}
+	}
+
+	return activePolicy
+}
+
+// allowsOrigin returns whether the given origin is allowed to make
+// cross-origin requests.
+func (p *effectiveSecurityPolicy) allowsOrigin(origin *url.URL) bool {
+	for _, allowed := range p.origins {
+		switch {
+		case allowed.Scheme != origin.Scheme:
+		case allowed.Host == "*":
+			return true
+		case allowed.Host == origin.Host:
+			return true
+		case strings.HasSuffix(allowed.Host, ":*") &&
+			strings.TrimSuffix(allowed.Host, ":*") == strings.TrimSuffix(origin.Host, ":"+origin.Port()):
+			return true
+		}
+	}
+	return false
+}
+
+// writeHeaders writes the security headers of the policy.
+func (p *effectiveSecurityPolicy) writeHeaders(w http.ResponseWriter) {
+	for name, value := range p.Headers {
+		w.Header().Set(name, value)
+	}
+
+	// Add CSP Header in production mode.
+	if !devMode() && p.ContentSecurityPolicyHeader != "" {
+		w.Header().Set("Content-Security-Policy", p.ContentSecurityPolicyHeader)
+	}
+}
+
+func (p SecurityPolicy) copy() SecurityPolicy {
+	c := SecurityPolicy{
+		AllowedOrigins:        append([]string{}, p.AllowedOrigins...),
+		ContentSecurityPolicy: make(map[string]string, len(p.ContentSecurityPolicy)),
+		Headers:               make(map[string]string, len(p.Headers)),
+	}
+	for k, v := range p.ContentSecurityPolicy {
+		c.ContentSecurityPolicy[k] = v
+	}
+	for k, v := range p.Headers {
+		c.Headers[k] = v
+	}
+	return c
+}
+
+func (p SecurityPolicy) check() error {
+	for _, origin := range p.AllowedOrigins {
+		if _, err := parseOrigin(origin); err != nil {
+			return err
+		}
+	}
+	for name, value := range p.ContentSecurityPolicy {
+		if _, _, err := parseCSPDirective(name + " " + value); err != nil {
+			return err
+		}
+	}
+	for name, value := range p.Headers {
+		if _, _, err := parseSecurityHeader(name + ": " + value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileCSP returns the Content-Security-Policy header value, starting with
+// the default-src directive.
+func (p SecurityPolicy) compileCSP() string {
+	names := make([]string, 0, len(p.ContentSecurityPolicy))
+	for name := range p.ContentSecurityPolicy {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "default-src" || names[j] == "default-src" {
+			return names[i] == "default-src"
+		}
+		return names[i] < names[j]
+	})
+
+	directives := make([]string, 0, len(names))
+	for _, name := range names {
+		directives = append(directives, strings.TrimSpace(name+" "+p.ContentSecurityPolicy[name]))
+	}
+	return strings.Join(directives, "; ")
+}
+
+// parseOrigin parses and validates an allowed origin. An entry with the port
+// "*" allows any port of the host.
+func parseOrigin(origin string) (*url.URL, error) {
+	withoutPort, anyPort := strings.CutSuffix(origin, ":*")
+	u, err := url.Parse(withoutPort)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("invalid origin %q: %w", origin, err)
+	case u.Scheme == "" || u.Host == "" || strings.HasSuffix(u.Host, ":"):
+		return nil, fmt.Errorf("invalid origin %q: must have the format <scheme>://<host>[:<port>]", origin)
+	case u.User != nil || u.Path != "" || u.RawQuery != "" || u.ForceQuery || u.Fragment != "":
+		return nil, fmt.Errorf("invalid origin %q: must not have user info, a path, query or fragment", origin)
+	case u.Host == "*" && !strings.HasSuffix(u.Scheme, "-extension"):
+		return nil, fmt.Errorf("invalid origin %q: only browser extension origins may use a wildcard", origin)
+	case anyPort && (u.Host == "*" || u.Port() != ""):
+		return nil, fmt.Errorf("invalid origin %q: must have the format <scheme>://<host>:*", origin)
+	}
+	if anyPort {
+		u.Host += ":*"
+	}
+	return u, nil
+}
+
+// parseCSPDirective parses and validates a CSP directive in the format
+// "<name> [<values>]".
+func parseCSPDirective(directive string) (name, value string, err error) {
+	name, value, _ = strings.Cut(strings.TrimSpace(directive), " ")
+	value = strings.TrimSpace(value)
+	switch {
+	case !cspDirectiveNameRegex.MatchString(name):
+		return "", "", fmt.Errorf("invalid CSP directive name %q", name)
+	case strings.ContainsAny(value, ";,\r\n"):
+		return "", "", fmt.Errorf("invalid value of CSP directive %q", name)
+	}
+	return name, value, nil
+}
+
+// parseSecurityHeader parses and validates a header in the format
+// "<name>: [<value>]". An empty value removes the header.
+func parseSecurityHeader(header string) (name, value string, err error) {
+	name, value, ok := strings.Cut(header, ":")
+	if !ok {
+		return "", "", fmt.Errorf("invalid header %q: must have the format <name>: <value>", header)
+	}
+	name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+	value = strings.TrimSpace(value)
+	switch {
+	case !headerNameRegex.MatchString(name):
+		return "", "", fmt.Errorf("invalid header name %q", name)
+	case strings.HasPrefix(name, "Access-Control-") || name == "Content-Security-Policy":
+		return "", "", fmt.Errorf("header %q is controlled by the allowed origins and CSP directives", name)
+	case strings.ContainsAny(value, "\r\n"):
+		return "", "", fmt.Errorf("invalid value of header %q", name)
+	}
+	return name, value, nil
+}
+
+// validateStringArray returns a config validation function that validates
+// every entry with the given function.
+func validateStringArray(fn func(entry string) error) func(value interface{}) error {
+	return func(value interface{}) error {
+		entries, ok := value.([]string)
+		if !ok {
+			return errors.New("value is not a string array")
+		}
+		for _, entry := range entries {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func getEffectiveSecurityPolicy(_ *Request) (i interface{}, err error) {
+	return getSecurityPolicy(), nil
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecurityPolicyParsing(t *testing.T) {
+	t.Parallel()
+
+	// Origins.
+	for _, origin := range []string{
+		"https://example.com",
+		"http://127.0.0.1:8080",
+		"http://localhost:*",
+		"http://[::1]:*",
+		"chrome-extension://*",
+		"moz-extension://0f5a7e4b-1b7c-4d2e-9c3a-8d6f5e4a3b2c",
+	} {
+		_, err := parseOrigin(origin)
+		assert.NoError(t, err, origin)
+	}
+	for _, origin := range []string{
+		"example.com",
+		"https://",
+		"https://*",
+		"https://example.com/",
+		"https://example.com/path",
+		"https://example.com:",
+		"https://example.com:8443:*",
+		"chrome-extension://*:*",
+		"https://user@example.com",
+		"https://example.com?query",
+	} {
+		_, err := parseOrigin(origin)
+		assert.Error(t, err, origin)
+	}
+
+	// CSP directives.
+	name, value, err := parseCSPDirective(" connect-src  'self' https://example.com ")
+	require.NoError(t, err)
+	assert.Equal(t, "connect-src", name)
+	assert.Equal(t, "'self' https://example.com", value)
+	name, value, err = parseCSPDirective("upgrade-insecure-requests")
+	require.NoError(t, err)
+	assert.Equal(t, "upgrade-insecure-requests", name)
+	assert.Equal(t, "", value)
+	_, _, err = parseCSPDirective("Connect-Src 'self'")
+	assert.Error(t, err)
+	_, _, err = parseCSPDirective("connect-src 'self'; script-src *")
+	assert.Error(t, err)
+
+	// Headers.
+	name, value, err = parseSecurityHeader("x-frame-options: sameorigin")
+	require.NoError(t, err)
+	assert.Equal(t, "X-Frame-Options", name)
+	assert.Equal(t, "sameorigin", value)
+	name, value, err = parseSecurityHeader("X-XSS-Protection:")
+	require.NoError(t, err)
+	assert.Equal(t, "X-Xss-Protection", name)
+	assert.Equal(t, "", value)
+	_, _, err = parseSecurityHeader("X-Frame-Options")
+	assert.Error(t, err)
+	_, _, err = parseSecurityHeader("Access-Control-Allow-Origin: *")
+	assert.Error(t, err)
+	_, _, err = parseSecurityHeader("Content-Security-Policy: default-src *")
+	assert.Error(t, err)
+	_, _, err = parseSecurityHeader("X-Test: a\r\nX-Injected: b")
+	assert.Error(t, err)
+
+	// Validation of config values.
+	validate := validateStringArray(func(entry string) error {
+		_, err := parseOrigin(entry)
+		return err
+	})
+	assert.NoError(t, validate([]string{"https://example.com"}))
+	assert.Error(t, validate([]string{"https://example.com", "invalid"}))
+	assert.Error(t, validate("https://example.com"))
+}
+
+func TestSecurityPolicy(t *testing.T) {
+	t.Parallel()
+
+	// Directives are compiled in a stable order, starting with default-src.
+	policy := defaultSecurityPolicy()
+	assert.Equal(t,
+		"default-src 'self'; connect-src https://*.safing.io 'self'; img-src 'self' data: blob:; style-src 'self' 'unsafe-inline'",
+		policy.compileCSP(),
+	)
+
+	// Invalid policies are rejected.
+	invalid := defaultSecurityPolicy()
+	invalid.AllowedOrigins = append(invalid.AllowedOrigins, "https://*")
+	assert.Error(t, SetSecurityPolicy(invalid))
+	invalid = defaultSecurityPolicy()
+	invalid.Headers["Access-Control-Allow-Origin"] = "*"
+	assert.Error(t, SetSecurityPolicy(invalid))
+
+	// Origins.
+	policy.AllowedOrigins = append(
+		policy.AllowedOrigins,
+		"moz-extension://0f5a7e4b-1b7c-4d2e-9c3a-8d6f5e4a3b2c",
+		"https://example.com",
+		"http://localhost:8080",
+		"http://127.0.0.1:*",
+	)
+	effective := &effectiveSecurityPolicy{SecurityPolicy: policy}
+	for _, origin := range policy.AllowedOrigins {
+		u, err := parseOrigin(origin)
+		require.NoError(t, err)
+		effective.origins = append(effective.origins, u)
+	}
+	for origin, allowed := range map[string]bool{
+		"chrome-extension://abcdefghijklmnop":                     true,
+		"moz-extension://0f5a7e4b-1b7c-4d2e-9c3a-8d6f5e4a3b2c":    true,
+		"moz-extension://11111111-1b7c-4d2e-9c3a-8d6f5e4a3b2c":    false,
+		"https://example.com":                                     true,
+		"https://example.com:8443":                                false,
+		"http://example.com":                                      false,
+		"https://sub.example.com":                                 false,
+		"http://localhost:8080":                                   true,
+		"http://localhost:8081":                                   false,
+		"http://127.0.0.1":                                        true,
+		"http://127.0.0.1:8081":                                   true,
+		"http://127.0.0.10:8081":                                  false,
+		"safari-web-extension://0f5a7e4b-1b7c-4d2e-9c3a-8d6f5e4a": false,
+	} {
+		u, err := url.Parse(origin)
+		require.NoError(t, err)
+		assert.Equal(t, allowed, effective.allowsOrigin(u), origin)
+	}
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit defines how many requests a single client may make in a period of
+// time. Clients are identified by their remote address.
+type RateLimit struct {
+	// Requests is the amount of requests that are allowed per period.
+	Requests int
+	// Per is the period in which the requests are allowed.
+	Per time.Duration
+	// Burst is the amount of requests that may be made at once.
+	// If omitted, defaults to Requests.
+	Burst int
+}
+
+// RateLimitedHandler defines the handler interface to specify a rate limit
+// for an API handler. A nil rate limit means that the handler is not limited.
+type RateLimitedHandler interface {
+	RateLimit(*http.Request) *RateLimit
+}
+
+func (rl *RateLimit) check() error {
+	switch {
+	case rl.Requests <= 0:
+		return errors.New("rate limit requests must be greater than zero")
+	case rl.Per <= 0:
+		return errors.New("rate limit period must be greater than zero")
+	case rl.Burst < 0:
+		return errors.New("rate limit burst must not be negative")
+	default:
+		return nil
+	}
+}
+
+// every returns the interval in which a single request is allowed.
+func (rl *RateLimit) every() rate.Limit {
+	return rate.Every(rl.Per / time.Duration(rl.Requests))
+}
+
+func (rl *RateLimit) burst() int {
+	if rl.Burst > 0 {
+		return rl.Burst
+	}
+	return rl.Requests
+}
+
+// Scopes for the configured rate limits.
+const (
+	rateLimitScopeToken   = "token"
+	rateLimitScopeAddress = "address"
+)
+
+// rateLimiterKey identifies a rate limiter. The scope is either one of the
+// configured scopes or the handler, as returned by rateLimitHandlerScope. The
+// client is either the identity of the token or the remote host.
+type rateLimiterKey struct {
+	scope  string
+	client string
+}
+
+type rateLimiter struct {
+	limit    RateLimit
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+var (
+	rateLimiters        = make(map[rateLimiterKey]*rateLimiter)
+	rateLimitersLock    sync.Mutex
+	rateLimitersPruned  time.Time
+	rateLimitPruneAfter = 10 * time.Minute
+)
+
+// checkRateLimits checks the configured rate limits and the given rate limit
+// of the handler with the given scope for a request of the given client. It
+// returns whether the request may proceed and, if not, after how much time it
+// may be retried.
+func checkRateLimits(remoteHost string, token *AuthToken, handlerScope string, handlerLimit *RateLimit) (retryAfter time.Duration, ok bool) {
+	// Do not limit the software itself.
+	if token != nil && token.Read == PermitSelf {
+		return 0, true
+	}
+
+	rateLimitersLock.Lock()
+	defer rateLimitersLock.Unlock()
+
+	now := time.Now()
+	pruneRateLimiters(now)
+
+	// Collect reservations of all applicable rate limits.
+	var reservations []*rate.Reservation
+	reserve := func(key rateLimiterKey, limit RateLimit) {
+		rl, ok := rateLimiters[key]
+		if !ok || rl.limit != limit {
+			rl = &rateLimiter{
+				limit:   limit,
+				limiter: rate.NewLimiter(limit.every(), limit.burst()),
+			}
+			rateLimiters[key] = rl
+		}
+		rl.lastUsed = now
+		reservations = append(reservations, rl.limiter.ReserveN(now, 1))
+	}
+
+	if perToken := rateLimitPerToken(); perToken > 0 && token != nil && token.Identity != "" {
+		reserve(
+			rateLimiterKey{scope: rateLimitScopeToken, client: token.Identity},
+			RateLimit{Requests: int(perToken), Per: time.Minute},
+		)
+	}
+	if perAddress := rateLimitPerAddress(); perAddress > 0 {
+		reserve(
+			rateLimiterKey{scope: rateLimitScopeAddress, client: remoteHost},
+			RateLimit{Requests: int(perAddress), Per: time.Minute},
+		)
+	}
+	if handlerLimit != nil {
+		reserve(
+			rateLimiterKey{scope: handlerScope, client: remoteHost},
+			*handlerLimit,
+		)
+	}
+
+	// Check if any limit is exceeded.
+	for _, r := range reservations {
+		if delay := r.DelayFrom(now); delay > retryAfter {
+			retryAfter = delay
+		}
+	}
+	if retryAfter == 0 {
+		return 0, true
+	}
+
+	// Give back reservations, as the request will not proceed.
+	for _, r := range reservations {
+		r.CancelAt(now)
+	}
+	return retryAfter, false
+}
+
+// pruneRateLimiters removes unused rate limiters.
+// The rate limiters lock must be held.
+func pruneRateLimiters(now time.Time) {
+	if now.Sub(rateLimitersPruned) < time.Minute {
+		return
+	}
+	rateLimitersPruned = now
+
+	for key, rl := range rateLimiters {
+		// Keep rate limiters until they would be fully replenished.
+		keep := rl.limit.Per * time.Duration(rl.limit.burst()) / time.Duration(rl.limit.Requests)
+		if keep < rateLimitPruneAfter {
+			keep = rateLimitPruneAfter
+		}
+		if now.Sub(rl.lastUsed) > keep {
+			delete(rateLimiters, key)
+		}
+	}
+}
+
+// checkRequestRateLimits checks the rate limits of the given request and
+// handler and replies with 429 Too Many Requests if a limit is exceeded.
+func checkRequestRateLimits(w http.ResponseWriter, r *http.Request, token *AuthToken, handler http.Handler) (ok bool) {
+	var handlerScope string
+	var handlerLimit *RateLimit
+	if rlHandler, ok := handler.(RateLimitedHandler); ok {
+		handlerLimit = rlHandler.RateLimit(r)
+		if handlerLimit != nil {
+			handlerScope = rateLimitHandlerScope(r)
+		}
+	}
+
+	retryAfter, ok := checkRateLimits(remoteHost(r.RemoteAddr), token, handlerScope, handlerLimit)
+	if ok {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+	http.Error(w, "Too many requests. Please try again later.", http.StatusTooManyRequests)
+	return false
+}
+
+// rateLimitHandlerScope returns the scope of the rate limit of the handler of
+// the given request. Endpoints are identified by their version and path, other
+// handlers by their route.
+func rateLimitHandlerScope(r *http.Request) string {
+	apiEndpoint, apiRequest := getAPIContext(r)
+	if apiEndpoint != nil {
+		return "endpoint:" + endpointKey(apiEndpoint.Version, apiEndpoint.Path)
+	}
+	if apiRequest != nil && apiRequest.Route != nil {
+		if template, err := apiRequest.Route.GetPathTemplate(); err == nil {
+			return "route:" + template
+		}
+	}
+	return "path:" + r.URL.Path
+}
+
+// retryAfterSeconds returns the given duration in full seconds, rounded up.
+func retryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// remoteHost returns the host part of the given remote address.
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+
+	assert.NoError(t, RegisterEndpoint(Endpoint{
+		Path:      "test/rate-limit",
+		Read:      PermitAnyone,
+		RateLimit: &RateLimit{Requests: 2, Per: time.Hour},
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return successMsg, nil
+		},
+	}))
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, apiV1Path+"test/rate-limit", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		return w
+	}
+
+	// The first two requests pass, the third is limited.
+	assert.Equal(t, http.StatusOK, request("192.0.2.1:1000").Code)
+	assert.Equal(t, http.StatusOK, request("192.0.2.1:1001").Code)
+	w := request("192.0.2.1:1002")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1800", w.Header().Get("Retry-After"))
+
+	// Other clients are limited separately.
+	assert.Equal(t, http.StatusOK, request("192.0.2.2:1000").Code)
+
+	// Invalid rate limits are rejected.
+	assert.Error(t, RegisterEndpoint(Endpoint{
+		Path:      "test/rate-limit-invalid",
+		Read:      PermitAnyone,
+		RateLimit: &RateLimit{Per: time.Minute},
+		ActionFunc: func(_ *Request) (msg string, err error) {
+			return successMsg, nil
+		},
+	}))
+}
+
+func TestRateLimitExemptSelf(t *testing.T) {
+	t.Parallel()
+
+	limit := &RateLimit{Requests: 1, Per: time.Hour}
+	self := &AuthToken{Read: PermitSelf, Write: PermitSelf}
+	user := &AuthToken{Read: PermitUser, Write: PermitUser}
+
+	for i := 0; i < 3; i++ {
+		_, ok := checkRateLimits("192.0.2.3", self, "test", limit)
+		assert.True(t, ok, "the software itself must not be limited")
+	}
+
+	_, ok := checkRateLimits("192.0.2.3", user, "test", limit)
+	assert.True(t, ok)
+	retryAfter, ok := checkRateLimits("192.0.2.3", user, "test", limit)
+	assert.False(t, ok)
+	assert.Greater(t, retryAfter, time.Duration(0))
+}
+
+func TestRateLimitPerToken(t *testing.T) { //nolint:paralleltest // Changes the global config.
+	previous := rateLimitPerToken
+	rateLimitPerToken = func() int64 { return 1 }
+	defer func() {
+		rateLimitPerToken = previous
+	}()
+
+	// Tokens are copied for every request, but are limited by their identity.
+	newToken := func(identity string) *AuthToken {
+		return &AuthToken{Read: PermitUser, Write: PermitUser, Identity: identity}
+	}
+	_, ok := checkRateLimits("192.0.2.4", newToken("session:a"), "", nil)
+	assert.True(t, ok)
+	_, ok = checkRateLimits("192.0.2.5", newToken("session:a"), "", nil)
+	assert.False(t, ok)
+	_, ok = checkRateLimits("192.0.2.4", newToken("session:b"), "", nil)
+	assert.True(t, ok)
+}
+
+func TestRateLimitPerEndpoint(t *testing.T) {
+	t.Parallel()
+
+	testHandler := &mainHandler{
+		mux: mainMux,
+	}
+
+	// Endpoints that share a rate limit are limited separately.
+	limit := &RateLimit{Requests: 1, Per: time.Hour}
+	for _, path := range []string{"test/rate-limit-shared-a", "test/rate-limit-shared-b"} {
+		assert.NoError(t, RegisterEndpoint(Endpoint{
+			Path:      path,
+			Read:      PermitAnyone,
+			RateLimit: limit,
+			ActionFunc: func(_ *Request) (msg string, err error) {
+				return successMsg, nil
+			},
+		}))
+	}
+
+	request := func(path string) int {
+		r := httptest.NewRequest(http.MethodGet, apiV1Path+path, nil)
+		r.RemoteAddr = "192.0.2.6:1000"
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, request("test/rate-limit-shared-a"))
+	assert.Equal(t, http.StatusOK, request("test/rate-limit-shared-b"))
+	assert.Equal(t, http.StatusTooManyRequests, request("test/rate-limit-shared-a"))
+	assert.Equal(t, http.StatusTooManyRequests, request("test/rate-limit-shared-b"))
+}
@@ -23,6 +23,9 @@ type Request struct {
 	// URLVars contains the URL variables extracted by the gorilla mux.
 	URLVars map[string]string
 
+	// Params contains the parsed values of the typed endpoint parameters.
+	Params ParameterValues
+
 	// AuthToken is the request-side authentication token assigned.
 	AuthToken *AuthToken
 
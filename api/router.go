@@ -51,10 +51,10 @@ func RegisterHandleFunc(path string, handleFunc func(http.ResponseWriter, *http.
 	return mainMux.HandleFunc(path, handleFunc)
 }
 
-func startServer() {
+func startServer() error {
 	// Check if server is enabled.
 	if !EnableServer {
-		return
+		return nil
 	}
 
 	// Configure server.
@@ -64,8 +64,24 @@ func startServer() {
 		mux: mainMux,
 	}
 
-	// Start server manager.
-	module.StartServiceWorker("http server manager", 0, serverManager)
+	// Configure TLS.
+	if tlsEnabled() {
+		tlsDir, err := getTLSDir()
+		if err != nil {
+			return fmt.Errorf("failed to create tls directory: %w", err)
+		}
+		server.TLSConfig, err = newTLSConfig(tlsDir, tlsHosts(server.Addr))
+		if err != nil {
+			return fmt.Errorf("failed to configure tls: %w", err)
+		}
+	}
+
+	// Start server manager, unless only the unix socket is used.
+	if server.Addr != "" {
+		module.StartServiceWorker("http server manager", 0, serverManager)
+	}
+
+	return startSocketServer()
 }
 
 func stopServer() error {
@@ -74,6 +90,10 @@ func stopServer() error {
 		return nil
 	}
 
+	if err := stopSocketServer(); err != nil {
+		log.Warningf("api: failed to stop unix socket server: %s", err)
+	}
+
 	if server.Addr != "" {
 		return server.Shutdown(context.Background())
 	}
@@ -84,11 +104,19 @@ func stopServer() error {
 // Serve starts serving the API endpoint.
 func serverManager(_ context.Context) error {
 	// start serving
-	log.Infof("api: starting to listen on %s", server.Addr)
+	if server.TLSConfig != nil {
+		log.Infof("api: starting to listen on %s with tls", server.Addr)
+	} else {
+		log.Infof("api: starting to listen on %s", server.Addr)
+	}
 	backoffDuration := 10 * time.Second
 	for {
 		// always returns an error
 		err := module.RunWorker("http endpoint", func(ctx context.Context) error {
+			if server.TLSConfig != nil {
+				// Certificates are set in the TLS config.
+				return server.ListenAndServeTLS("", "")
+			}
 			return server.ListenAndServe()
 		})
 		// return on shutdown error
@@ -103,6 +131,10 @@ func serverManager(_ context.Context) error {
 
 type mainHandler struct {
 	mux *mux.Router
+
+	// checkAuth optionally replaces the authentication of requests, eg. in
+	// order to use the credentials of a batch request.
+	checkAuth checkAuthFunc
 }
 
 func (mh *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -112,6 +144,8 @@ func (mh *mainHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (mh *mainHandler) handle(w http.ResponseWriter, r *http.Request) error {
+	started := time.Now()
+
 	// Setup context trace logging.
 	ctx, tracer := log.AddTracer(r.Context())
 	// Add request context.
@@ -131,25 +165,12 @@ func (mh *mainHandler) handle(w http.ResponseWriter, r *http.Request) error {
 			tracer.Debugf("api request: %s %d %s %s", lrw.Request.RemoteAddr, lrw.Status, lrw.Request.Method, lrw.Request.RequestURI)
 		}
 		tracer.Submit()
+		observeRequest(apiRequest, lrw.Status, started)
 	}()
 
 	// Add security headers.
-	w.Header().Set("Referrer-Policy", "same-origin")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("X-Frame-Options", "deny")
-	w.Header().Set("X-XSS-Protection", "1; mode=block")
-	w.Header().Set("X-DNS-Prefetch-Control", "off")
-
-	// Add CSP Header in production mode.
-	if !devMode() {
-		w.Header().Set(
-			"Content-Security-Policy",
-			"default-src 'self'; "+
-				"connect-src https://*.safing.io 'self'; "+
-				"style-src 'self' 'unsafe-inline'; "+
-				"img-src 'self' data: blob:",
-		)
-	}
+	policy := getSecurityPolicy()
+	policy.writeHeaders(w)
 
 	// Check Cross-Origin Requests.
 	origin := r.Header.Get("Origin")
@@ -170,12 +191,8 @@ func (mh *mainHandler) handle(w http.ResponseWriter, r *http.Request) error {
 			// Origin (with port) matches Host.
 		case originURL.Hostname() == r.Host:
 			// Origin (without port) matches Host.
-		case originURL.Scheme == "chrome-extension":
-			// Allow access for the browser extension
-			// TODO(ppacher):
-			// This currently allows access from any browser extension.
-			// Can we reduce that to only our browser extension?
-			// Also, what do we need to support Firefox?
+		case policy.allowsOrigin(originURL):
+			// Origin is allowed by the security policy.
 		case devMode() &&
 			utils.StringInSlice(allowedDevCORSOrigins, originURL.Hostname()):
 			// We are in dev mode and the request is coming from the allowed
@@ -240,6 +257,12 @@ func (mh *mainHandler) handle(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
+	// Endpoints may be routed directly, eg. by a test harness.
+	if apiEndpoint, ok := handler.(*Endpoint); ok {
+		apiRequest.HandlerCache = apiEndpoint
+		handler = &endpointHandler{}
+	}
+
 	// Be sure that URLVars always is a map.
 	if apiRequest.URLVars == nil {
 		apiRequest.URLVars = make(map[string]string)
@@ -261,13 +284,27 @@ func (mh *mainHandler) handle(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	}
 
+	// Record write requests in the audit log, including denied ones.
+	if !readMethod {
+		defer auditHTTPRequest(lrw, apiRequest)
+	}
+
 	// Check authentication.
-	apiRequest.AuthToken = authenticateRequest(lrw, r, handler, readMethod)
+	checkAuthFn := mh.checkAuth
+	if checkAuthFn == nil {
+		checkAuthFn = checkAuth
+	}
+	apiRequest.AuthToken = authenticateRequest(lrw, r, handler, readMethod, checkAuthFn)
 	if apiRequest.AuthToken == nil {
 		// Authenticator already replied.
 		return nil
 	}
 
+	// Check rate limits.
+	if !checkRequestRateLimits(lrw, r, apiRequest.AuthToken, handler) {
+		return nil
+	}
+
 	// Wait for the owning module to be ready.
 	if moduleHandler, ok := handler.(ModuleHandler); ok {
 		if !moduleIsReady(moduleHandler.BelongsTo()) {
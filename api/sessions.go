@@ -0,0 +1,415 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tevino/abool"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/log"
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/rng"
+)
+
+const (
+	sessionCookieName = "Portmaster-API-Token"
+	sessionCookieTTL  = 5 * time.Minute
+
+	sessionsKeyPrefix = apiKeysDatabaseName + ":sessions/"
+
+	// sessionLastUsedInterval defines how often the last used time of a
+	// session is updated and saved.
+	sessionLastUsedInterval = time.Minute
+)
+
+var (
+	sessions     = make(map[string]*Session) // Key is the hash.
+	sessionsLock sync.Mutex
+
+	// sessionsPersisted is whether sessions are saved to the database. It is
+	// set when the module starts.
+	sessionsPersisted = abool.New()
+
+	// ErrSessionNotFound is returned when a session does not exist.
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionInfo holds the public information of a session.
+type SessionInfo struct {
+	// ID is the public identifier of the session.
+	ID string
+	// Identity identifies the holder of the session.
+	Identity string
+	// Read is the read permission granted by the session.
+	Read Permission
+	// Write is the write permission granted by the session.
+	Write Permission
+	// Scopes optionally limits the session to endpoints whose path starts with
+	// one of the scopes.
+	Scopes []string `json:",omitempty"`
+	// Created is the time the session was created.
+	Created time.Time
+	// LastUsed is the time the session was last used, with a precision of
+	// about a minute.
+	LastUsed time.Time
+	// ValidUntil is the time the session expires if it is not used anymore.
+	ValidUntil time.Time
+	// Expires optionally is the time the session expires regardless of use.
+	Expires *time.Time `json:",omitempty"`
+}
+
+// Session is an authenticated session of a client, which is identified by a
+// cookie. Only a hash of the session key is stored.
+type Session struct {
+	record.Base
+	sync.Mutex
+
+	SessionInfo
+	Hash string
+
+	// dbLock serializes saving and deleting the session. The removed flag and
+	// the metadata of the session are guarded by it.
+	dbLock  sync.Mutex
+	removed bool
+}
+
+// Expired returns whether the session has expired.
+func (sess *Session) Expired() bool {
+	sess.Lock()
+	defer sess.Unlock()
+
+	now := time.Now()
+	return now.After(sess.ValidUntil) ||
+		(sess.Expires != nil && now.After(*sess.Expires))
+}
+
+// Refresh refreshes the validity of the session with the given TTL.
+// It returns whether the last used time was updated.
+func (sess *Session) Refresh(ttl time.Duration) (updated bool) {
+	sess.Lock()
+	defer sess.Unlock()
+
+	now := time.Now()
+	sess.ValidUntil = now.Add(ttl)
+	if now.Sub(sess.LastUsed) > sessionLastUsedInterval {
+		sess.LastUsed = now
+		return true
+	}
+	return false
+}
+
+// token returns the auth token of the session.
+func (sess *Session) token() *AuthToken {
+	sess.Lock()
+	defer sess.Unlock()
+
+	return &AuthToken{
+		Read:       sess.Read,
+		Write:      sess.Write,
+		ValidUntil: sess.Expires,
+		Identity:   sess.Identity,
+		Scopes:     sess.Scopes,
+	}
+}
+
+func loadSessions() error {
+	// Delete any previously persisted sessions if persistence is disabled.
+	if !persistSessions() {
+		n, err := apiKeysDB.Purge(module.Ctx, query.New(sessionsKeyPrefix))
+		if n > 0 {
+			log.Infof("api: deleted %d persisted sessions", n)
+		}
+		return err
+	}
+	sessionsPersisted.Set()
+
+	it, err := apiKeysDB.Query(query.New(sessionsKeyPrefix))
+	if err != nil {
+		return err
+	}
+
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	var expired []string
+	for r := range it.Next {
+		sess, err := ensureSession(r)
+		if err != nil {
+			log.Warningf("api: failed to load session %s: %s", r.Key(), err)
+			continue
+		}
+		if sess.Expired() {
+			expired = append(expired, sess.Key())
+			continue
+		}
+		sessions[sess.Hash] = sess
+	}
+	if it.Err() != nil {
+		return it.Err()
+	}
+
+	// Delete expired sessions after the query finished.
+	for _, key := range expired {
+		if err := apiKeysDB.Delete(key); err != nil {
+			log.Warningf("api: failed to delete expired session %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+func ensureSession(r record.Record) (*Session, error) {
+	// Unwrap record if it's wrapped.
+	if r.IsWrapped() {
+		sess := &Session{}
+		if err := record.Unwrap(r, sess); err != nil {
+			return nil, err
+		}
+		return sess, nil
+	}
+
+	// Or adjust type.
+	sess, ok := r.(*Session)
+	if !ok {
+		return nil, fmt.Errorf("record not of type *Session, but %T", r)
+	}
+	return sess, nil
+}
+
+// saveSession saves the session to the database, if sessions are persisted.
+func saveSession(sess *Session) {
+	if !sessionsPersisted.IsSet() {
+		return
+	}
+
+	module.StartWorker("save api session", func(_ context.Context) error {
+		return persistSession(sess)
+	})
+}
+
+// persistSession saves a snapshot of the session to the database, unless it
+// was removed in the meantime, as it would otherwise be restored.
+func persistSession(sess *Session) error {
+	sess.dbLock.Lock()
+	defer sess.dbLock.Unlock()
+
+	if sess.removed {
+		return nil
+	}
+
+	// Save a copy, so that the session can be used while it is being saved.
+	sess.Lock()
+	snapshot := &Session{
+		SessionInfo: sess.SessionInfo,
+		Hash:        sess.Hash,
+	}
+	sess.Unlock()
+	snapshot.SetKey(sess.Key())
+	if sess.Meta() != nil {
+		snapshot.SetMeta(sess.Meta().Duplicate())
+	}
+
+	if err := apiKeysDB.Put(snapshot); err != nil {
+		return err
+	}
+	sess.SetMeta(snapshot.Meta())
+	return nil
+}
+
+// removeSession removes the session and deletes it from the database, if
+// sessions are persisted. The sessions lock must be held.
+func removeSession(sess *Session) {
+	delete(sessions, sess.Hash)
+
+	sess.dbLock.Lock()
+	defer sess.dbLock.Unlock()
+
+	sess.removed = true
+	if sessionsPersisted.IsSet() {
+		if err := apiKeysDB.Delete(sess.Key()); err != nil {
+			log.Warningf("api: failed to delete session %s: %s", sess.ID, err)
+		}
+	}
+}
+
+func checkSessionCookie(r *http.Request) *AuthToken {
+	// Get session cookie from request.
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	// Check if session cookie is registered.
+	sessionsLock.Lock()
+	sess, ok := sessions[hashAPIKey(c.Value)]
+	sessionsLock.Unlock()
+	if !ok {
+		log.Tracer(r.Context()).Tracef("api: provided session cookie %s is unknown", c.Value)
+		return nil
+	}
+
+	// Check if session is still valid.
+	if sess.Expired() {
+		log.Tracer(r.Context()).Tracef("api: provided session cookie %s has expired", c.Value)
+		return nil
+	}
+
+	// Refresh session and return.
+	if sess.Refresh(sessionCookieTTL) {
+		saveSession(sess)
+	}
+	log.Tracer(r.Context()).Tracef("api: session cookie %s is valid, refreshing", c.Value)
+	return sess.token()
+}
+
+func createSession(w http.ResponseWriter, r *http.Request, token *AuthToken) (*AuthToken, error) {
+	// Generate new session key.
+	secret, err := rng.Bytes(32) // 256 bit
+	if err != nil {
+		return nil, err
+	}
+	sessionKey := base64.RawURLEncoding.EncodeToString(secret)
+
+	// Set token cookie in response.
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionKey,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// Create session with the permissions of the token, identified by the
+	// session, unless the authenticator already identified the holder.
+	now := time.Now()
+	sess := &Session{
+		SessionInfo: SessionInfo{
+			ID:       fingerprint(sessionKey),
+			Identity: token.Identity,
+			Read:     token.Read,
+			Write:    token.Write,
+			Scopes:   token.Scopes,
+			Created:  now,
+			LastUsed: now,
+			Expires:  token.ValidUntil,
+		},
+		Hash: hashAPIKey(sessionKey),
+	}
+	if sess.Identity == "" {
+		sess.Identity = "session:" + sess.ID
+	}
+	if maxLifetime := sessionMaxLifetime(); maxLifetime > 0 {
+		expires := now.Add(time.Duration(maxLifetime) * time.Hour)
+		if sess.Expires == nil || expires.Before(*sess.Expires) {
+			sess.Expires = &expires
+		}
+	}
+	sess.Refresh(sessionCookieTTL)
+	sess.SetKey(sessionsKeyPrefix + sess.ID)
+
+	// Save session.
+	sessionsLock.Lock()
+	sessions[sess.Hash] = sess
+	sessionsLock.Unlock()
+	saveSession(sess)
+	log.Tracer(r.Context()).Debug("api: issued session cookie")
+
+	return sess.token(), nil
+}
+
+func cleanSessions(_ context.Context, _ *modules.Task) error {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	for _, sess := range sessions {
+		if sess.Expired() {
+			removeSession(sess)
+		}
+	}
+
+	return nil
+}
+
+func deleteSession(sessionKey string) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	if sess, ok := sessions[hashAPIKey(sessionKey)]; ok {
+		removeSession(sess)
+	}
+}
+
+func registerSessionEndpoints() error {
+	if err := RegisterEndpoint(Endpoint{
+		Path:         "sessions",
+		Read:         PermitAdmin,
+		StructFunc:   listSessions,
+		ResponseType: []SessionInfo{},
+		Name:         "List Sessions",
+		Description:  "Returns all active sessions with their permissions and last use.",
+	}); err != nil {
+		return err
+	}
+
+	if err := RegisterEndpoint(Endpoint{
+		Path:  "sessions/{id:[0-9a-f]+}/revoke",
+		Write: PermitAdmin,
+		Parameters: []Parameter{{
+			Method:      http.MethodPost,
+			Field:       "id",
+			Type:        ParamTypeString,
+			Description: "ID of the session.",
+		}},
+		ActionFunc:  revokeSession,
+		Name:        "Revoke Session",
+		Description: "Deletes a session. The client has to authenticate again.",
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func listSessions(_ *Request) (i interface{}, err error) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, sess := range sessions {
+		if sess.Expired() {
+			continue
+		}
+		sess.Lock()
+		infos = append(infos, sess.SessionInfo)
+		sess.Unlock()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.Before(infos[j].Created)
+	})
+
+	return infos, nil
+}
+
+func revokeSession(ar *Request) (msg string, err error) {
+	sessionsLock.Lock()
+	defer sessionsLock.Unlock()
+
+	id := ar.Params.String("id")
+	for _, sess := range sessions {
+		if sess.ID == id {
+			removeSession(sess)
+			log.Infof("api: revoked session %s (%s)", sess.ID, sess.Identity)
+			return "Session revoked.", nil
+		}
+	}
+
+	return "", ErrorWithStatus(ErrSessionNotFound, http.StatusNotFound)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) { //nolint:paralleltest // Changes the session config.
+	// Override the experimental session options.
+	defaultPersistSessions, defaultSessionMaxLifetime := persistSessions, sessionMaxLifetime
+	persistSessions = func() bool { return true }
+	sessionMaxLifetime = func() int64 { return 1 }
+	defer func() {
+		persistSessions, sessionMaxLifetime = defaultPersistSessions, defaultSessionMaxLifetime
+		sessionsPersisted.UnSet()
+	}()
+	sessionsPersisted.Set()
+
+	// Create session.
+	w := httptest.NewRecorder()
+	token, err := createSession(w, httptest.NewRequest(http.MethodGet, apiV1Path, nil), &AuthToken{
+		Read:  PermitUser,
+		Write: PermitUser,
+	})
+	require.NoError(t, err)
+	cookies := w.Result().Cookies() //nolint:bodyclose // Recorder.
+	require.Len(t, cookies, 1)
+	sessionRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, apiV1Path, nil)
+		r.AddCookie(cookies[0])
+		return r
+	}
+
+	// The session is limited by the max lifetime.
+	require.NotNil(t, token.ValidUntil)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *token.ValidUntil, time.Minute)
+
+	// The session grants the permissions of the token.
+	sessionToken := checkSessionCookie(sessionRequest())
+	require.NotNil(t, sessionToken)
+	assert.Equal(t, PermitUser, sessionToken.Read)
+	assert.Equal(t, token.Identity, sessionToken.Identity)
+
+	// The session is listed.
+	i, err := listSessions(nil)
+	require.NoError(t, err)
+	infos, ok := i.([]SessionInfo)
+	require.True(t, ok)
+	var info *SessionInfo
+	for _, listed := range infos {
+		if "session:"+listed.ID == token.Identity {
+			listed := listed
+			info = &listed
+		}
+	}
+	require.NotNil(t, info)
+	assert.Equal(t, PermitUser, info.Write)
+
+	// The session is persisted with the hash of the session key only and is
+	// loaded again.
+	require.Eventually(t, func() bool {
+		exists, _ := apiKeysDB.Exists(sessionsKeyPrefix + info.ID)
+		return exists
+	}, time.Second, 10*time.Millisecond)
+	sessionsLock.Lock()
+	for hash, sess := range sessions {
+		if sess.ID == info.ID {
+			delete(sessions, hash)
+		}
+	}
+	sessionsLock.Unlock()
+	assert.Nil(t, checkSessionCookie(sessionRequest()))
+	require.NoError(t, loadSessions())
+	assert.NotNil(t, checkSessionCookie(sessionRequest()))
+
+	// Revoked sessions do not grant access anymore and are not saved again by
+	// pending updates.
+	var revoked *Session
+	sessionsLock.Lock()
+	for _, sess := range sessions {
+		if sess.ID == info.ID {
+			revoked = sess
+		}
+	}
+	sessionsLock.Unlock()
+	require.NotNil(t, revoked)
+
+	// Sessions may be used while they are saved.
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		for i := 0; i < 100; i++ {
+			revoked.Refresh(sessionCookieTTL)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, persistSession(revoked))
+	}
+	<-refreshed
+	exists, err := apiKeysDB.Exists(sessionsKeyPrefix + info.ID)
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	_, err = revokeSession(&Request{Params: ParameterValues{"id": info.ID}})
+	require.NoError(t, err)
+	assert.Nil(t, checkSessionCookie(sessionRequest()))
+	require.NoError(t, persistSession(revoked))
+	exists, err = apiKeysDB.Exists(sessionsKeyPrefix + info.ID)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	_, err = revokeSession(&Request{Params: ParameterValues{"id": info.ID}})
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	sess := &Session{
+		SessionInfo: SessionInfo{
+			LastUsed: now.Add(-time.Hour),
+		},
+	}
+
+	// The last use is only updated about every minute.
+	assert.True(t, sess.Refresh(sessionCookieTTL))
+	assert.False(t, sess.Refresh(sessionCookieTTL))
+	assert.False(t, sess.Expired())
+
+	// Sessions expire when unused.
+	sess.ValidUntil = now.Add(-time.Second)
+	assert.True(t, sess.Expired())
+
+	// Sessions expire at the max lifetime, even if used.
+	expires := now.Add(-time.Second)
+	sess.Expires = &expires
+	sess.Refresh(sessionCookieTTL)
+	assert.True(t, sess.Expired())
+}
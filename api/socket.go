@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/log"
+)
+
+type peerCredentialsContextKey struct{}
+
+// PeerCredentials holds the credentials of the process on the other end of a
+// unix socket connection to the API.
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+var (
+	// socketServer serves the API on the unix socket.
+	socketServer = &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnContext:       addPeerCredentials,
+	}
+	socketListener net.Listener
+)
+
+// GetPeerCredentials returns the credentials of the peer process, if the
+// request was made via the unix socket and the platform supports it.
+// Authenticators may use them to assign permissions.
+func GetPeerCredentials(r *http.Request) *PeerCredentials {
+	creds, _ := r.Context().Value(peerCredentialsContextKey{}).(*PeerCredentials)
+	return creds
+}
+
+// addPeerCredentials adds the peer credentials of unix socket connections to
+// the connection context.
+func addPeerCredentials(ctx context.Context, c net.Conn) context.Context {
+	unixConn, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	creds, err := getPeerCredentials(unixConn)
+	if err != nil {
+		log.Debugf("api: failed to get peer credentials of unix socket connection: %s", err)
+		return ctx
+	}
+	return context.WithValue(ctx, peerCredentialsContextKey{}, creds)
+}
+
+// listenUnixSocket listens on the unix socket at the given path with the given
+// file mode. A stale socket file is removed, but no other file.
+func listenUnixSocket(path string, mode fs.FileMode) (net.Listener, error) {
+	// Remove stale socket.
+	info, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to check for stale socket: %w", err)
+	case info.Mode().Type() != fs.ModeSocket:
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	default:
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	// Create the socket in a private directory and only move it into place
+	// after setting the file mode, so that it is never accessible with other
+	// permissions.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".api-socket-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private socket directory: %w", err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	tmpPath := filepath.Join(dir, "api.sock")
+
+	ln, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// The socket file is removed by the unixSocketListener instead.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("failed to set socket file mode: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("failed to move socket into place: %w", err)
+	}
+
+	return &unixSocketListener{
+		Listener: ln,
+		path:     path,
+	}, nil
+}
+
+// unixSocketListener is a unix socket listener that was moved to its path
+// after listening. It removes the socket file when closed.
+type unixSocketListener struct {
+	net.Listener
+
+	path      string
+	closeOnce sync.Once
+}
+
+// Addr returns the address of the socket.
+func (l *unixSocketListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// Close closes the listener and removes the socket file.
+func (l *unixSocketListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() {
+		_ = os.Remove(l.path)
+	})
+	return err
+}
+
+// parseSocketMode parses an octal file mode, such as "0660".
+func parseSocketMode(s string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o0777 {
+		return 0, fmt.Errorf("invalid file mode: %s", s)
+	}
+	return fs.FileMode(mode), nil
+}
+
+func startSocketServer() error {
+	path := socketPathConfig()
+	if path == "" {
+		return nil
+	}
+	mode, err := parseSocketMode(socketModeConfig())
+	if err != nil {
+		return err
+	}
+
+	socketListener, err = listenUnixSocket(path, mode)
+	if err != nil {
+		return fmt.Errorf("failed to listen on unix socket: %w", err)
+	}
+	socketServer.Handler = server.Handler
+
+	module.StartWorker("unix socket server", serveSocket)
+	return nil
+}
+
+func stopSocketServer() error {
+	if socketListener == nil {
+		return nil
+	}
+
+	return socketServer.Shutdown(context.Background())
+}
+
+// serveSocket serves the API on the unix socket.
+func serveSocket(_ context.Context) error {
+	log.Infof("api: starting to listen on unix socket %s", socketListener.Addr())
+
+	err := socketServer.Serve(socketListener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return fmt.Errorf("unix socket endpoint failed: %w", err)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package api
+
+import (
+	"errors"
+	"net"
+)
+
+func getPeerCredentials(_ *net.UnixConn) (*PeerCredentials, error) {
+	return nil, errors.New("peer credentials are not supported on this platform")
+}
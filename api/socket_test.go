@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnixSocket(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	path := filepath.Join(t.TempDir(), "api.sock")
+	ln, err := listenUnixSocket(path, 0o0600)
+	require.NoError(t, err)
+
+	// Check file mode.
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o0600), info.Mode().Perm())
+
+	// Serve peer credentials.
+	srv := &http.Server{
+		ReadHeaderTimeout: socketServer.ReadHeaderTimeout,
+		ConnContext:       addPeerCredentials,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			creds := GetPeerCredentials(r)
+			if creds == nil {
+				http.Error(w, "no peer credentials", http.StatusInternalServerError)
+				return
+			}
+			_, _ = fmt.Fprintf(w, "%d %d %d", creds.PID, creds.UID, creds.GID)
+		}),
+	}
+	go func() {
+		_ = srv.Serve(ln)
+	}()
+	defer func() { _ = srv.Close() }()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	resp, err := client.Get("http://unix/")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d %d %d", os.Getpid(), os.Getuid(), os.Getgid()), string(body))
+
+	// The socket file is removed when closing.
+	_ = srv.Close()
+	_, err = os.Lstat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Stale sockets are replaced.
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+	ln, err = listenUnixSocket(path, 0o0660)
+	require.NoError(t, err)
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o0660), info.Mode().Perm())
+	_ = ln.Close()
+
+	// Other files are never removed.
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o0600))
+	_, err = listenUnixSocket(path, 0o0600)
+	assert.Error(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	// No private directories are left behind.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	_, err = parseSocketMode("0999")
+	assert.Error(t, err)
+}
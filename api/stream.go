@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+)
+
+// Stream MIME Types.
+const (
+	// MimeTypeNDJSON is the content type of newline delimited JSON streams.
+	MimeTypeNDJSON string = "application/x-ndjson"
+
+	// MimeTypeDSDStream is the content type of DSD streams. Every value is
+	// prefixed with its varint encoded length and includes its DSD format
+	// identifier.
+	MimeTypeDSDStream string = "application/x-dsd-stream"
+
+	// streamErrorTrailer is the trailer that holds the error that ended a
+	// stream, after it was started.
+	streamErrorTrailer = "X-Stream-Error"
+)
+
+// StreamWriter encodes values and sends them to the client right away.
+type StreamWriter struct {
+	w       http.ResponseWriter
+	r       *http.Request
+	flusher http.Flusher
+
+	// format is the DSD format of the stream. JSON streams are sent as NDJSON.
+	format  uint8
+	started bool
+}
+
+// newStreamWriter returns a new stream writer with the format requested by
+// the client.
+func newStreamWriter(w http.ResponseWriter, r *http.Request) (*StreamWriter, error) {
+	format := uint8(dsd.JSON)
+	if accept := r.Header.Get("Accept"); !strings.Contains(accept, MimeTypeNDJSON) {
+		format = dsd.FormatFromAccept(accept)
+		if format == dsd.AUTO {
+			return nil, ErrorWithStatus(dsd.ErrIncompatibleFormat, http.StatusNotAcceptable)
+		}
+	}
+
+	flusher, _ := w.(http.Flusher)
+	return &StreamWriter{
+		w:       w,
+		r:       r,
+		flusher: flusher,
+		format:  format,
+	}, nil
+}
+
+// Write encodes the given value and sends it to the client. It returns an
+// error if the client went away.
+func (sw *StreamWriter) Write(v interface{}) error {
+	// Stop if the client went away.
+	if err := sw.r.Context().Err(); err != nil {
+		return err
+	}
+
+	// Encode value.
+	var data []byte
+	var err error
+	if sw.format == dsd.JSON {
+		data, err = json.Marshal(v)
+		data = append(data, '\n')
+	} else {
+		data, err = dsd.Dump(v, sw.format)
+		data = append(varint.Pack64(uint64(len(data))), data...)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Start stream with the first value.
+	if !sw.started {
+		sw.start()
+	}
+
+	if _, err := sw.w.Write(data); err != nil {
+		return err
+	}
+	if sw.flusher != nil {
+		sw.flusher.Flush()
+	}
+	return nil
+}
+
+func (sw *StreamWriter) start() {
+	if sw.format == dsd.JSON {
+		sw.w.Header().Set("Content-Type", MimeTypeNDJSON)
+	} else {
+		sw.w.Header().Set("Content-Type", MimeTypeDSDStream)
+	}
+	sw.w.Header().Set("Trailer", streamErrorTrailer)
+	sw.w.WriteHeader(http.StatusOK)
+	sw.started = true
+}
+
+// finish ends the stream with the given error of the stream function.
+func (sw *StreamWriter) finish(err error) {
+	switch {
+	case err == nil && !sw.started:
+		// Nothing was streamed.
+		sw.w.WriteHeader(http.StatusNoContent)
+	case err == nil:
+		// All good.
+	case !sw.started:
+		// Nothing was sent yet, so respond with a proper error.
+		var statusProvider HTTPStatusProvider
+		if errors.As(err, &statusProvider) {
+			http.Error(sw.w, err.Error(), statusProvider.HTTPStatus())
+		} else {
+			http.Error(sw.w, err.Error(), http.StatusInternalServerError)
+		}
+	case sw.r.Context().Err() != nil:
+		log.Tracer(sw.r.Context()).Debugf("api: stream canceled by client: %s", err)
+	default:
+		// The status was already sent, report the error in the trailer.
+		log.Tracer(sw.r.Context()).Warningf("api: stream failed: %s", err)
+		sw.w.Header().Set(streamErrorTrailer, err.Error())
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/safing/portbase/container"
+	"github.com/safing/portbase/formats/dsd"
+)
+
+type streamTestValue struct {
+	N int
+}
+
+func TestStreamEndpoints(t *testing.T) {
+	t.Parallel()
+
+	canceled := make(chan error, 1)
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/stream",
+		Read: PermitAnyone,
+		StreamFunc: func(ar *Request, w *StreamWriter) error {
+			for i := 1; i <= 3; i++ {
+				if err := w.Write(&streamTestValue{N: i}); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/stream-err",
+		Read: PermitAnyone,
+		StreamFunc: func(ar *Request, w *StreamWriter) error {
+			if ar.URL.Query().Get("started") == "" {
+				return ErrorWithStatus(errors.New(failedMsg), http.StatusNotFound)
+			}
+			if err := w.Write(&streamTestValue{N: 1}); err != nil {
+				return err
+			}
+			return errors.New(failedMsg)
+		},
+	}))
+	require.NoError(t, RegisterEndpoint(Endpoint{
+		Path: "test/stream-endless",
+		Read: PermitAnyone,
+		StreamFunc: func(ar *Request, w *StreamWriter) error {
+			for i := 0; ; i++ {
+				if err := w.Write(&streamTestValue{N: i}); err != nil {
+					canceled <- err
+					return err
+				}
+				time.Sleep(time.Millisecond)
+			}
+		},
+	}))
+
+	srv := httptest.NewServer(&mainHandler{mux: mainMux})
+	defer srv.Close()
+
+	get := func(ctx context.Context, path, accept string) *http.Response {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+apiV1Path+path, nil)
+		require.NoError(t, err)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// NDJSON.
+	resp := get(context.Background(), "test/stream", "")
+	assert.Equal(t, MimeTypeNDJSON, resp.Header.Get("Content-Type"))
+	scanner := bufio.NewScanner(resp.Body)
+	var n int
+	for scanner.Scan() {
+		n++
+		v := &streamTestValue{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), v))
+		assert.Equal(t, n, v.N)
+	}
+	assert.Equal(t, 3, n)
+	_ = resp.Body.Close()
+
+	// DSD.
+	resp = get(context.Background(), "test/stream", "application/msgpack")
+	assert.Equal(t, MimeTypeDSDStream, resp.Header.Get("Content-Type"))
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	c := container.New(data)
+	for n = 1; c.HoldsData(); n++ {
+		block, err := c.GetNextBlock()
+		require.NoError(t, err)
+		assert.Equal(t, byte(dsd.MsgPack), block[0])
+		v := &streamTestValue{}
+		_, err = dsd.Load(block, v)
+		require.NoError(t, err)
+		assert.Equal(t, n, v.N)
+	}
+	assert.Equal(t, 4, n)
+
+	// Unsupported format.
+	resp = get(context.Background(), "test/stream", "text/html")
+	assert.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	// Errors before the stream started.
+	resp = get(context.Background(), "test/stream-err", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	_ = resp.Body.Close()
+
+	// Errors after the stream started.
+	resp = get(context.Background(), "test/stream-err?started=true", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, failedMsg, resp.Trailer.Get(streamErrorTrailer))
+
+	// Cancellation.
+	ctx, cancel := context.WithCancel(context.Background())
+	resp = get(ctx, "test/stream-endless", "")
+	_, err = resp.Body.Read(make([]byte, 1))
+	require.NoError(t, err)
+	cancel()
+	_ = resp.Body.Close()
+	select {
+	case err := <-canceled:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream was not canceled")
+	}
+}
@@ -0,0 +1,363 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/safing/portbase/dataroot"
+)
+
+const (
+	tlsDirName = "api-tls"
+
+	tlsCACertFile     = "ca.crt"
+	tlsCAKeyFile      = "ca.key"
+	tlsServerCertFile = "server.crt"
+	tlsServerKeyFile  = "server.key"
+
+	tlsCAValidity     = 10 * 365 * 24 * time.Hour
+	tlsServerValidity = 365 * 24 * time.Hour
+	// tlsServerRenewal defines how long before expiry the server certificate is
+	// renewed.
+	tlsServerRenewal = 30 * 24 * time.Hour
+
+	// Client certificates carry their permissions as organizational units.
+	clientCertReadPrefix  = "read="
+	clientCertWritePrefix = "write="
+)
+
+// certAuthority is the self-signed certificate authority of the API.
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// getTLSDir returns the directory for the TLS certificates in the data root.
+func getTLSDir() (string, error) {
+	root := dataroot.Root()
+	if root == nil {
+		return "", errors.New("data root is not set")
+	}
+
+	dir := root.ChildDir(tlsDirName, 0o0700)
+	return dir.Path, dir.Ensure()
+}
+
+// newTLSConfig returns the TLS config for the API server with a server
+// certificate for the given hosts. Client certificates are requested and
+// verified, but not required.
+func newTLSConfig(dir string, hosts []string) (*tls.Config, error) {
+	ca, err := loadOrCreateCA(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate authority: %w", err)
+	}
+	serverCert, err := loadOrCreateServerCertificate(dir, ca, hosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}, nil
+}
+
+// tlsHosts returns the hosts the server certificate is valid for.
+func tlsHosts(listenAddress string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+
+	host, _, err := net.SplitHostPort(listenAddress)
+	if err == nil && host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+func loadOrCreateCA(dir string) (*certAuthority, error) {
+	certPath := filepath.Join(dir, tlsCACertFile)
+	keyPath := filepath.Join(dir, tlsCAKeyFile)
+
+	// Load existing certificate authority.
+	cert, key, err := loadCertificate(certPath, keyPath)
+	if err == nil {
+		return &certAuthority{cert: cert, key: key}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Create a new certificate authority.
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newCertificateTemplate("Portmaster API CA", tlsCAValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	cert, err = createCertificate(template, template, key, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveCertificate(certPath, keyPath, cert, key); err != nil {
+		return nil, err
+	}
+
+	return &certAuthority{cert: cert, key: key}, nil
+}
+
+func loadOrCreateServerCertificate(dir string, ca *certAuthority, hosts []string) (*tls.Certificate, error) {
+	certPath := filepath.Join(dir, tlsServerCertFile)
+	keyPath := filepath.Join(dir, tlsServerKeyFile)
+
+	// Load existing server certificate and check if it is still usable.
+	cert, key, err := loadCertificate(certPath, keyPath)
+	switch {
+	case err == nil:
+		if serverCertificateUsable(cert, ca, hosts) {
+			return &tls.Certificate{
+				Certificate: [][]byte{cert.Raw, ca.cert.Raw},
+				PrivateKey:  key,
+				Leaf:        cert,
+			}, nil
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+
+	// Create a new server certificate.
+	key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newCertificateTemplate("Portmaster API", tlsServerValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	cert, err = createCertificate(template, ca.cert, key, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveCertificate(certPath, keyPath, cert, key); err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        cert,
+	}, nil
+}
+
+// serverCertificateUsable returns whether the server certificate is signed by
+// the certificate authority, valid for all hosts and not about to expire.
+func serverCertificateUsable(cert *x509.Certificate, ca *certAuthority, hosts []string) bool {
+	if time.Now().Add(tlsServerRenewal).After(cert.NotAfter) {
+		return false
+	}
+	if cert.CheckSignatureFrom(ca.cert) != nil {
+		return false
+	}
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// IssueClientCertificate issues a client certificate that grants the given
+// permissions when authenticating with ClientCertificateAuthenticator. The
+// certificate and its key are returned PEM encoded.
+func IssueClientCertificate(name string, read, write Permission, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	dir, err := getTLSDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := loadOrCreateCA(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load certificate authority: %w", err)
+	}
+
+	return issueClientCertificate(ca, name, read, write, validFor)
+}
+
+func issueClientCertificate(ca *certAuthority, name string, read, write Permission, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	// Only permissions that can be assigned to third parties are allowed.
+	for _, p := range []Permission{read, write} {
+		if p < PermitAnyone || p > PermitAdmin {
+			return nil, nil, fmt.Errorf("invalid permission for client certificate: %s", p)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := newCertificateTemplate(name, validFor)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.Subject.OrganizationalUnit = []string{
+		clientCertReadPrefix + strings.ToLower(read.Role()),
+		clientCertWritePrefix + strings.ToLower(write.Role()),
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	cert, err := createCertificate(template, ca.cert, key, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+// ClientCertificateAuthenticator is an AuthenticatorFunc that authenticates
+// requests using client certificates issued with IssueClientCertificate.
+// It requires TLS to be enabled for the API. Requests without a verified
+// client certificate are not authenticated.
+func ClientCertificateAuthenticator(r *http.Request, _ *http.Server) (*AuthToken, error) {
+	// Client certificates are verified by the TLS server.
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	token := &AuthToken{
+		Read:       PermitAnyone,
+		Write:      PermitAnyone,
+		ValidUntil: &cert.NotAfter,
+		Identity:   "cert:" + hex.EncodeToString(cert.SerialNumber.Bytes()),
+	}
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		var err error
+		switch {
+		case strings.HasPrefix(ou, clientCertReadPrefix):
+			token.Read, err = parseAPIPermission(strings.TrimPrefix(ou, clientCertReadPrefix))
+		case strings.HasPrefix(ou, clientCertWritePrefix):
+			token.Write, err = parseAPIPermission(strings.TrimPrefix(ou, clientCertWritePrefix))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%winvalid client certificate: %s", ErrAPIAccessDeniedMessage, err)
+		}
+	}
+
+	return token, nil
+}
+
+func newCertificateTemplate(commonName string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(validFor),
+	}, nil
+}
+
+func createCertificate(template, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func loadCertificate(certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, err := readPEM(certPath, "CERTIFICATE")
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, err := readPEM(keyPath, "EC PRIVATE KEY")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", certPath, err)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %w", keyPath, err)
+	}
+
+	return cert, key, nil
+}
+
+func saveCertificate(certPath, keyPath string, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o0644) //nolint:gosec // Certificate is public.
+}
+
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("failed to parse %s: no %s found", path, blockType)
+	}
+	return block, nil
+}
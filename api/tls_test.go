@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	hosts := tlsHosts("127.0.0.1:8817")
+
+	// Create the server TLS config and check that it is reused.
+	serverConfig, err := newTLSConfig(dir, hosts)
+	require.NoError(t, err)
+	reloadedConfig, err := newTLSConfig(dir, hosts)
+	require.NoError(t, err)
+	assert.Equal(t, serverConfig.Certificates[0].Certificate, reloadedConfig.Certificates[0].Certificate)
+
+	// Issue a client certificate.
+	ca, err := loadOrCreateCA(dir)
+	require.NoError(t, err)
+	certPEM, keyPEM, err := issueClientCertificate(ca, "test client", PermitAdmin, PermitUser, time.Hour)
+	require.NoError(t, err)
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+	_, _, err = issueClientCertificate(ca, "test client", PermitSelf, PermitSelf, time.Hour)
+	assert.Error(t, err)
+
+	// Start a TLS server that reports the authenticated permissions.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := ClientCertificateAuthenticator(r, nil)
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case token == nil:
+			http.Error(w, "no token", http.StatusUnauthorized)
+		default:
+			_, _ = fmt.Fprintf(w, "%s %s", token.Read.Role(), token.Write.Role())
+		}
+	}))
+	srv.TLS = serverConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.cert)
+	request := func(certs ...tls.Certificate) (int, string) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					MinVersion:   tls.VersionTLS12,
+					RootCAs:      rootCAs,
+					Certificates: certs,
+				},
+			},
+		}
+		resp, err := client.Get(srv.URL)
+		require.NoError(t, err)
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := request(clientCert)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Admin User", body)
+
+	status, _ = request()
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
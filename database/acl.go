@@ -0,0 +1,167 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/safing/portbase/database/iterator"
+	"github.com/safing/portbase/database/record"
+)
+
+// permissionRule defines the API permission levels required for all records
+// with a key starting with the key prefix.
+type permissionRule struct {
+	keyPrefix string
+	read      int8
+	write     int8
+}
+
+var (
+	permissionRules     []*permissionRule
+	permissionRulesLock sync.RWMutex
+)
+
+// RequirePermissions sets the API permission levels that are required to read
+// and write records with a key (including the database name) starting with
+// the given prefix. The levels correspond to the values of api.Permission. If
+// multiple prefixes match a key, the longest one is used. Permissions set on
+// the record itself take precedence. Setting both levels to zero removes the
+// rule for the given prefix.
+// Permission levels are only enforced for interfaces with ReadPermission or
+// WritePermission set in their options.
+func RequirePermissions(keyPrefix string, read, write int8) {
+	permissionRulesLock.Lock()
+	defer permissionRulesLock.Unlock()
+
+	// Remove existing rule.
+	for idx, rule := range permissionRules {
+		if rule.keyPrefix == keyPrefix {
+			permissionRules = append(permissionRules[:idx], permissionRules[idx+1:]...)
+			break
+		}
+	}
+
+	// Add new rule.
+	if read != 0 || write != 0 {
+		permissionRules = append(permissionRules, &permissionRule{
+			keyPrefix: keyPrefix,
+			read:      read,
+			write:     write,
+		})
+	}
+
+	// Sort by decreasing prefix length, so that the first match is the longest.
+	sort.SliceStable(permissionRules, func(i, j int) bool {
+		return len(permissionRules[i].keyPrefix) > len(permissionRules[j].keyPrefix)
+	})
+}
+
+// getRequiredPermissions returns the API permission levels required to read
+// and write the record with the given key and metadata. The metadata may be
+// nil for records that do not exist yet.
+func getRequiredPermissions(key string, m *record.Meta) (read, write int8) {
+	// Get permissions from the record itself.
+	if m != nil {
+		read, write = m.RequiredPermissions()
+		if read != 0 || write != 0 {
+			return read, write
+		}
+	}
+
+	// Get permissions from the key prefix rules.
+	permissionRulesLock.RLock()
+	defer permissionRulesLock.RUnlock()
+
+	for _, rule := range permissionRules {
+		if strings.HasPrefix(key, rule.keyPrefix) {
+			return rule.read, rule.write
+		}
+	}
+
+	return 0, 0
+}
+
+// hasPermissionLevels returns whether the options specify API permission
+// levels that must be checked.
+func (o *Options) hasPermissionLevels() bool {
+	return o.ReadPermission != 0 || o.WritePermission != 0
+}
+
+// checkReadPermission checks if the interface options permit reading the
+// record with the given key and metadata.
+func (o *Options) checkReadPermission(key string, m *record.Meta) bool {
+	if !o.hasPermissionLevels() {
+		return true
+	}
+
+	read, _ := getRequiredPermissions(key, m)
+	return o.ReadPermission >= read
+}
+
+// checkWritePermission checks if the interface options permit writing the
+// record with the given key and metadata. The metadata may be nil for records
+// that do not exist yet.
+func (o *Options) checkWritePermission(key string, m *record.Meta) bool {
+	if !o.hasPermissionLevels() {
+		return true
+	}
+
+	read, write := getRequiredPermissions(key, m)
+	// Writing implicitly also includes reading.
+	return o.WritePermission >= write && o.WritePermission >= read
+}
+
+// inheritRequiredPermissions copies the required permissions from the given
+// metadata of an existing record to the given record, if the interface options
+// specify permission levels and the record does not require any permissions
+// itself. This keeps records from losing their required permissions when they
+// are overwritten by an API client. The record must be locked.
+func (o *Options) inheritRequiredPermissions(r record.Record, existing *record.Meta) {
+	if !o.hasPermissionLevels() || existing == nil || r.Meta() == nil {
+		return
+	}
+
+	read, write := r.Meta().RequiredPermissions()
+	if read == 0 && write == 0 {
+		r.Meta().RequirePermissions(existing.RequiredPermissions())
+	}
+}
+
+// filterByReadPermission returns an iterator that only passes through the
+// records of the given iterator that the interface options permit reading.
+func (o *Options) filterByReadPermission(dbIt *iterator.Iterator) *iterator.Iterator {
+	it := iterator.New()
+	go func() {
+		for {
+			select {
+			case r, ok := <-dbIt.Next:
+				if !ok {
+					it.Finish(dbIt.Err())
+					return
+				}
+
+				r.Lock()
+				permitted := o.checkReadPermission(r.Key(), r.Meta())
+				r.Unlock()
+				if !permitted {
+					continue
+				}
+
+				select {
+				case it.Next <- r:
+				case <-it.Done:
+					dbIt.Cancel()
+					it.Finish(nil)
+					return
+				}
+			case <-it.Done:
+				dbIt.Cancel()
+				it.Finish(nil)
+				return
+			}
+		}
+	}()
+
+	return it
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	q "github.com/safing/portbase/database/query"
+)
+
+func testPermissionLevels(t *testing.T) { //nolint:thelper
+	t.Run("TestPermissionLevels", func(t *testing.T) {
+		dbName := "testing-acl"
+		_, err := Register(&Database{
+			Name:        dbName,
+			Description: "Unit Test Database for Permission Levels",
+			StorageType: "bbolt",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Require admin (3) by default, but user (2) for reading public records.
+		RequirePermissions(dbName+":", 3, 3)
+		RequirePermissions(dbName+":public/", 2, 3)
+		defer RequirePermissions(dbName+":", 0, 0)
+		defer RequirePermissions(dbName+":public/", 0, 0)
+
+		internal := NewInterface(&Options{
+			Local:    true,
+			Internal: true,
+		})
+		user := NewInterface(&Options{
+			ReadPermission:  2,
+			WritePermission: 2,
+		})
+		admin := NewInterface(&Options{
+			ReadPermission:  3,
+			WritePermission: 3,
+		})
+
+		// Prepare records.
+		for _, key := range []string{"public/A", "private/B"} {
+			if err := internal.Put(NewExample(dbName+":"+key, key, 1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		labeled := NewExample(dbName+":private/C", "C", 1)
+		labeled.UpdateMeta()
+		labeled.Meta().RequirePermissions(2, 2)
+		if err := internal.Put(labeled); err != nil {
+			t.Fatal(err)
+		}
+
+		// Check reading.
+		if _, err := user.Get(dbName + ":public/A"); err != nil {
+			t.Fatalf("user should be able to read public record: %s", err)
+		}
+		if _, err := user.Get(dbName + ":private/B"); !errors.Is(err, ErrPermissionDenied) {
+			t.Fatalf("user should not be able to read private record: %v", err)
+		}
+		if _, err := user.Get(dbName + ":private/C"); err != nil {
+			t.Fatalf("user should be able to read labeled record: %s", err)
+		}
+		if _, err := admin.Get(dbName + ":private/B"); err != nil {
+			t.Fatalf("admin should be able to read private record: %s", err)
+		}
+		if cnt := countRecords(t, user, q.New(dbName)); cnt != 2 {
+			t.Fatalf("user should see 2 records, but saw %d", cnt)
+		}
+		if cnt := countRecords(t, admin, q.New(dbName)); cnt != 3 {
+			t.Fatalf("admin should see 3 records, but saw %d", cnt)
+		}
+
+		// Check writing.
+		if err := user.Put(NewExample(dbName+":public/A", "A", 2)); !errors.Is(err, ErrPermissionDenied) {
+			t.Fatalf("user should not be able to write public record: %v", err)
+		}
+		if err := user.Put(NewExample(dbName+":public/D", "D", 2)); !errors.Is(err, ErrPermissionDenied) {
+			t.Fatalf("user should not be able to create public record: %v", err)
+		}
+		if err := user.Delete(dbName + ":private/B"); !errors.Is(err, ErrPermissionDenied) {
+			t.Fatalf("user should not be able to delete private record: %v", err)
+		}
+		if err := user.Put(NewExample(dbName+":private/C", "C", 2)); err != nil {
+			t.Fatalf("user should be able to write labeled record: %s", err)
+		}
+
+		// Check that the label was kept when overwriting the record.
+		if _, err := user.Get(dbName + ":private/C"); err != nil {
+			t.Fatalf("user should still be able to read labeled record: %s", err)
+		}
+		if err := admin.Put(NewExample(dbName+":private/B", "B", 2)); err != nil {
+			t.Fatalf("admin should be able to write private record: %s", err)
+		}
+	})
+}
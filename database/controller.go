@@ -278,7 +278,9 @@ func (c *Controller) notifySubscribers(r record.Record) {
 	defer c.subscriptionLock.RUnlock()
 
 	for _, sub := range c.subscriptions {
-		if r.Meta().CheckPermission(sub.local, sub.internal) && sub.q.Matches(r) {
+		if r.Meta().CheckPermission(sub.local, sub.internal) &&
+			sub.options.checkReadPermission(r.Key(), r.Meta()) &&
+			sub.q.Matches(r) {
 			select {
 			case sub.Feed <- r:
 			default:
@@ -18,6 +18,7 @@
 	_ "github.com/safing/portbase/database/storage/bbolt"
 	_ "github.com/safing/portbase/database/storage/fstree"
 	_ "github.com/safing/portbase/database/storage/hashmap"
+	_ "github.com/safing/portbase/database/storage/sqlite"
 )
 
 func TestMain(m *testing.M) {
@@ -182,10 +183,15 @@ func testDatabase(t *testing.T, storageType string, shadowDelete bool) { //nolin
 				t.Errorf("A should be deleted and purged, err=%s", err)
 			}
 			B1, err := dbController.storage.Get("B")
-			if err != nil {
+			switch {
+			case !shadowDelete:
+				// Expired entries are immediately purged without shadow delete.
+				if !errors.Is(err, storage.ErrNotFound) {
+					t.Errorf("B should be purged, err=%s", err)
+				}
+			case err != nil:
 				t.Fatalf("should exist: %s, original meta: %+v", err, B.Meta())
-			}
-			if B1.Meta().Deleted == 0 {
+			case B1.Meta().Deleted == 0:
 				t.Errorf("B should be deleted")
 			}
 
@@ -254,10 +260,16 @@ func TestDatabaseSystem(t *testing.T) { //nolint:tparallel
 		testDatabase(t, "bbolt", shadowDelete)
 		testDatabase(t, "hashmap", shadowDelete)
 		testDatabase(t, "fstree", shadowDelete)
+		testDatabase(t, "hashmap-persistent", shadowDelete)
+		testDatabase(t, "sqlite", shadowDelete)
 		// testDatabase(t, "badger", shadowDelete)
 		// TODO: Fix badger tests
 	}
 
+	testInterfaceOverlay(t)
+	testPermissionLevels(t)
+	testCacheStats(t)
+
 	err := MaintainRecordStates(context.TODO())
 	if err != nil {
 		t.Fatal(err)
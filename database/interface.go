@@ -5,6 +5,7 @@
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluele/gcache"
@@ -28,10 +29,22 @@ type Interface struct {
 	writeCache        map[string]record.Record
 	writeCacheLock    sync.Mutex
 	triggerCacheWrite chan struct{}
+
+	overlay     map[string]record.Record
+	overlayLock sync.Mutex
+
+	cacheEvictions     atomic.Uint64
+	cacheFlushedWrites atomic.Uint64
 }
 
 // Options holds options that may be set for an Interface instance.
 type Options struct {
+	// Name optionally defines a name for the interface. If the interface uses a
+	// cache, its statistics are tracked under this name and exposed as metrics.
+	// The name must be unique and short, eg. the name of the module using it.
+	// Only the first interface with a name is tracked.
+	Name string
+
 	// Local specifies if the interface is used by an actor on the local device.
 	// Setting both the Local and Internal flags will bring performance
 	// improvements because less checks are needed.
@@ -74,6 +87,26 @@ type Options struct {
 	// Please note that this means that other interfaces will not be able to
 	// guarantee to serve the latest record if records are written this way.
 	DelayCachedWrites string
+
+	// Overlay defines that all writes through this interface are kept in an
+	// in-memory overlay instead of being written to the database. Reads and
+	// queries through this interface see the changes in the overlay, while
+	// other interfaces do not. This is useful for trying out changes, eg. for
+	// dry runs of migrations. Hooks and subscriptions are not triggered for
+	// writes to the overlay. The overlay cannot be used together with a cache.
+	// Please note that records returned by some storages, such as the hashmap
+	// storage, are shared instances. Changing them in place will still change
+	// the stored data, even if they are only written to the overlay.
+	Overlay bool
+
+	// ReadPermission and WritePermission define the API permission levels of
+	// the actor using this interface. The levels correspond to the values of
+	// api.Permission. If any of them is set, records that require a higher
+	// permission level, either by themselves or by their key prefix, are not
+	// accessible through this interface. See RequirePermissions for details.
+	// Purging is not possible with permission levels set.
+	ReadPermission  int8
+	WritePermission int8
 }
 
 // Apply applies options to the record metadata.
@@ -95,7 +128,7 @@ func (o *Options) Apply(r record.Record) {
 // HasAllPermissions returns whether the options specify the highest possible
 // permissions for operations.
 func (o *Options) HasAllPermissions() bool {
-	return o.Local && o.Internal
+	return o.Local && o.Internal && !o.hasPermissionLevels()
 }
 
 // hasAccessPermission checks if the interface options permit access to the
@@ -111,7 +144,22 @@ func (o *Options) hasAccessPermission(r record.Record) bool {
 	defer r.Unlock()
 
 	// Check permissions against record.
-	return r.Meta().CheckPermission(o.Local, o.Internal)
+	return r.Meta().CheckPermission(o.Local, o.Internal) &&
+		o.checkReadPermission(r.Key(), r.Meta())
+}
+
+// hasWritePermission checks if the interface options permit writing the given
+// record, locking the record for accessing it's attributes.
+func (o *Options) hasWritePermission(r record.Record) bool {
+	// Check if any permission levels need to be checked.
+	if !o.hasPermissionLevels() {
+		return true
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	return o.checkWritePermission(r.Key(), r.Meta())
 }
 
 // NewInterface returns a new Interface to the database.
@@ -123,14 +171,21 @@ func NewInterface(opts *Options) *Interface {
 	newIface := &Interface{
 		options: opts,
 	}
-	if opts.CacheSize > 0 {
+	if opts.Overlay {
+		newIface.overlay = make(map[string]record.Record)
+	} else if opts.CacheSize > 0 {
 		cacheBuilder := gcache.New(opts.CacheSize).ARC()
+		cacheBuilder.EvictedFunc(newIface.cacheEvictHandler)
 		if opts.DelayCachedWrites != "" {
-			cacheBuilder.EvictedFunc(newIface.cacheEvictHandler)
 			newIface.writeCache = make(map[string]record.Record, opts.CacheSize/2)
 			newIface.triggerCacheWrite = make(chan struct{})
 		}
 		newIface.cache = cacheBuilder.Build()
+
+		// Register named interfaces for cache statistics.
+		if opts.Name != "" {
+			registerCachedInterface(newIface)
+		}
 	}
 	return newIface
 }
@@ -171,11 +226,28 @@ func (i *Interface) getRecord(dbName string, dbKey string, mustBeWriteable bool)
 		return nil, db, ErrReadOnly
 	}
 
+	r, found := i.checkOverlay(dbName + ":" + dbKey)
+	if found {
+		if r == nil {
+			return nil, db, ErrNotFound
+		}
+		if !i.options.hasAccessPermission(r) {
+			return nil, db, ErrPermissionDenied
+		}
+		if mustBeWriteable && !i.options.hasWritePermission(r) {
+			return nil, db, ErrPermissionDenied
+		}
+		return r, db, nil
+	}
+
 	r = i.checkCache(dbName + ":" + dbKey)
 	if r != nil {
 		if !i.options.hasAccessPermission(r) {
 			return nil, db, ErrPermissionDenied
 		}
+		if mustBeWriteable && !i.options.hasWritePermission(r) {
+			return nil, db, ErrPermissionDenied
+		}
 		return r, db, nil
 	}
 
@@ -187,6 +259,9 @@ func (i *Interface) getRecord(dbName string, dbKey string, mustBeWriteable bool)
 	if !i.options.hasAccessPermission(r) {
 		return nil, db, ErrPermissionDenied
 	}
+	if mustBeWriteable && !i.options.hasWritePermission(r) {
+		return nil, db, ErrPermissionDenied
+	}
 
 	r.Lock()
 	ttl := r.Meta().GetRelativeExpiry()
@@ -215,7 +290,18 @@ func (i *Interface) getMeta(dbName string, dbKey string, mustBeWriteable bool) (
 		return nil, db, ErrReadOnly
 	}
 
-	r := i.checkCache(dbName + ":" + dbKey)
+	r, found := i.checkOverlay(dbName + ":" + dbKey)
+	if found {
+		if r == nil {
+			return nil, db, ErrNotFound
+		}
+		if !i.options.hasAccessPermission(r) {
+			return nil, db, ErrPermissionDenied
+		}
+		return r.Meta(), db, nil
+	}
+
+	r = i.checkCache(dbName + ":" + dbKey)
 	if r != nil {
 		if !i.options.hasAccessPermission(r) {
 			return nil, db, ErrPermissionDenied
@@ -228,7 +314,8 @@ func (i *Interface) getMeta(dbName string, dbKey string, mustBeWriteable bool) (
 		return nil, db, err
 	}
 
-	if !m.CheckPermission(i.options.Local, i.options.Internal) {
+	if !m.CheckPermission(i.options.Local, i.options.Internal) ||
+		!i.options.checkReadPermission(dbName+":"+dbKey, m) {
 		return nil, db, ErrPermissionDenied
 	}
 
@@ -262,18 +349,22 @@ func (i *Interface) InsertValue(key string, attribute string, value interface{})
 	}
 
 	i.options.Apply(r)
-	return db.Put(r)
+	return i.putLocked(db, r)
 }
 
 // Put saves a record to the database.
 func (i *Interface) Put(r record.Record) (err error) {
 	// get record or only database
 	var db *Controller
+	var existingMeta *record.Meta
 	if !i.options.HasAllPermissions() {
-		_, db, err = i.getMeta(r.DatabaseName(), r.DatabaseKey(), true)
+		existingMeta, db, err = i.getMeta(r.DatabaseName(), r.DatabaseKey(), true)
 		if err != nil && !errors.Is(err, ErrNotFound) {
 			return err
 		}
+		if !i.options.checkWritePermission(r.Key(), existingMeta) {
+			return ErrPermissionDenied
+		}
 	} else {
 		db, err = getController(r.DatabaseName())
 		if err != nil {
@@ -288,10 +379,17 @@ func (i *Interface) Put(r record.Record) (err error) {
 
 	r.Lock()
 	i.options.Apply(r)
+	i.options.inheritRequiredPermissions(r, existingMeta)
 	remove := r.Meta().IsDeleted()
 	ttl := r.Meta().GetRelativeExpiry()
 	r.Unlock()
 
+	// Write to overlay instead, if in use.
+	if i.overlay != nil {
+		i.writeOverlay(r, remove)
+		return nil
+	}
+
 	// The record may not be locked when updating the cache.
 	written := i.updateCache(r, true, remove, ttl)
 	if written {
@@ -307,11 +405,15 @@ func (i *Interface) Put(r record.Record) (err error) {
 func (i *Interface) PutNew(r record.Record) (err error) {
 	// get record or only database
 	var db *Controller
+	var existingMeta *record.Meta
 	if !i.options.HasAllPermissions() {
-		_, db, err = i.getMeta(r.DatabaseName(), r.DatabaseKey(), true)
+		existingMeta, db, err = i.getMeta(r.DatabaseName(), r.DatabaseKey(), true)
 		if err != nil && !errors.Is(err, ErrNotFound) {
 			return err
 		}
+		if !i.options.checkWritePermission(r.Key(), existingMeta) {
+			return ErrPermissionDenied
+		}
 	} else {
 		db, err = getController(r.DatabaseName())
 		if err != nil {
@@ -329,10 +431,17 @@ func (i *Interface) PutNew(r record.Record) (err error) {
 		r.Meta().Reset()
 	}
 	i.options.Apply(r)
+	i.options.inheritRequiredPermissions(r, existingMeta)
 	remove := r.Meta().IsDeleted()
 	ttl := r.Meta().GetRelativeExpiry()
 	r.Unlock()
 
+	// Write to overlay instead, if in use.
+	if i.overlay != nil {
+		i.writeOverlay(r, remove)
+		return nil
+	}
+
 	// The record may not be locked when updating the cache.
 	written := i.updateCache(r, true, remove, ttl)
 	if written {
@@ -361,6 +470,11 @@ func (i *Interface) PutMany(dbName string) (put func(record.Record) error) {
 		}
 	}
 
+	// Write to overlay instead, if in use.
+	if i.overlay != nil {
+		return i.overlayPutMany(dbName)
+	}
+
 	// get database
 	db, err := getController(dbName)
 	if err != nil {
@@ -455,7 +569,7 @@ func (i *Interface) SetAbsoluteExpiry(key string, time int64) error {
 
 	i.options.Apply(r)
 	r.Meta().SetAbsoluteExpiry(time)
-	return db.Put(r)
+	return i.putLocked(db, r)
 }
 
 // SetRelativateExpiry sets a relative (self-updating) record expiry.
@@ -470,7 +584,7 @@ func (i *Interface) SetRelativateExpiry(key string, duration int64) error {
 
 	i.options.Apply(r)
 	r.Meta().SetRelativateExpiry(duration)
-	return db.Put(r)
+	return i.putLocked(db, r)
 }
 
 // MakeSecret marks the record as a secret, meaning interfacing processes, such as an UI, are denied access to the record.
@@ -485,7 +599,7 @@ func (i *Interface) MakeSecret(key string) error {
 
 	i.options.Apply(r)
 	r.Meta().MakeSecret()
-	return db.Put(r)
+	return i.putLocked(db, r)
 }
 
 // MakeCrownJewel marks a record as a crown jewel, meaning it will only be accessible locally.
@@ -500,7 +614,24 @@ func (i *Interface) MakeCrownJewel(key string) error {
 
 	i.options.Apply(r)
 	r.Meta().MakeCrownJewel()
-	return db.Put(r)
+	return i.putLocked(db, r)
+}
+
+// RequirePermissions sets the API permission levels that are required to read
+// and write the record. The levels correspond to the values of api.Permission.
+// See the package function RequirePermissions for details.
+func (i *Interface) RequirePermissions(key string, read, write int8) error {
+	r, db, err := i.getRecord(getDBFromKey, key, true)
+	if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	i.options.Apply(r)
+	r.Meta().RequirePermissions(read, write)
+	return i.putLocked(db, r)
 }
 
 // Delete deletes a record from the database.
@@ -515,11 +646,27 @@ func (i *Interface) Delete(key string) error {
 		return ErrReadOnly
 	}
 
+	// Only mark the record as deleted in the overlay, if in use.
+	if i.overlay != nil {
+		i.writeOverlay(r, true)
+		return nil
+	}
+
 	i.options.Apply(r)
 	r.Meta().Delete()
 	return db.Put(r)
 }
 
+// putLocked writes the given record to the database, or to the overlay, if in
+// use. The record must be locked.
+func (i *Interface) putLocked(db *Controller, r record.Record) error {
+	if i.overlay != nil {
+		i.writeOverlay(r, false)
+		return nil
+	}
+	return db.Put(r)
+}
+
 // Query executes the given query on the database.
 // Will not see data that is in the write cache, waiting to be written.
 // Use with care with caching.
@@ -538,7 +685,22 @@ func (i *Interface) Query(q *query.Query) (*iterator.Iterator, error) {
 	// Flush the cache before we query the database.
 	// i.FlushCache()
 
-	return db.Query(q, i.options.Local, i.options.Internal)
+	it, err := db.Query(q, i.options.Local, i.options.Internal)
+	if err != nil {
+		return nil, err
+	}
+
+	// Merge overlay into results, if in use.
+	if i.overlay != nil {
+		it = i.overlayQuery(q, it)
+	}
+
+	// Filter by permission levels, if set.
+	if i.options.hasPermissionLevels() {
+		it = i.options.filterByReadPermission(it)
+	}
+
+	return it, nil
 }
 
 // Purge deletes all records that match the given query. It returns the number
@@ -559,6 +721,16 @@ func (i *Interface) Purge(ctx context.Context, q *query.Query) (int, error) {
 		return 0, ErrReadOnly
 	}
 
+	// Purging is not supported with permission levels.
+	if i.options.hasPermissionLevels() {
+		return 0, ErrPermissionDenied
+	}
+
+	// Only mark the records as deleted in the overlay, if in use.
+	if i.overlay != nil {
+		return i.overlayPurge(ctx, q)
+	}
+
 	return db.Purge(ctx, q, i.options.Local, i.options.Internal)
 }
 
@@ -578,6 +750,7 @@ func (i *Interface) Subscribe(q *query.Query) (*Subscription, error) {
 		q:        q,
 		local:    i.options.Local,
 		internal: i.options.Internal,
+		options:  i.options,
 		Feed:     make(chan record.Record, 1000),
 	}
 	c.addSubscription(sub)
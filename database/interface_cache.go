@@ -109,6 +109,8 @@ func (i *Interface) flushWriteCache(percentThreshold int) {
 		log.Warningf("database: failed to finish flushing write cache to %q database: %s", i.options.DelayCachedWrites, err)
 	}
 
+	i.cacheFlushedWrites.Add(uint64(len(i.writeCache)))
+
 	// Optimized map clearing following the Go1.11 recommendation.
 	for key := range i.writeCache {
 		delete(i.writeCache, key)
@@ -118,6 +120,13 @@ func (i *Interface) flushWriteCache(percentThreshold int) {
 // cacheEvictHandler is run by the cache for every entry that gets evicted
 // from the cache.
 func (i *Interface) cacheEvictHandler(keyData, _ interface{}) {
+	i.cacheEvictions.Add(1)
+
+	// Check if write cache is in use.
+	if i.writeCache == nil {
+		return
+	}
+
 	// Transform the key into a string.
 	key, ok := keyData.(string)
 	if !ok {
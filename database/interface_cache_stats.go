@@ -0,0 +1,131 @@
+package database
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/safing/portbase/log"
+)
+
+// CacheStats holds statistics about the cache of an interface.
+type CacheStats struct {
+	// Name is the name of the interface, as set in the options.
+	Name string
+	// Size is the maximum amount of entries in the cache.
+	Size int
+	// Entries is the current amount of entries in the cache.
+	Entries int
+	// Hits is the amount of lookups that were served from the cache.
+	Hits uint64
+	// Misses is the amount of lookups that were not found in the cache.
+	Misses uint64
+	// HitRate is the ratio of hits to all lookups.
+	HitRate float64
+	// Evictions is the amount of entries that were removed from the cache,
+	// either because the cache was full, the entry expired or the record was
+	// deleted.
+	Evictions uint64
+	// DelayedWrites defines if writes to the database are delayed and batched.
+	DelayedWrites bool
+	// PendingWrites is the amount of records that wait to be written to the
+	// database.
+	PendingWrites int
+	// FlushedWrites is the amount of records that were written to the database
+	// from the write cache.
+	FlushedWrites uint64
+}
+
+var (
+	cachedInterfaces     = make(map[string]*Interface)
+	cachedInterfacesLock sync.Mutex
+
+	cachedInterfaceObservers []func(*Interface)
+)
+
+// CacheStats returns statistics about the cache of the interface. It returns
+// nil if the interface does not use a cache.
+func (i *Interface) CacheStats() *CacheStats {
+	// Check if cache is in use.
+	if i.cache == nil {
+		return nil
+	}
+
+	stats := &CacheStats{
+		Name:          i.options.Name,
+		Size:          i.options.CacheSize,
+		Entries:       i.cache.Len(false),
+		Hits:          i.cache.HitCount(),
+		Misses:        i.cache.MissCount(),
+		HitRate:       i.cache.HitRate(),
+		Evictions:     i.cacheEvictions.Load(),
+		DelayedWrites: i.options.DelayCachedWrites != "",
+		FlushedWrites: i.cacheFlushedWrites.Load(),
+	}
+
+	if stats.DelayedWrites {
+		i.writeCacheLock.Lock()
+		stats.PendingWrites = len(i.writeCache)
+		i.writeCacheLock.Unlock()
+	}
+
+	return stats
+}
+
+// Name returns the name of the interface, as set in the options.
+func (i *Interface) Name() string {
+	return i.options.Name
+}
+
+// registerCachedInterface registers a named interface with a cache for
+// statistics and notifies all observers. Registered interfaces are kept for the
+// lifetime of the program, so only the first interface with a name is
+// registered.
+func registerCachedInterface(i *Interface) {
+	cachedInterfacesLock.Lock()
+	if _, ok := cachedInterfaces[i.options.Name]; ok {
+		cachedInterfacesLock.Unlock()
+		log.Warningf("database: not tracking cache statistics of interface %s, as the name is already in use", i.options.Name)
+		return
+	}
+	cachedInterfaces[i.options.Name] = i
+	observers := cachedInterfaceObservers
+	cachedInterfacesLock.Unlock()
+
+	for _, fn := range observers {
+		fn(i)
+	}
+}
+
+// GetCachedInterfaces returns all named interfaces that use a cache, sorted by
+// name.
+func GetCachedInterfaces() []*Interface {
+	cachedInterfacesLock.Lock()
+	defer cachedInterfacesLock.Unlock()
+
+	ifaces := make([]*Interface, 0, len(cachedInterfaces))
+	for _, i := range cachedInterfaces {
+		ifaces = append(ifaces, i)
+	}
+
+	sort.Slice(ifaces, func(a, b int) bool {
+		return ifaces[a].options.Name < ifaces[b].options.Name
+	})
+	return ifaces
+}
+
+// ObserveCachedInterfaces registers a function that is called with every named
+// interface that uses a cache. It is immediately called with all existing
+// ones and then with every new one when it is created.
+func ObserveCachedInterfaces(fn func(*Interface)) {
+	cachedInterfacesLock.Lock()
+	cachedInterfaceObservers = append(cachedInterfaceObservers, fn)
+	existing := make([]*Interface, 0, len(cachedInterfaces))
+	for _, i := range cachedInterfaces {
+		existing = append(existing, i)
+	}
+	cachedInterfacesLock.Unlock()
+
+	for _, i := range existing {
+		fn(i)
+	}
+}
@@ -0,0 +1,88 @@
+package database
+
+import (
+	"testing"
+)
+
+func testCacheStats(t *testing.T) { //nolint:thelper
+	t.Run("TestCacheStats", func(t *testing.T) {
+		dbName := "testing-cache-stats"
+		_, err := Register(&Database{
+			Name:        dbName,
+			Description: "Unit Test Database for Cache Statistics",
+			StorageType: "hashmap",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := NewInterface(&Options{
+			Name:      "testing-cache-stats",
+			Local:     true,
+			Internal:  true,
+			CacheSize: 2,
+		})
+
+		// Check registration.
+		var found bool
+		for _, i := range GetCachedInterfaces() {
+			if i == db {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatal("named interface should be registered")
+		}
+
+		// Interfaces with a name that is already in use are not registered.
+		duplicate := NewInterface(&Options{
+			Name:      "testing-cache-stats",
+			Local:     true,
+			Internal:  true,
+			CacheSize: 2,
+		})
+		for _, i := range GetCachedInterfaces() {
+			if i == duplicate {
+				t.Fatal("interface with duplicate name should not be registered")
+			}
+		}
+		if duplicate.CacheStats() == nil {
+			t.Error("interface with duplicate name should still have statistics")
+		}
+
+		// Fill the cache beyond its size and read from it.
+		for _, key := range []string{"A", "B", "C"} {
+			if err := db.Put(NewExample(dbName+":"+key, key, 1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.Get(dbName + ":C"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get(dbName + ":A"); err != nil {
+			t.Fatal(err)
+		}
+
+		stats := db.CacheStats()
+		if stats.Name != "testing-cache-stats" {
+			t.Errorf("unexpected name %q", stats.Name)
+		}
+		if stats.Entries > 2 {
+			t.Errorf("cache should hold at most 2 entries, but holds %d", stats.Entries)
+		}
+		if stats.Hits != 1 {
+			t.Errorf("expected 1 hit, got %d", stats.Hits)
+		}
+		if stats.Misses != 1 {
+			t.Errorf("expected 1 miss, got %d", stats.Misses)
+		}
+		if stats.Evictions == 0 {
+			t.Error("expected evictions")
+		}
+
+		// Interfaces without a cache have no statistics.
+		if NewInterface(nil).CacheStats() != nil {
+			t.Error("interface without cache should not have statistics")
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/safing/portbase/database/iterator"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+)
+
+// checkOverlay returns the record with the given key from the overlay. If
+// found is true, but the record is nil, the record was deleted in the overlay.
+func (i *Interface) checkOverlay(key string) (r record.Record, found bool) {
+	// Check if overlay is in use.
+	if i.overlay == nil {
+		return nil, false
+	}
+
+	i.overlayLock.Lock()
+	defer i.overlayLock.Unlock()
+
+	r, found = i.overlay[key]
+	return r, found
+}
+
+// writeOverlay writes the given record to the overlay. If remove is true, the
+// record is marked as deleted instead.
+func (i *Interface) writeOverlay(r record.Record, remove bool) {
+	i.overlayLock.Lock()
+	defer i.overlayLock.Unlock()
+
+	if remove {
+		i.overlay[r.Key()] = nil
+	} else {
+		i.overlay[r.Key()] = r
+	}
+}
+
+// DiscardOverlay discards all changes kept in the overlay.
+func (i *Interface) DiscardOverlay() {
+	// Check if overlay is in use.
+	if i.overlay == nil {
+		return
+	}
+
+	i.overlayLock.Lock()
+	defer i.overlayLock.Unlock()
+
+	// Optimized map clearing following the Go1.11 recommendation.
+	for key := range i.overlay {
+		delete(i.overlay, key)
+	}
+}
+
+// OverlayChanges returns the keys of all records that were changed in the
+// overlay, mapped to whether they were deleted.
+func (i *Interface) OverlayChanges() map[string]bool {
+	// Check if overlay is in use.
+	if i.overlay == nil {
+		return nil
+	}
+
+	i.overlayLock.Lock()
+	defer i.overlayLock.Unlock()
+
+	changes := make(map[string]bool, len(i.overlay))
+	for key, r := range i.overlay {
+		changes[key] = r == nil
+	}
+	return changes
+}
+
+// overlayPutMany returns a put function for PutMany that writes to the overlay.
+func (i *Interface) overlayPutMany(dbName string) (put func(record.Record) error) {
+	return func(r record.Record) error {
+		// finish?
+		if r == nil {
+			return nil
+		}
+
+		// check record scope
+		if r.DatabaseName() != dbName {
+			return errors.New("record out of database scope")
+		}
+
+		r.Lock()
+		i.options.Apply(r)
+		remove := r.Meta().IsDeleted()
+		r.Unlock()
+
+		i.writeOverlay(r, remove)
+		return nil
+	}
+}
+
+// overlayQuery merges the overlay into the results of the given iterator.
+// Records that are changed in the overlay are replaced by their overlay
+// version, records added to the overlay are appended to the results.
+func (i *Interface) overlayQuery(q *query.Query, dbIt *iterator.Iterator) *iterator.Iterator {
+	// Copy the relevant part of the overlay.
+	i.overlayLock.Lock()
+	overlay := make(map[string]record.Record)
+	for key, r := range i.overlay {
+		dbName, dbKey := record.ParseKey(key)
+		if dbName == q.DatabaseName() && q.MatchesKey(dbKey) {
+			overlay[key] = r
+		}
+	}
+	i.overlayLock.Unlock()
+
+	it := iterator.New()
+	go func() {
+		// Pass through database results that were not changed in the overlay.
+	dbResults:
+		for {
+			select {
+			case r, ok := <-dbIt.Next:
+				if !ok {
+					break dbResults
+				}
+				if _, changed := overlay[r.Key()]; changed {
+					continue
+				}
+				select {
+				case it.Next <- r:
+				case <-it.Done:
+					dbIt.Cancel()
+					it.Finish(nil)
+					return
+				}
+			case <-it.Done:
+				dbIt.Cancel()
+				it.Finish(nil)
+				return
+			}
+		}
+		if err := dbIt.Err(); err != nil {
+			it.Finish(err)
+			return
+		}
+
+		// Add matching records of the overlay.
+		for _, r := range overlay {
+			if r == nil || !i.options.hasAccessPermission(r) {
+				continue
+			}
+
+			r.Lock()
+			matches := r.Meta().CheckValidity() && q.MatchesRecord(r)
+			r.Unlock()
+			if !matches {
+				continue
+			}
+
+			select {
+			case it.Next <- r:
+			case <-it.Done:
+				it.Finish(nil)
+				return
+			}
+		}
+
+		it.Finish(nil)
+	}()
+
+	return it
+}
+
+// overlayPurge marks all records that match the given query as deleted in the
+// overlay.
+func (i *Interface) overlayPurge(ctx context.Context, q *query.Query) (int, error) {
+	it, err := i.Query(q)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for {
+		select {
+		case r, ok := <-it.Next:
+			if !ok {
+				return deleted, it.Err()
+			}
+			i.writeOverlay(r, true)
+			deleted++
+		case <-ctx.Done():
+			it.Cancel()
+			return deleted, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	q "github.com/safing/portbase/database/query"
+)
+
+func testInterfaceOverlay(t *testing.T) { //nolint:thelper
+	t.Run("TestInterfaceOverlay", func(t *testing.T) {
+		dbName := "testing-overlay"
+		_, err := Register(&Database{
+			Name:        dbName,
+			Description: "Unit Test Database for Overlays",
+			StorageType: "bbolt",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		db := NewInterface(&Options{
+			Local:    true,
+			Internal: true,
+		})
+		overlay := NewInterface(&Options{
+			Local:    true,
+			Internal: true,
+			Overlay:  true,
+		})
+
+		// Prepare existing records.
+		if err := db.Put(NewExample(dbName+":A", "A", 1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(NewExample(dbName+":B", "B", 2)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Change records through the overlay.
+		if err := overlay.Put(NewExample(dbName+":A", "A", 10)); err != nil {
+			t.Fatal(err)
+		}
+		if err := overlay.Put(NewExample(dbName+":C", "C", 3)); err != nil {
+			t.Fatal(err)
+		}
+		if err := overlay.Delete(dbName + ":B"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Check that the overlay sees the changes.
+		r, err := overlay.Get(dbName + ":A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, ok := r.(*Example); !ok || e.Score != 10 {
+			t.Fatalf("unexpected record in overlay: %+v", r)
+		}
+		if _, err := overlay.Get(dbName + ":B"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected deleted record in overlay, got %v", err)
+		}
+		it, err := overlay.Query(q.New(dbName).MustBeValid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]struct{})
+		for r := range it.Next {
+			seen[r.Key()] = struct{}{}
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		if len(seen) != 2 {
+			t.Fatalf("expected 2 records in overlay query, got %v", seen)
+		}
+		for _, key := range []string{"A", "C"} {
+			if _, ok := seen[dbName+":"+key]; !ok {
+				t.Fatalf("missing record %s in overlay query", key)
+			}
+		}
+
+		// Check that the database did not change.
+		r, err = GetExample(dbName + ":A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, ok := r.(*Example); !ok || e.Score != 1 {
+			t.Fatalf("database record was changed by overlay: %+v", r)
+		}
+		if _, err := db.Get(dbName + ":B"); err != nil {
+			t.Fatalf("database record was deleted by overlay: %s", err)
+		}
+		if _, err := db.Get(dbName + ":C"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("database record was created by overlay: %v", err)
+		}
+
+		// Purge through the overlay.
+		n, err := overlay.Purge(context.Background(), q.New(dbName).MustBeValid())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Fatalf("expected 2 purged records, got %d", n)
+		}
+		if len(overlay.OverlayChanges()) != 3 {
+			t.Fatalf("unexpected overlay changes: %v", overlay.OverlayChanges())
+		}
+
+		// Discard overlay.
+		overlay.DiscardOverlay()
+		if _, err := overlay.Get(dbName + ":B"); err != nil {
+			t.Fatalf("expected record after discarding overlay: %s", err)
+		}
+	})
+}
@@ -2,6 +2,10 @@
 
 import "errors"
 
+// ErrRollbackNotSupported is returned when a migration that should be rolled
+// back does not have a DownFunc.
+var ErrRollbackNotSupported = errors.New("migration does not support rollback")
+
 // DiagnosticStep describes one migration step in the Diagnostics.
 type DiagnosticStep struct {
 	Version     string
@@ -36,6 +36,11 @@ type Migration struct {
 	Version string
 	// MigrateFuc is executed when the migration should be performed.
 	MigrateFunc MigrateFunc
+	// DownFunc is optional and executed when the migration should be
+	// reverted. It receives the version after the migration (from) and
+	// the version before the migration (to). Migrations without a
+	// DownFunc cannot be rolled back.
+	DownFunc MigrateFunc
 }
 
 // Registry holds a migration stack.
@@ -44,6 +49,7 @@ type Registry struct {
 
 	lock       sync.Mutex
 	migrations []Migration
+	onProgress ProgressFunc
 }
 
 // New creates a new migration registry.
@@ -72,23 +78,46 @@ func (reg *Registry) Add(migrations ...Migration) error {
 // migration in order of increasing version numbers. The error
 // returned, if not nil, is always of type *Diagnostics.
 func (reg *Registry) Migrate(ctx context.Context) (err error) {
+	return reg.migrate(ctx, false)
+}
+
+// DryRun executes all pending migrations like Migrate, but keeps all
+// changes in an overlay that is discarded afterwards. Nothing is
+// written to the database. Use it to check whether pending
+// migrations would succeed. The error returned, if not nil, is always
+// of type *Diagnostics.
+// Please note that the migrations see the changes of previous
+// migrations only through the given database interface. Also, see the
+// Overlay option of database.Options for limitations.
+func (reg *Registry) DryRun(ctx context.Context) (err error) {
+	return reg.migrate(ctx, true)
+}
+
+func (reg *Registry) migrate(ctx context.Context, dryRun bool) (err error) {
 	reg.lock.Lock()
 	defer reg.lock.Unlock()
 
+	runType := "migration"
+	if dryRun {
+		runType = "dry run"
+	}
+
 	start := time.Now()
-	log.Infof("migration: migration of %s started", reg.key)
+	log.Infof("migration: %s of %s started", runType, reg.key)
 	defer func() {
 		if err != nil {
-			log.Errorf("migration: migration of %s failed after %s: %s", reg.key, time.Since(start), err)
+			log.Errorf("migration: %s of %s failed after %s: %s", runType, reg.key, time.Since(start), err)
 		} else {
-			log.Infof("migration: migration of %s finished after %s", reg.key, time.Since(start))
+			log.Infof("migration: %s of %s finished after %s", runType, reg.key, time.Since(start))
 		}
 	}()
 
 	db := database.NewInterface(&database.Options{
 		Local:    true,
 		Internal: true,
+		Overlay:  dryRun,
 	})
+	defer db.DiscardOverlay()
 
 	startOfMigration, err := reg.getLatestSuccessfulMigration(db)
 	if err != nil {
@@ -106,16 +135,35 @@ func (reg *Registry) Migrate(ctx context.Context) (err error) {
 
 	// finally, apply our migrations
 	lastAppliedMigration := startOfMigration
-	for _, m := range execPlan {
+	for step, m := range execPlan {
 		target, _ := version.NewSemver(m.Version) // we can safely ignore the error here
 
+		reg.reportProgress(&Progress{
+			Key:         reg.key,
+			DryRun:      dryRun,
+			Step:        step + 1,
+			Steps:       len(execPlan),
+			Version:     target.String(),
+			Description: m.Description,
+		})
+
 		migrationCtx, tracer := log.AddTracer(ctx)
 
 		if err := m.MigrateFunc(migrationCtx, lastAppliedMigration, target, db); err != nil {
 			diag.Wrapped = err
 			diag.FailedMigration = m.Description
-			tracer.Errorf("migration: migration for %s failed: %s - %s", reg.key, target.String(), m.Description)
+			tracer.Errorf("migration: %s for %s failed: %s - %s", runType, reg.key, target.String(), m.Description)
 			tracer.Submit()
+			reg.reportProgress(&Progress{
+				Key:         reg.key,
+				DryRun:      dryRun,
+				Step:        step + 1,
+				Steps:       len(execPlan),
+				Version:     target.String(),
+				Description: m.Description,
+				Done:        true,
+				Err:         diag,
+			})
 			return diag
 		}
 
@@ -127,14 +175,135 @@ func (reg *Registry) Migrate(ctx context.Context) (err error) {
 			diag.Wrapped = err
 			diag.FailedMigration = m.Description
 		}
-		tracer.Infof("migration: applied migration for %s: %s - %s", reg.key, target.String(), m.Description)
+		tracer.Infof("migration: applied %s for %s: %s - %s", runType, reg.key, target.String(), m.Description)
 		tracer.Submit()
 	}
 
+	reg.reportProgress(&Progress{
+		Key:     reg.key,
+		DryRun:  dryRun,
+		Step:    len(execPlan),
+		Steps:   len(execPlan),
+		Version: lastAppliedMigration.String(),
+		Done:    true,
+	})
+
 	// all migrations have been applied successfully, we're done here
 	return nil
 }
 
+// Rollback reverts all applied migrations with a version greater than
+// the given target version in order of decreasing version numbers by
+// executing their DownFunc. All of these migrations must have a
+// DownFunc, else no migration is reverted at all. The error returned,
+// if not nil, is always of type *Diagnostics.
+func (reg *Registry) Rollback(ctx context.Context, targetVersion string) (err error) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	start := time.Now()
+	log.Infof("migration: rollback of %s to %s started", reg.key, targetVersion)
+	defer func() {
+		if err != nil {
+			log.Errorf("migration: rollback of %s failed after %s: %s", reg.key, time.Since(start), err)
+		} else {
+			log.Infof("migration: rollback of %s finished after %s", reg.key, time.Since(start))
+		}
+	}()
+
+	target, err := version.NewSemver(targetVersion)
+	if err != nil {
+		return &Diagnostics{
+			Message: "failed to parse target version",
+			Wrapped: err,
+		}
+	}
+
+	db := database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	startOfRollback, err := reg.getLatestSuccessfulMigration(db)
+	if err != nil {
+		return err
+	}
+	if startOfRollback == nil || target.GreaterThanOrEqual(startOfRollback) {
+		// Nothing to roll back.
+		return nil
+	}
+
+	rollbackPlan, diag, err := reg.getRollbackPlan(startOfRollback, target)
+	if err != nil {
+		return err
+	}
+	if len(rollbackPlan) == 0 {
+		return nil
+	}
+	diag.TargetVersion = target.String()
+
+	// revert the migrations
+	lastRevertedMigration := startOfRollback
+	for step, m := range rollbackPlan {
+		// Revert to the version of the previous migration, but not beyond the target.
+		revertTo := target
+		if step+1 < len(rollbackPlan) {
+			revertTo, _ = version.NewSemver(rollbackPlan[step+1].Version) // we can safely ignore the error here
+		}
+
+		reg.reportProgress(&Progress{
+			Key:         reg.key,
+			Rollback:    true,
+			Step:        step + 1,
+			Steps:       len(rollbackPlan),
+			Version:     revertTo.String(),
+			Description: m.Description,
+		})
+
+		migrationCtx, tracer := log.AddTracer(ctx)
+
+		if err := m.DownFunc(migrationCtx, lastRevertedMigration, revertTo, db); err != nil {
+			diag.Wrapped = err
+			diag.FailedMigration = m.Description
+			tracer.Errorf("migration: rollback for %s failed: %s - %s", reg.key, m.Version, m.Description)
+			tracer.Submit()
+			reg.reportProgress(&Progress{
+				Key:         reg.key,
+				Rollback:    true,
+				Step:        step + 1,
+				Steps:       len(rollbackPlan),
+				Version:     revertTo.String(),
+				Description: m.Description,
+				Done:        true,
+				Err:         diag,
+			})
+			return diag
+		}
+
+		lastRevertedMigration = revertTo
+		diag.LastSuccessfulMigration = lastRevertedMigration.String()
+
+		if err := reg.saveLastSuccessfulMigration(db, revertTo); err != nil {
+			diag.Message = "failed to persist migration status"
+			diag.Wrapped = err
+			diag.FailedMigration = m.Description
+		}
+		tracer.Infof("migration: reverted migration for %s: %s - %s", reg.key, m.Version, m.Description)
+		tracer.Submit()
+	}
+
+	reg.reportProgress(&Progress{
+		Key:      reg.key,
+		Rollback: true,
+		Step:     len(rollbackPlan),
+		Steps:    len(rollbackPlan),
+		Version:  lastRevertedMigration.String(),
+		Done:     true,
+	})
+
+	return nil
+}
+
 func (reg *Registry) getLatestSuccessfulMigration(db *database.Interface) (*version.Version, error) {
 	// find the latest version stored in the database
 	rec, err := db.Get(reg.key)
@@ -176,10 +345,9 @@ func (reg *Registry) saveLastSuccessfulMigration(db *database.Interface, ver *ve
 	return db.Put(r)
 }
 
-func (reg *Registry) getExecutionPlan(startOfMigration *version.Version) ([]Migration, *Diagnostics, error) {
+func (reg *Registry) getSortedMigrations() (map[string]Migration, version.Collection, error) {
 	// create a look-up map for migrations indexed by their semver created a
-	// list of version (sorted by increasing number) that we use as our execution
-	// plan.
+	// list of version (sorted by increasing number).
 	lm := make(map[string]Migration)
 	versions := make(version.Collection, 0, len(reg.migrations))
 	for _, m := range reg.migrations {
@@ -196,6 +364,17 @@ func (reg *Registry) getExecutionPlan(startOfMigration *version.Version) ([]Migr
 	}
 	sort.Sort(versions)
 
+	return lm, versions, nil
+}
+
+func (reg *Registry) getExecutionPlan(startOfMigration *version.Version) ([]Migration, *Diagnostics, error) {
+	// get the migrations sorted by increasing version number that we use as
+	// our execution plan.
+	lm, versions, err := reg.getSortedMigrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	diag := new(Diagnostics)
 	if startOfMigration != nil {
 		diag.StartOfMigration = startOfMigration.String()
@@ -218,3 +397,38 @@ func (reg *Registry) getExecutionPlan(startOfMigration *version.Version) ([]Migr
 
 	return execPlan, diag, nil
 }
+
+func (reg *Registry) getRollbackPlan(startOfRollback, target *version.Version) ([]Migration, *Diagnostics, error) {
+	lm, versions, err := reg.getSortedMigrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	diag := &Diagnostics{
+		StartOfMigration: startOfRollback.String(),
+	}
+
+	// prepare our diagnostics and the rollback plan in order of decreasing
+	// version numbers.
+	rollbackPlan := make([]Migration, 0, len(versions))
+	for idx := len(versions) - 1; idx >= 0; idx-- {
+		ver := versions[idx]
+		// skip migrations that have not been applied or are to be kept.
+		if ver.GreaterThan(startOfRollback) || target.GreaterThanOrEqual(ver) {
+			continue
+		}
+		m := lm[ver.String()]
+		if m.DownFunc == nil {
+			diag.Wrapped = ErrRollbackNotSupported
+			diag.FailedMigration = m.Description
+			return nil, nil, diag
+		}
+		diag.ExecutionPlan = append(diag.ExecutionPlan, DiagnosticStep{
+			Description: m.Description,
+			Version:     ver.String(),
+		})
+		rollbackPlan = append(rollbackPlan, m)
+	}
+
+	return rollbackPlan, diag, nil
+}
@@ -0,0 +1,147 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/go-version"
+
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/database/record"
+	_ "github.com/safing/portbase/database/storage/bbolt"
+	"github.com/safing/portbase/formats/dsd"
+)
+
+func TestMain(m *testing.M) {
+	testDir, err := os.MkdirTemp("", "portbase-migration-testing-")
+	if err != nil {
+		panic(err)
+	}
+
+	err = database.InitializeWithPath(testDir)
+	if err != nil {
+		panic(err)
+	}
+	_, err = database.Register(&database.Database{
+		Name:        "test",
+		Description: "Unit Test Database for Migrations",
+		StorageType: "bbolt",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	exitCode := m.Run()
+
+	// Clean up the test directory.
+	// Do not defer, as we end this function with a os.Exit call.
+	_ = os.RemoveAll(testDir)
+
+	os.Exit(exitCode)
+}
+
+func putValue(db *database.Interface, key, value string) error {
+	r, err := record.NewWrapper(key, nil, dsd.RAW, []byte(value))
+	if err != nil {
+		return err
+	}
+	return db.Put(r)
+}
+
+func getValue(db *database.Interface, key string) (string, error) {
+	r, err := db.Get(key)
+	if err != nil {
+		return "", err
+	}
+	w, ok := r.(*record.Wrapper)
+	if !ok {
+		return "", errors.New("expected wrapped database record")
+	}
+	return string(w.Data), nil
+}
+
+func setValueMigration(key, up, down string) (MigrateFunc, MigrateFunc) {
+	return func(_ context.Context, _, _ *version.Version, db *database.Interface) error {
+			return putValue(db, key, up)
+		}, func(_ context.Context, _, _ *version.Version, db *database.Interface) error {
+			return putValue(db, key, down)
+		}
+}
+
+func TestMigrationDryRunAndRollback(t *testing.T) {
+	t.Parallel()
+
+	db := database.NewInterface(&database.Options{
+		Local:    true,
+		Internal: true,
+	})
+
+	reg := New("test:migrations/status")
+	up1, down1 := setValueMigration("test:value", "v1", "")
+	up2, down2 := setValueMigration("test:value", "v2", "v1")
+	if err := reg.Add(
+		Migration{Description: "one", Version: "v0.1.0", MigrateFunc: up1, DownFunc: down1},
+		Migration{Description: "two", Version: "v0.2.0", MigrateFunc: up2, DownFunc: down2},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []*Progress
+	reg.OnProgress(func(p *Progress) {
+		progress = append(progress, p)
+	})
+
+	// Dry run must not change anything.
+	if err := reg.DryRun(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get("test:value"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("dry run wrote to database: %v", err)
+	}
+	if _, err := db.Get("test:migrations/status"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("dry run saved migration status: %v", err)
+	}
+	if len(progress) != 3 || !progress[0].DryRun || !progress[2].Done {
+		t.Fatalf("unexpected progress reports: %+v", progress)
+	}
+
+	// Migrate.
+	if err := reg.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := getValue(db, "test:value"); err != nil || v != "v2" {
+		t.Fatalf("unexpected value after migration: %q %v", v, err)
+	}
+
+	// Roll back to first migration.
+	if err := reg.Rollback(context.Background(), "v0.1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := getValue(db, "test:value"); err != nil || v != "v1" {
+		t.Fatalf("unexpected value after rollback: %q %v", v, err)
+	}
+	if v, err := getValue(db, "test:migrations/status"); err != nil || v != "0.1.0" {
+		t.Fatalf("unexpected migration status after rollback: %q %v", v, err)
+	}
+
+	// Migrating again must only apply the second migration.
+	if err := reg.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := getValue(db, "test:value"); err != nil || v != "v2" {
+		t.Fatalf("unexpected value after second migration: %q %v", v, err)
+	}
+
+	// Rollback must fail if a migration has no DownFunc.
+	if err := reg.Add(Migration{Description: "three", Version: "v0.3.0", MigrateFunc: up2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Rollback(context.Background(), "v0.1.0"); !errors.Is(err, ErrRollbackNotSupported) {
+		t.Fatalf("expected rollback to fail, got %v", err)
+	}
+}
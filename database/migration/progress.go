@@ -0,0 +1,118 @@
+package migration
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/safing/portbase/modules"
+	"github.com/safing/portbase/notifications"
+)
+
+// Progress describes the progress of a migration run.
+type Progress struct {
+	// Key is the key of the migration registry.
+	Key string
+	// DryRun is set if the migrations are executed as a dry run.
+	DryRun bool
+	// Rollback is set if the migrations are being reverted.
+	Rollback bool
+	// Step is the number of the current migration step, starting at 1.
+	Step int
+	// Steps is the total number of migration steps in this run.
+	Steps int
+	// Version is the version of the database after the current step.
+	Version string
+	// Description is the description of the current migration.
+	Description string
+	// Done is set when the run has finished, successfully or not.
+	Done bool
+	// Err holds the error if the run failed.
+	Err error
+}
+
+// ProgressFunc is called when a migration step starts and when a migration
+// run finishes.
+type ProgressFunc func(p *Progress)
+
+// OnProgress sets a function that is called with progress updates of
+// migration runs. It replaces any previously set function.
+func (reg *Registry) OnProgress(fn ProgressFunc) {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	reg.onProgress = fn
+}
+
+// reportProgress reports the progress to the progress function, if set.
+// The registry must be locked.
+func (reg *Registry) reportProgress(p *Progress) {
+	if reg.onProgress == nil {
+		return
+	}
+
+	reg.onProgress(p)
+}
+
+// ModuleProgress returns a ProgressFunc that shows the progress of migration
+// runs with more than minSteps steps in a notification that is attached to
+// the given module, and thus also reflected in the module's status.
+// The notification is removed when the run finishes successfully. If the run
+// fails, the notification is replaced by an error notification.
+func ModuleProgress(m *modules.Module, minSteps int) ProgressFunc {
+	var (
+		lock sync.Mutex
+		n    *notifications.Notification
+	)
+
+	return func(p *Progress) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		// Ignore short migration runs.
+		if p.Steps < minSteps {
+			return
+		}
+
+		eventID := fmt.Sprintf("%s:migration-%s", m.Name, p.Key)
+
+		// Handle finished run.
+		if p.Done {
+			if n != nil {
+				n.Delete()
+				n = nil
+			}
+			if p.Err != nil {
+				notifications.NotifyError(
+					eventID+"-failed",
+					"Data Migration Failed",
+					fmt.Sprintf("Failed to migrate data of %s: %s", p.Key, p.Err),
+				).AttachToModule(m)
+			}
+			return
+		}
+
+		// Create or update progress notification.
+		msg := fmt.Sprintf("Step %d of %d: %s", p.Step, p.Steps, p.Description)
+		switch {
+		case p.DryRun:
+			msg = "Testing migrations. " + msg
+		case p.Rollback:
+			msg = "Reverting migrations. " + msg
+		}
+		if n == nil {
+			n = notifications.Notify(&notifications.Notification{
+				EventID: eventID,
+				Type:    notifications.Info,
+				Title:   "Migrating Data",
+				Message: msg,
+			})
+			n.AttachToModule(m)
+			return
+		}
+
+		n.Lock()
+		n.Message = msg
+		n.Unlock()
+		n.Save()
+	}
+}
@@ -6,7 +6,7 @@
 
 // GenCodeSize returns the size of the gencode marshalled byte slice.
 func (m *Meta) GenCodeSize() (s int) {
-	s += 34
+	s += 36
 	return
 }
 
@@ -112,12 +112,20 @@ func (m *Meta) GenCodeMarshal(buf []byte) ([]byte, error) {
 			buf[33] = 0
 		}
 	}
-	return buf[:i+34], nil
+	{
+		buf[34] = byte(m.readPermission)
+	}
+	{
+		buf[35] = byte(m.writePermission)
+	}
+	return buf[:i+36], nil
 }
 
 // GenCodeUnmarshal gencode unmarshalls Meta and returns the bytes read.
 func (m *Meta) GenCodeUnmarshal(buf []byte) (uint64, error) {
-	if len(buf) < m.GenCodeSize() {
+	// Metadata written before the required permissions were added is only
+	// 34 bytes long.
+	if len(buf) < 34 {
 		return 0, fmt.Errorf("insufficient data: got %d out of %d bytes", len(buf), m.GenCodeSize())
 	}
 
@@ -141,5 +149,16 @@ func (m *Meta) GenCodeUnmarshal(buf []byte) (uint64, error) {
 	{
 		m.cronjewel = buf[33] == 1
 	}
-	return i + 34, nil
+	if len(buf) < 36 {
+		m.readPermission = 0
+		m.writePermission = 0
+		return i + 34, nil
+	}
+	{
+		m.readPermission = int8(buf[34])
+	}
+	{
+		m.writePermission = int8(buf[35])
+	}
+	return i + 36, nil
 }
@@ -13,6 +13,9 @@
 	Deleted:   time.Now().Unix(),
 	secret:    true,
 	cronjewel: true,
+
+	readPermission:  2,
+	writePermission: 3,
 }
 
 func TestGenCode(t *testing.T) {
@@ -33,3 +36,27 @@ func TestGenCode(t *testing.T) {
 		t.Errorf("objects are not equal, got: %v", newMeta)
 	}
 }
+
+func TestGenCodeWithoutPermissions(t *testing.T) {
+	t.Parallel()
+
+	encoded, err := genCodeTestMeta.GenCodeMarshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Metadata written by earlier versions does not include the permissions.
+	newMeta := &Meta{}
+	n, err := newMeta.GenCodeUnmarshal(encoded[:34])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 34 {
+		t.Errorf("expected to read 34 bytes, got %d", n)
+	}
+
+	read, write := newMeta.RequiredPermissions()
+	if read != 0 || write != 0 {
+		t.Errorf("unexpected required permissions: %d %d", read, write)
+	}
+}
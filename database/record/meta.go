@@ -10,6 +10,9 @@ type Meta struct {
 	Deleted   int64
 	secret    bool // secrets must not be sent to the UI, only synced between nodes
 	cronjewel bool // crownjewels must never leave the instance, but may be read by the UI
+
+	readPermission  int8 // required API permission level for reading, see api.Permission
+	writePermission int8 // required API permission level for writing, see api.Permission
 }
 
 // SetAbsoluteExpiry sets an absolute expiry time (in seconds), that is not affected when the record is updated.
@@ -54,6 +57,31 @@ func (m *Meta) MakeSecret() {
 	m.secret = true
 }
 
+// IsCrownJewel returns whether the database record is marked as a crownjewel.
+func (m *Meta) IsCrownJewel() bool {
+	return m.cronjewel
+}
+
+// IsSecret returns whether the database record is marked as secret.
+func (m *Meta) IsSecret() bool {
+	return m.secret
+}
+
+// RequirePermissions sets the API permission levels that are required to read
+// and write the database record. The levels correspond to the values of
+// api.Permission. A level of zero means that no specific level is required.
+func (m *Meta) RequirePermissions(read, write int8) {
+	m.readPermission = read
+	m.writePermission = write
+}
+
+// RequiredPermissions returns the API permission levels that are required to
+// read and write the database record. A level of zero means that no specific
+// level is required.
+func (m *Meta) RequiredPermissions() (read, write int8) {
+	return m.readPermission, m.writePermission
+}
+
 // Update updates the internal meta states and should be called before writing the record to the database.
 func (m *Meta) Update() {
 	now := time.Now().Unix()
@@ -66,7 +94,8 @@ func (m *Meta) Update() {
 	}
 }
 
-// Reset resets all metadata, except for the secret and crownjewel status.
+// Reset resets all metadata, except for the secret and crownjewel status and
+// the required permissions.
 func (m *Meta) Reset() {
 	m.Created = 0
 	m.Modified = 0
@@ -125,5 +154,8 @@ func (m *Meta) Duplicate() *Meta {
 		Deleted:   m.Deleted,
 		secret:    m.secret,
 		cronjewel: m.cronjewel,
+
+		readPermission:  m.readPermission,
+		writePermission: m.writePermission,
 	}
 }
@@ -18,6 +18,9 @@ type HashMap struct {
 	name   string
 	db     map[string]record.Record
 	dbLock sync.RWMutex
+
+	// wal is the write-ahead log of persistent hashmaps.
+	wal *writeAheadLog
 }
 
 func init() {
@@ -61,6 +64,10 @@ func (hm *HashMap) Put(r record.Record) (record.Record, error) {
 	hm.dbLock.Lock()
 	defer hm.dbLock.Unlock()
 
+	if err := hm.logPut(r); err != nil {
+		return nil, err
+	}
+
 	hm.db[r.DatabaseKey()] = r
 	return r, nil
 }
@@ -77,16 +84,21 @@ func (hm *HashMap) PutMany(shadowDelete bool) (chan<- record.Record, <-chan erro
 
 	// start handler
 	go func() {
+		// Keep consuming the batch after an error, so that the caller does not
+		// block, and report the first error at the end.
+		var err error
 		for r := range batch {
-			hm.batchPutOrDelete(shadowDelete, r)
+			if batchErr := hm.batchPutOrDelete(shadowDelete, r); batchErr != nil && err == nil {
+				err = batchErr
+			}
 		}
-		errs <- nil
+		errs <- err
 	}()
 
 	return batch, errs
 }
 
-func (hm *HashMap) batchPutOrDelete(shadowDelete bool, r record.Record) {
+func (hm *HashMap) batchPutOrDelete(shadowDelete bool, r record.Record) error {
 	r.Lock()
 	defer r.Unlock()
 
@@ -94,10 +106,18 @@ func (hm *HashMap) batchPutOrDelete(shadowDelete bool, r record.Record) {
 	defer hm.dbLock.Unlock()
 
 	if !shadowDelete && r.Meta().IsDeleted() {
+		if err := hm.logDelete(r.DatabaseKey()); err != nil {
+			return err
+		}
 		delete(hm.db, r.DatabaseKey())
 	} else {
+		if err := hm.logPut(r); err != nil {
+			return err
+		}
 		hm.db[r.DatabaseKey()] = r
 	}
+
+	return nil
 }
 
 // Delete deletes a record from the database.
@@ -105,6 +125,10 @@ func (hm *HashMap) Delete(key string) error {
 	hm.dbLock.Lock()
 	defer hm.dbLock.Unlock()
 
+	if err := hm.logDelete(key); err != nil {
+		return err
+	}
+
 	delete(hm.db, key)
 	return nil
 }
@@ -194,7 +218,11 @@ func (hm *HashMap) MaintainRecordStates(ctx context.Context, purgeDeletedBefore
 				// mark as deleted
 				record.Lock()
 				meta.Deleted = meta.Expires
+				err := hm.logPut(record)
 				record.Unlock()
+				if err != nil {
+					return err
+				}
 
 				continue
 			}
@@ -203,6 +231,9 @@ func (hm *HashMap) MaintainRecordStates(ctx context.Context, purgeDeletedBefore
 			fallthrough
 		case meta.Deleted > 0 && (!shadowDelete || meta.Deleted < purgeThreshold):
 			// delete from storage
+			if err := hm.logDelete(key); err != nil {
+				return err
+			}
 			delete(hm.db, key)
 		}
 	}
@@ -210,7 +241,28 @@ func (hm *HashMap) MaintainRecordStates(ctx context.Context, purgeDeletedBefore
 	return nil
 }
 
+// Maintain runs a light maintenance operation on the database.
+// For persistent hashmaps, this compacts the write-ahead log.
+func (hm *HashMap) Maintain(ctx context.Context) error {
+	hm.dbLock.Lock()
+	defer hm.dbLock.Unlock()
+
+	return hm.compact(ctx)
+}
+
+// MaintainThorough runs a thorough maintenance operation on the database.
+// For persistent hashmaps, this compacts the write-ahead log.
+func (hm *HashMap) MaintainThorough(ctx context.Context) error {
+	return hm.Maintain(ctx)
+}
+
 // Shutdown shuts down the database.
 func (hm *HashMap) Shutdown() error {
+	hm.dbLock.Lock()
+	defer hm.dbLock.Unlock()
+
+	if hm.wal != nil {
+		return hm.wal.close()
+	}
 	return nil
 }
@@ -0,0 +1,281 @@
+package hashmap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/database/storage"
+	"github.com/safing/portbase/formats/varint"
+	"github.com/safing/portbase/log"
+)
+
+const (
+	walFileName    = "wal.log"
+	walTmpFileName = "wal.log.tmp"
+
+	walOpPut    uint8 = 1
+	walOpDelete uint8 = 2
+
+	walChecksumSize = 4
+)
+
+// writeAheadLog persists all changes of a hashmap to disk by appending them
+// to a log file. Entries are written to the OS, but not synced, on every
+// change. This survives a crash of the process, but may lose the latest
+// changes on a power loss. The log is synced and compacted in Maintain and
+// on shutdown.
+//
+// Every entry has the format:
+// [varint body length][body][crc32 of body (4 bytes)]
+// With the body being:
+// [op][varint key length][key][record data (puts only)]
+type writeAheadLog struct {
+	dir  string
+	file *os.File
+}
+
+func init() {
+	_ = storage.Register("hashmap-persistent", NewPersistentHashMap)
+}
+
+// NewPersistentHashMap creates a hashmap database that persists all changes
+// to a write-ahead log within the given location. Existing data is replayed
+// from the log on start.
+func NewPersistentHashMap(name, location string) (storage.Interface, error) {
+	hm := &HashMap{
+		name: name,
+		db:   make(map[string]record.Record),
+		wal: &writeAheadLog{
+			dir: location,
+		},
+	}
+
+	// Replay existing log.
+	validSize, err := hm.wal.replay(hm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cut off any corrupted or incomplete entries at the end.
+	if info, err := os.Stat(hm.wal.path()); err == nil && info.Size() > validSize {
+		if err := os.Truncate(hm.wal.path(), validSize); err != nil {
+			return nil, fmt.Errorf("failed to truncate write-ahead log: %w", err)
+		}
+	}
+
+	// Open log for appending.
+	if err := hm.wal.open(); err != nil {
+		return nil, err
+	}
+
+	return hm, nil
+}
+
+func (wal *writeAheadLog) path() string {
+	return filepath.Join(wal.dir, walFileName)
+}
+
+// replay loads all entries of the log into the given hashmap and returns the
+// size of the valid part of the log.
+func (wal *writeAheadLog) replay(hm *HashMap) (validSize int64, err error) {
+	data, err := os.ReadFile(wal.path())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read write-ahead log: %w", err)
+	}
+
+	var offset int
+	for offset < len(data) {
+		body, n, err := varint.GetNextBlock(data[offset:])
+		if err != nil || offset+n+walChecksumSize > len(data) {
+			log.Warningf("database/storage: discarding incomplete end of write-ahead log of hashmap database %s at offset %d", hm.name, offset)
+			break
+		}
+		checksum := binary.BigEndian.Uint32(data[offset+n : offset+n+walChecksumSize])
+		if checksum != crc32.ChecksumIEEE(body) {
+			log.Warningf("database/storage: discarding corrupted end of write-ahead log of hashmap database %s at offset %d", hm.name, offset)
+			break
+		}
+
+		if err := hm.applyWALEntry(body); err != nil {
+			log.Warningf("database/storage: discarding end of write-ahead log of hashmap database %s at offset %d: %s", hm.name, offset, err)
+			break
+		}
+		offset += n + walChecksumSize
+	}
+
+	return int64(offset), nil
+}
+
+// applyWALEntry applies a single entry body of the log to the hashmap.
+func (hm *HashMap) applyWALEntry(body []byte) error {
+	if len(body) == 0 {
+		return errors.New("empty entry")
+	}
+	op := body[0]
+	key, n, err := varint.GetNextBlock(body[1:])
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+
+	switch op {
+	case walOpPut:
+		r, err := record.NewRawWrapper(hm.name, string(key), body[1+n:])
+		if err != nil {
+			return fmt.Errorf("failed to parse record %s: %w", key, err)
+		}
+		hm.db[string(key)] = r
+	case walOpDelete:
+		delete(hm.db, string(key))
+	default:
+		return fmt.Errorf("unknown operation %d", op)
+	}
+
+	return nil
+}
+
+// encodeWALEntry returns the log entry for the given operation.
+func encodeWALEntry(op uint8, key string, data []byte) []byte {
+	body := make([]byte, 0, 1+varint.EncodedSize(uint64(len(key)))+len(key)+len(data))
+	body = append(body, op)
+	body = append(body, varint.PrependLength([]byte(key))...)
+	body = append(body, data...)
+
+	entry := varint.PrependLength(body)
+	return binary.BigEndian.AppendUint32(entry, crc32.ChecksumIEEE(body))
+}
+
+// logPut appends a put of the given record to the log. The record must be
+// locked.
+func (hm *HashMap) logPut(r record.Record) error {
+	if hm.wal == nil {
+		return nil
+	}
+
+	data, err := r.MarshalRecord(r)
+	if err != nil {
+		return err
+	}
+	return hm.wal.write(encodeWALEntry(walOpPut, r.DatabaseKey(), data))
+}
+
+// logDelete appends a delete of the given key to the log.
+func (hm *HashMap) logDelete(key string) error {
+	if hm.wal == nil {
+		return nil
+	}
+
+	return hm.wal.write(encodeWALEntry(walOpDelete, key, nil))
+}
+
+func (wal *writeAheadLog) write(entry []byte) error {
+	if wal.file == nil {
+		return errors.New("write-ahead log is closed")
+	}
+
+	// Write the entry with a single call in order to minimize the chance of
+	// incomplete entries.
+	if _, err := wal.file.Write(entry); err != nil {
+		return fmt.Errorf("failed to write to write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the log with the current state of the hashmap. The
+// database lock must be held.
+func (hm *HashMap) compact(ctx context.Context) error {
+	if hm.wal == nil || hm.wal.file == nil {
+		return nil
+	}
+
+	// Write the current state to a temporary file.
+	tmpPath := filepath.Join(hm.wal.dir, walTmpFileName)
+	tmpFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o0600)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted write-ahead log: %w", err)
+	}
+	err = hm.writeSnapshot(ctx, tmpFile)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	// Replace the log with the compacted one.
+	_ = hm.wal.file.Close()
+	hm.wal.file = nil
+	if err := os.Rename(tmpPath, hm.wal.path()); err != nil {
+		_ = os.Remove(tmpPath)
+		// Continue with the existing log.
+		if reopenErr := hm.wal.open(); reopenErr != nil {
+			return fmt.Errorf("failed to replace write-ahead log: %w (and failed to reopen existing log: %s)", err, reopenErr)
+		}
+		return fmt.Errorf("failed to replace write-ahead log: %w", err)
+	}
+
+	return hm.wal.open()
+}
+
+// writeSnapshot writes put entries for all records to the given file.
+func (hm *HashMap) writeSnapshot(ctx context.Context, file *os.File) error {
+	for _, r := range hm.db {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		r.Lock()
+		data, err := r.MarshalRecord(r)
+		key := r.DatabaseKey()
+		r.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to marshal record %s: %w", key, err)
+		}
+
+		if _, err := file.Write(encodeWALEntry(walOpPut, key, data)); err != nil {
+			return fmt.Errorf("failed to write compacted write-ahead log: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// open opens the log for appending.
+func (wal *writeAheadLog) open() error {
+	file, err := os.OpenFile(wal.path(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o0600)
+	if err != nil {
+		return fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	wal.file = file
+	return nil
+}
+
+// close syncs and closes the log.
+func (wal *writeAheadLog) close() error {
+	if wal.file == nil {
+		return nil
+	}
+
+	syncErr := wal.file.Sync()
+	closeErr := wal.file.Close()
+	wal.file = nil
+
+	if syncErr != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %w", syncErr)
+	}
+	return closeErr
+}
@@ -0,0 +1,147 @@
+package hashmap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/database/storage"
+)
+
+var (
+	// Compile time interface checks.
+	_ storage.Maintainer = &HashMap{}
+)
+
+func newPersistentTestRecord(key, s string) *TestRecord {
+	r := &TestRecord{S: s}
+	r.SetKey(key)
+	r.CreateMeta()
+	return r
+}
+
+func TestPersistentHashMap(t *testing.T) {
+	t.Parallel()
+
+	location := t.TempDir()
+
+	// start
+	db, err := NewPersistentHashMap("test", location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// write some records
+	for _, r := range []*TestRecord{
+		newPersistentTestRecord("test:A", "A1"),
+		newPersistentTestRecord("test:B", "B1"),
+		newPersistentTestRecord("test:A", "A2"),
+		newPersistentTestRecord("test:C", "C1"),
+	} {
+		if _, err := db.Put(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("B"); err != nil {
+		t.Fatal(err)
+	}
+
+	// batch write
+	batch, errs := db.(storage.Batcher).PutMany(false)
+	batch <- newPersistentTestRecord("test:D", "D1")
+	deleted := newPersistentTestRecord("test:C", "C2")
+	deleted.Meta().Delete()
+	batch <- deleted
+	close(batch)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash: do not shut down and append an incomplete entry.
+	logFile, err := os.OpenFile(filepath.Join(location, walFileName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	incomplete := encodeWALEntry(walOpDelete, "A", nil)
+	if _, err := logFile.Write(incomplete[:len(incomplete)-1]); err != nil {
+		t.Fatal(err)
+	}
+	_ = logFile.Close()
+
+	// restart and check contents
+	db, err = NewPersistentHashMap("test", location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPersistentHashMap(t, db)
+
+	// compact and check size
+	logPath := filepath.Join(location, walFileName)
+	sizeBefore := fileSize(t, logPath)
+	if err := db.(storage.Maintainer).Maintain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sizeAfter := fileSize(t, logPath); sizeAfter >= sizeBefore {
+		t.Fatalf("compaction did not shrink log: %d -> %d", sizeBefore, sizeAfter)
+	}
+
+	// write after compaction
+	if _, err := db.Put(newPersistentTestRecord("test:E", "E1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart and check contents again
+	db, err = NewPersistentHashMap("test", location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPersistentHashMap(t, db)
+	if _, err := db.Get("E"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkPersistentHashMap(t *testing.T, db storage.Interface) {
+	t.Helper()
+
+	for key, expected := range map[string]string{
+		"A": "A2",
+		"D": "D1",
+	} {
+		r, err := db.Get(key)
+		if err != nil {
+			t.Fatalf("failed to get %s: %s", key, err)
+		}
+		got := &TestRecord{}
+		if err := record.Unwrap(r, got); err != nil {
+			t.Fatal(err)
+		}
+		if got.S != expected {
+			t.Fatalf("unexpected value for %s: %q", key, got.S)
+		}
+	}
+
+	for _, key := range []string{"B", "C"} {
+		if _, err := db.Get(key); err == nil {
+			t.Fatalf("%s should be deleted", key)
+		}
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
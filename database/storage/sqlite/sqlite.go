@@ -0,0 +1,518 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Register pure-Go sqlite driver.
+
+	"github.com/safing/portbase/database/iterator"
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/database/storage"
+	"github.com/safing/portbase/formats/dsd"
+	"github.com/safing/portbase/formats/varint"
+)
+
+// The record metadata is stored in separate columns, so that expiry, deletion
+// and key prefixes can be handled by SQL and the data can be inspected with
+// external tools. The value holds the record data without the dsd format
+// byte, which is stored in the format column.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS records (
+		key              TEXT    NOT NULL PRIMARY KEY,
+		created          INTEGER NOT NULL DEFAULT 0,
+		modified         INTEGER NOT NULL DEFAULT 0,
+		expires          INTEGER NOT NULL DEFAULT 0,
+		deleted          INTEGER NOT NULL DEFAULT 0,
+		secret           INTEGER NOT NULL DEFAULT 0,
+		crownjewel       INTEGER NOT NULL DEFAULT 0,
+		read_permission  INTEGER NOT NULL DEFAULT 0,
+		write_permission INTEGER NOT NULL DEFAULT 0,
+		format           INTEGER NOT NULL DEFAULT 0,
+		value            BLOB
+	)`,
+	`CREATE INDEX IF NOT EXISTS records_expires ON records (expires)`,
+	`CREATE INDEX IF NOT EXISTS records_deleted ON records (deleted)`,
+}
+
+const (
+	metaColumns   = "key, created, modified, expires, deleted, secret, crownjewel, read_permission, write_permission"
+	recordColumns = metaColumns + ", format, value"
+)
+
+// SQLite database made pluggable for portbase.
+type SQLite struct {
+	name string
+	db   *sql.DB
+}
+
+// execer is implemented by sql.DB and sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// scanner is implemented by sql.Row and sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func init() {
+	_ = storage.Register("sqlite", NewSQLite)
+}
+
+// NewSQLite opens/creates a sqlite database.
+func NewSQLite(name, location string) (storage.Interface, error) {
+	dbFile := filepath.Join(location, "db.sqlite")
+	dsn := "file:" + dbFile +
+		"?_pragma=busy_timeout(5000)" +
+		"&_pragma=journal_mode(WAL)" +
+		"&_pragma=synchronous(NORMAL)"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create table and indexes.
+	for _, statement := range schema {
+		if _, err := db.Exec(statement); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to create schema: %w", err)
+		}
+	}
+
+	return &SQLite{
+		name: name,
+		db:   db,
+	}, nil
+}
+
+// Get returns a database record.
+func (s *SQLite) Get(key string) (record.Record, error) {
+	row := s.db.QueryRow("SELECT "+recordColumns+" FROM records WHERE key = ?", key)
+	r, err := s.scanRecord(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetMeta returns the metadata of a database record.
+func (s *SQLite) GetMeta(key string) (*record.Meta, error) {
+	row := s.db.QueryRow("SELECT "+metaColumns+" FROM records WHERE key = ?", key)
+	meta, err := scanMeta(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return meta, nil
+}
+
+// Put stores a record in the database.
+func (s *SQLite) Put(r record.Record) (record.Record, error) {
+	if err := putRecord(s.db, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// PutMany stores many records in the database.
+func (s *SQLite) PutMany(shadowDelete bool) (chan<- record.Record, <-chan error) {
+	batch := make(chan record.Record, 100)
+	errs := make(chan error, 1)
+
+	go func() {
+		tx, err := s.db.Begin()
+
+		// Keep consuming the batch after an error, so that the caller does not
+		// block, and report the first error at the end.
+		for r := range batch {
+			if err == nil {
+				err = batchPutOrDelete(tx, shadowDelete, r)
+			}
+		}
+
+		switch {
+		case tx == nil:
+			errs <- err
+		case err != nil:
+			_ = tx.Rollback()
+			errs <- err
+		default:
+			errs <- tx.Commit()
+		}
+	}()
+
+	return batch, errs
+}
+
+func batchPutOrDelete(tx *sql.Tx, shadowDelete bool, r record.Record) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if !shadowDelete && r.Meta().IsDeleted() {
+		// Immediate delete.
+		_, err := tx.Exec("DELETE FROM records WHERE key = ?", r.DatabaseKey())
+		return err
+	}
+
+	// Put or shadow delete.
+	return putRecord(tx, r)
+}
+
+// putRecord inserts or replaces the given record.
+func putRecord(ex execer, r record.Record) error {
+	meta := r.Meta()
+	if meta == nil {
+		return errors.New("missing meta")
+	}
+
+	// Marshal record data. Wrapped records must keep their format.
+	format := uint8(dsd.JSON)
+	if r.IsWrapped() {
+		format = dsd.AUTO
+	}
+	data, err := r.Marshal(r, format)
+	if err != nil {
+		return err
+	}
+
+	// Split off the format, which is stored in its own column.
+	var (
+		dataFormat uint8 = dsd.RAW
+		value      []byte
+	)
+	if len(data) > 0 {
+		var n int
+		dataFormat, n, err = varint.Unpack8(data)
+		if err != nil {
+			return fmt.Errorf("could not get dsd format: %w", err)
+		}
+		value = data[n:]
+	}
+
+	readPermission, writePermission := meta.RequiredPermissions()
+	_, err = ex.Exec(
+		"INSERT OR REPLACE INTO records ("+recordColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		r.DatabaseKey(),
+		meta.Created,
+		meta.Modified,
+		meta.Expires,
+		meta.Deleted,
+		meta.IsSecret(),
+		meta.IsCrownJewel(),
+		readPermission,
+		writePermission,
+		dataFormat,
+		value,
+	)
+	return err
+}
+
+// Delete deletes a record from the database.
+func (s *SQLite) Delete(key string) error {
+	_, err := s.db.Exec("DELETE FROM records WHERE key = ?", key)
+	return err
+}
+
+// Query returns a an iterator for the supplied query.
+func (s *SQLite) Query(q *query.Query, local, internal bool) (*iterator.Iterator, error) {
+	_, err := q.Check()
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+
+	queryIter := iterator.New()
+
+	go s.queryExecutor(queryIter, q, local, internal)
+	return queryIter, nil
+}
+
+func (s *SQLite) queryExecutor(queryIter *iterator.Iterator, q *query.Query, local, internal bool) {
+	queryIter.Finish(s.executeQuery(queryIter, q, local, internal))
+}
+
+func (s *SQLite) executeQuery(queryIter *iterator.Iterator, q *query.Query, local, internal bool) error {
+	// Select valid and permitted records with the key prefix.
+	where, args := buildConditions(q.DatabaseKeyPrefix(), local, internal)
+	where = append(where, "deleted <= 0", "(expires <= 0 OR expires >= ?)")
+	args = append(args, time.Now().Unix())
+
+	rows, err := s.db.Query(
+		"SELECT "+recordColumns+" FROM records WHERE "+strings.Join(where, " AND ")+" ORDER BY key",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		r, err := s.scanRecord(rows)
+		if err != nil {
+			return err
+		}
+
+		// Check the query conditions.
+		if !q.MatchesRecord(r) {
+			continue
+		}
+
+		select {
+		case <-queryIter.Done:
+			return nil
+		case queryIter.Next <- r:
+		default:
+			select {
+			case <-queryIter.Done:
+				return nil
+			case queryIter.Next <- r:
+			case <-time.After(1 * time.Second):
+				return errors.New("query timeout")
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+// ReadOnly returns whether the database is read only.
+func (s *SQLite) ReadOnly() bool {
+	return false
+}
+
+// Injected returns whether the database is injected.
+func (s *SQLite) Injected() bool {
+	return false
+}
+
+// MaintainRecordStates maintains records states in the database.
+func (s *SQLite) MaintainRecordStates(ctx context.Context, purgeDeletedBefore time.Time, shadowDelete bool) error {
+	now := time.Now().Unix()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if shadowDelete {
+		// Mark expired records as deleted.
+		_, err = tx.ExecContext(ctx,
+			"UPDATE records SET deleted = expires, format = ?, value = NULL WHERE deleted = 0 AND expires > 0 AND expires < ?",
+			dsd.RAW, now,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Purge records that were deleted before the threshold.
+		_, err = tx.ExecContext(ctx,
+			"DELETE FROM records WHERE deleted > 0 AND deleted < ?",
+			purgeDeletedBefore.Unix(),
+		)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Immediately delete expired and deleted records.
+		_, err = tx.ExecContext(ctx,
+			"DELETE FROM records WHERE deleted > 0 OR (deleted = 0 AND expires > 0 AND expires < ?)",
+			now,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Purge deletes all records that match the given query. It returns the number of successful deletes and an error.
+func (s *SQLite) Purge(ctx context.Context, q *query.Query, local, internal, shadowDelete bool) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Collect the keys of all permitted and not yet deleted records that match
+	// the query.
+	keys, err := s.matchingKeys(ctx, tx, q, local, internal)
+	if err != nil {
+		return 0, err
+	}
+
+	// Delete records.
+	var stmt *sql.Stmt
+	if shadowDelete {
+		stmt, err = tx.PrepareContext(ctx, "UPDATE records SET deleted = ?, format = ?, value = NULL WHERE key = ?")
+	} else {
+		stmt, err = tx.PrepareContext(ctx, "DELETE FROM records WHERE key = ?")
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	now := time.Now().Unix()
+	for _, key := range keys {
+		if shadowDelete {
+			_, err = stmt.ExecContext(ctx, now, dsd.RAW, key)
+		} else {
+			_, err = stmt.ExecContext(ctx, key)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+func (s *SQLite) matchingKeys(ctx context.Context, tx *sql.Tx, q *query.Query, local, internal bool) ([]string, error) {
+	where, args := buildConditions(q.DatabaseKeyPrefix(), local, internal)
+	where = append(where, "deleted <= 0")
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT "+recordColumns+" FROM records WHERE "+strings.Join(where, " AND "),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var keys []string
+	for rows.Next() {
+		r, err := s.scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		if q.MatchesRecord(r) {
+			keys = append(keys, r.DatabaseKey())
+		}
+	}
+
+	return keys, rows.Err()
+}
+
+// Shutdown shuts down the database.
+func (s *SQLite) Shutdown() error {
+	return s.db.Close()
+}
+
+// buildConditions returns the SQL conditions and arguments for selecting
+// records with the given key prefix that may be accessed with the given
+// scope.
+func buildConditions(prefix string, local, internal bool) (where []string, args []interface{}) {
+	where = []string{"key >= ?"}
+	args = []interface{}{prefix}
+	if upperBound, ok := prefixUpperBound(prefix); ok {
+		where = append(where, "key < ?")
+		args = append(args, upperBound)
+	}
+
+	if !local {
+		where = append(where, "crownjewel = 0")
+	}
+	if !internal {
+		where = append(where, "secret = 0")
+	}
+
+	return where, args
+}
+
+// prefixUpperBound returns the smallest key that is greater than all keys
+// with the given prefix. It returns false if there is no such key.
+func prefixUpperBound(prefix string) (string, bool) {
+	upper := []byte(prefix)
+	for i := len(upper) - 1; i >= 0; i-- {
+		if upper[i] < 0xFF {
+			upper[i]++
+			return string(upper[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+func (s *SQLite) scanRecord(row scanner) (*record.Wrapper, error) {
+	var (
+		key                                 string
+		created, modified, expires, deleted int64
+		secret, crownjewel                  bool
+		readPermission, writePermission     int8
+		format                              uint8
+		value                               []byte
+	)
+	err := row.Scan(
+		&key, &created, &modified, &expires, &deleted,
+		&secret, &crownjewel, &readPermission, &writePermission,
+		&format, &value,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := newMeta(created, modified, expires, deleted, secret, crownjewel, readPermission, writePermission)
+	return record.NewWrapper(s.name+":"+key, meta, format, value)
+}
+
+func scanMeta(row scanner) (*record.Meta, error) {
+	var (
+		key                                 string
+		created, modified, expires, deleted int64
+		secret, crownjewel                  bool
+		readPermission, writePermission     int8
+	)
+	err := row.Scan(
+		&key, &created, &modified, &expires, &deleted,
+		&secret, &crownjewel, &readPermission, &writePermission,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMeta(created, modified, expires, deleted, secret, crownjewel, readPermission, writePermission), nil
+}
+
+func newMeta(created, modified, expires, deleted int64, secret, crownjewel bool, readPermission, writePermission int8) *record.Meta {
+	meta := &record.Meta{
+		Created:  created,
+		Modified: modified,
+		Expires:  expires,
+		Deleted:  deleted,
+	}
+	if secret {
+		meta.MakeSecret()
+	}
+	if crownjewel {
+		meta.MakeCrownJewel()
+	}
+	meta.RequirePermissions(readPermission, writePermission)
+	return meta
+}
@@ -0,0 +1,305 @@
+package sqlite
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/safing/portbase/database/query"
+	"github.com/safing/portbase/database/record"
+	"github.com/safing/portbase/database/storage"
+)
+
+var (
+	// Compile time interface checks.
+	_ storage.Interface   = &SQLite{}
+	_ storage.Batcher     = &SQLite{}
+	_ storage.Purger      = &SQLite{}
+	_ storage.MetaHandler = &SQLite{}
+)
+
+type TestRecord struct { //nolint:maligned
+	record.Base
+	sync.Mutex
+	S    string
+	I    int
+	I8   int8
+	I16  int16
+	I32  int32
+	I64  int64
+	UI   uint
+	UI8  uint8
+	UI16 uint16
+	UI32 uint32
+	UI64 uint64
+	F32  float32
+	F64  float64
+	B    bool
+}
+
+func TestSQLite(t *testing.T) {
+	t.Parallel()
+
+	testDir, err := os.MkdirTemp("", "testing-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(testDir) // clean up
+	}()
+
+	// start
+	db, err := NewSQLite("test", testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &TestRecord{
+		S:    "banana",
+		I:    42,
+		I8:   42,
+		I16:  42,
+		I32:  42,
+		I64:  42,
+		UI:   42,
+		UI8:  42,
+		UI16: 42,
+		UI32: 42,
+		UI64: 42,
+		F32:  42.42,
+		F64:  42.42,
+		B:    true,
+	}
+	a.SetMeta(&record.Meta{})
+	a.Meta().Update()
+	a.SetKey("test:A")
+
+	// put record
+	_, err = db.Put(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get and compare
+	r1, err := db.Get("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a1 := &TestRecord{}
+	err = record.Unwrap(r1, a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(a, a1) {
+		t.Fatalf("mismatch, got %v", a1)
+	}
+
+	// setup query test records
+	qA := &TestRecord{}
+	qA.SetKey("test:path/to/A")
+	qA.CreateMeta()
+	qB := &TestRecord{}
+	qB.SetKey("test:path/to/B")
+	qB.CreateMeta()
+	qC := &TestRecord{}
+	qC.SetKey("test:path/to/C")
+	qC.CreateMeta()
+	qZ := &TestRecord{}
+	qZ.SetKey("test:z")
+	qZ.CreateMeta()
+	// put
+	_, err = db.Put(qA)
+	if err == nil {
+		_, err = db.Put(qB)
+	}
+	if err == nil {
+		_, err = db.Put(qC)
+	}
+	if err == nil {
+		_, err = db.Put(qZ)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test query
+	q := query.New("test:path/to/").MustBeValid()
+	it, err := db.Query(q, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnt := 0
+	for range it.Next {
+		cnt++
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if cnt != 3 {
+		t.Fatalf("unexpected query result count: %d", cnt)
+	}
+
+	// delete
+	err = db.Delete("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check if its gone
+	_, err = db.Get("A")
+	if err == nil {
+		t.Fatal("should fail")
+	}
+
+	// maintenance
+	err = db.MaintainRecordStates(context.TODO(), time.Now(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// maintenance
+	err = db.MaintainRecordStates(context.TODO(), time.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// purging
+	purger, ok := db.(storage.Purger)
+	if ok {
+		n, err := purger.Purge(context.TODO(), query.New("test:path/to/").MustBeValid(), true, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Fatalf("unexpected purge delete count: %d", n)
+		}
+	} else {
+		t.Fatal("should implement Purger")
+	}
+
+	// test query
+	q = query.New("test").MustBeValid()
+	it, err = db.Query(q, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnt = 0
+	for range it.Next {
+		cnt++
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	if cnt != 1 {
+		t.Fatalf("unexpected query result count: %d", cnt)
+	}
+
+	// shutdown
+	err = db.Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSQLiteMeta(t *testing.T) {
+	t.Parallel()
+
+	// start
+	db, err := NewSQLite("test", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// put records in a batch
+	secret := &TestRecord{S: "secret"}
+	secret.SetKey("test:meta/secret")
+	secret.CreateMeta()
+	secret.Meta().MakeSecret()
+	secret.Meta().RequirePermissions(2, 3)
+	expired := &TestRecord{S: "expired"}
+	expired.SetKey("test:meta/expired")
+	expired.CreateMeta()
+	expired.Meta().SetAbsoluteExpiry(time.Now().Add(-time.Hour).Unix())
+	valid := &TestRecord{S: "valid"}
+	valid.SetKey("test:meta/valid")
+	valid.CreateMeta()
+
+	batch, errs := db.(storage.Batcher).PutMany(false)
+	batch <- secret
+	batch <- expired
+	batch <- valid
+	close(batch)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	// check meta columns
+	meta, err := db.(storage.MetaHandler).GetMeta("meta/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsSecret() || meta.IsCrownJewel() {
+		t.Fatal("unexpected secret or crownjewel state")
+	}
+	if read, write := meta.RequiredPermissions(); read != 2 || write != 3 {
+		t.Fatalf("unexpected permissions: %d/%d", read, write)
+	}
+
+	// query skips expired records and secret records for non-internal access
+	countQuery := func(internal bool) int {
+		it, err := db.Query(query.New("test:meta/").MustBeValid(), true, internal)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cnt := 0
+		for range it.Next {
+			cnt++
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		return cnt
+	}
+	if cnt := countQuery(true); cnt != 2 {
+		t.Fatalf("unexpected internal query result count: %d", cnt)
+	}
+	if cnt := countQuery(false); cnt != 1 {
+		t.Fatalf("unexpected external query result count: %d", cnt)
+	}
+
+	// maintenance marks expired record as deleted
+	err = db.MaintainRecordStates(context.TODO(), time.Now().Add(-time.Hour), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err = db.(storage.MetaHandler).GetMeta("meta/expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsDeleted() {
+		t.Fatal("expired record should be marked as deleted")
+	}
+
+	// shadow purge
+	n, err := db.(storage.Purger).Purge(context.TODO(), query.New("test:meta/").MustBeValid(), true, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("unexpected purge delete count: %d", n)
+	}
+	if cnt := countQuery(true); cnt != 0 {
+		t.Fatalf("unexpected query result count after purge: %d", cnt)
+	}
+
+	// shutdown
+	err = db.Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
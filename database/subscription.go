@@ -10,6 +10,7 @@ type Subscription struct {
 	q        *query.Query
 	local    bool
 	internal bool
+	options  *Options
 
 	Feed chan record.Record
 }
@@ -43,3 +43,24 @@ func TestMimeTypes(t *testing.T) {
 		assert.Equal(t, format, derivedFormat, "assumption for %q should hold", accept)
 	}
 }
+
+func TestMimeDump(t *testing.T) {
+	t.Parallel()
+
+	// The mime type is the one of the chosen format, not of the accept header.
+	for accept, format := range map[string]uint8{
+		"application/json, image/webp": JSON,
+		"text/yAMl":                    YAML,
+		"*/*":                          DefaultSerializationFormat,
+		"":                             DefaultSerializationFormat,
+	} {
+		data, mimeType, derivedFormat, err := MimeDump(&SimpleTestStruct{S: "test", B: 1}, accept)
+		assert.NoError(t, err, accept)
+		assert.NotEmpty(t, data, accept)
+		assert.Equal(t, format, derivedFormat, accept)
+		assert.Equal(t, FormatToMimeType[format], mimeType, accept)
+	}
+
+	_, _, _, err := MimeDump(&SimpleTestStruct{}, "text/xml")
+	assert.ErrorIs(t, err, ErrIncompatibleFormat)
+}
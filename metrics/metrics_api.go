@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/log"
+)
+
+var (
+	apiRequestDurations = make(map[string]*Histogram)
+	apiRequestCounters  = make(map[string]*Counter)
+	apiDatabaseCounters = make(map[string]*Counter)
+	apiMetricsLock      sync.Mutex
+)
+
+func registerAPIMetrics() error {
+	api.ObserveRequests(observeAPIRequest)
+	api.ObserveDatabaseOperations(observeAPIDatabaseOperation)
+	return nil
+}
+
+func apiMetricOpts(name string) *Options {
+	return &Options{
+		Name:           name,
+		Permission:     api.PermitUser,
+		ExpertiseLevel: config.ExpertiseLevelDeveloper,
+	}
+}
+
+func observeAPIRequest(route string, status int, duration time.Duration) {
+	// Label requests by their route template to keep cardinality bounded.
+	if route == "" {
+		route = "unmatched"
+	}
+	statusCode := strconv.Itoa(status)
+
+	apiMetricsLock.Lock()
+	defer apiMetricsLock.Unlock()
+
+	// Get or create metrics of the route.
+	histogram, ok := apiRequestDurations[route]
+	if !ok {
+		var err error
+		histogram, err = NewHistogram(
+			"api/request/duration/seconds",
+			map[string]string{
+				"route": route,
+			},
+			apiMetricOpts("API Request Duration"),
+		)
+		if err != nil {
+			log.Warningf("metrics: failed to register api request duration metric of %s: %s", route, err)
+			return
+		}
+		apiRequestDurations[route] = histogram
+	}
+	counter, ok := apiRequestCounters[route+" "+statusCode]
+	if !ok {
+		var err error
+		counter, err = NewCounter(
+			"api/request/total",
+			map[string]string{
+				"route":  route,
+				"status": statusCode,
+			},
+			apiMetricOpts("API Requests"),
+		)
+		if err != nil {
+			log.Warningf("metrics: failed to register api request metric of %s: %s", route, err)
+			return
+		}
+		apiRequestCounters[route+" "+statusCode] = counter
+	}
+
+	histogram.Update(duration.Seconds())
+	counter.Inc()
+}
+
+func observeAPIDatabaseOperation(command string) {
+	apiMetricsLock.Lock()
+	defer apiMetricsLock.Unlock()
+
+	counter, ok := apiDatabaseCounters[command]
+	if !ok {
+		var err error
+		counter, err = NewCounter(
+			"api/database/operations/total",
+			map[string]string{
+				"command": command,
+			},
+			apiMetricOpts("Database API Operations"),
+		)
+		if err != nil {
+			log.Warningf("metrics: failed to register database api operation metric of %s: %s", command, err)
+			return
+		}
+		apiDatabaseCounters[command] = counter
+	}
+
+	counter.Inc()
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"github.com/safing/portbase/api"
+	"github.com/safing/portbase/config"
+	"github.com/safing/portbase/database"
+	"github.com/safing/portbase/log"
+)
+
+func registerDatabaseMetrics() error {
+	database.ObserveCachedInterfaces(func(i *database.Interface) {
+		if err := registerDatabaseCacheMetrics(i); err != nil {
+			log.Warningf("metrics: failed to register cache metrics of database interface %s: %s", i.Name(), err)
+		}
+	})
+
+	return nil
+}
+
+func registerDatabaseCacheMetrics(i *database.Interface) (err error) {
+	labels := map[string]string{
+		"interface": i.Name(),
+	}
+	opts := func(name string) *Options {
+		return &Options{
+			Name:           name,
+			Permission:     api.PermitUser,
+			ExpertiseLevel: config.ExpertiseLevelDeveloper,
+		}
+	}
+
+	_, err = NewFetchingCounter(
+		"database/cache/hits/total",
+		labels,
+		func() uint64 {
+			return i.CacheStats().Hits
+		},
+		opts("Database Cache Hits"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = NewFetchingCounter(
+		"database/cache/misses/total",
+		labels,
+		func() uint64 {
+			return i.CacheStats().Misses
+		},
+		opts("Database Cache Misses"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = NewFetchingCounter(
+		"database/cache/evictions/total",
+		labels,
+		func() uint64 {
+			return i.CacheStats().Evictions
+		},
+		opts("Database Cache Evictions"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = NewGauge(
+		"database/cache/entries",
+		labels,
+		func() float64 {
+			return float64(i.CacheStats().Entries)
+		},
+		opts("Database Cache Entries"),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Only register write cache metrics if writes are delayed.
+	if !i.CacheStats().DelayedWrites {
+		return nil
+	}
+
+	_, err = NewGauge(
+		"database/cache/writes/pending",
+		labels,
+		func() float64 {
+			return float64(i.CacheStats().PendingWrites)
+		},
+		opts("Database Cache Pending Writes"),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = NewFetchingCounter(
+		"database/cache/writes/flushed/total",
+		labels,
+		func() uint64 {
+			return i.CacheStats().FlushedWrites
+		},
+		opts("Database Cache Flushed Writes"),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -66,6 +66,14 @@ func start() error {
 		return err
 	}
 
+	if err := registerDatabaseMetrics(); err != nil {
+		return err
+	}
+
+	if err := registerAPIMetrics(); err != nil {
+		return err
+	}
+
 	if err := registerAPI(); err != nil {
 		return err
 	}